@@ -0,0 +1,354 @@
+// Detects archive and compression formats and walks over their content.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ArchiveFormat identifies the container or compression format of a file.
+type ArchiveFormat int
+
+const (
+	FormatPlain ArchiveFormat = iota
+	FormatTar
+	FormatXZ
+	FormatGzip
+	FormatBzip2
+	FormatZip
+)
+
+// Tar files carry their magic bytes at this offset in the first header.
+const tarMagicOffset = 257
+
+// countingReader counts the bytes that are read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements the io.Reader interface.
+func (cr *countingReader) Read(p []byte) (int, error) {
+
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+// archiveEntryInfo wraps the FileInfo of an archive entry.  It additionally
+// records at which byte offset the entry's content starts in the (decompressed)
+// archive.
+type archiveEntryInfo struct {
+	os.FileInfo
+	offset int64
+}
+
+// FileOffset returns the byte offset at which the content of the file with the
+// given FileInfo starts.  That's 0 for files that aren't part of an archive or
+// a stream.
+func FileOffset(info os.FileInfo) int64 {
+
+	switch v := info.(type) {
+	case *archiveEntryInfo:
+		return v.offset
+	case *streamDocumentInfo:
+		return v.offset
+	}
+
+	return 0
+}
+
+// WalkError is returned if we cannot walk over a file, e.g., because it's a
+// corrupt or truncated archive.
+type WalkError struct {
+	Path string
+	// Number of bytes we managed to read from the file before the error.
+	Offset int64
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *WalkError) Error() string {
+
+	return fmt.Sprintf("%s: %s (at byte offset %d)", e.Path, e.Err, e.Offset)
+}
+
+// magicBytes maps formats to the byte sequence that their files start with.
+var magicBytes = map[ArchiveFormat][]byte{
+	FormatXZ:    []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+	FormatGzip:  []byte{0x1f, 0x8b},
+	FormatBzip2: []byte{'B', 'Z', 'h'},
+	FormatZip:   []byte{'P', 'K', 0x03, 0x04},
+}
+
+// String implements the Stringer interface.
+func (f ArchiveFormat) String() string {
+
+	switch f {
+	case FormatTar:
+		return "tar"
+	case FormatXZ:
+		return "xz"
+	case FormatGzip:
+		return "gzip"
+	case FormatBzip2:
+		return "bzip2"
+	case FormatZip:
+		return "zip"
+	default:
+		return "plain"
+	}
+}
+
+// DetectFormat peeks at the beginning of the given reader and determines its
+// format by looking for magic bytes.  The reader's content is not consumed.
+// Anything we don't recognise is considered to be a plain document.
+func DetectFormat(r *bufio.Reader) ArchiveFormat {
+
+	// Peek returns an error if the stream is shorter than requested.  That's
+	// fine because we then simply have less bytes to look at.
+	head, _ := r.Peek(tarMagicOffset + 5)
+
+	for format, magic := range magicBytes {
+		if bytes.HasPrefix(head, magic) {
+			return format
+		}
+	}
+
+	if len(head) == tarMagicOffset+5 && string(head[tarMagicOffset:]) == "ustar" {
+		return FormatTar
+	}
+
+	return FormatPlain
+}
+
+// stripCompressionSuffix removes the given compression format's file name
+// suffix, so that, e.g., "consensuses-2015-08.tar.xz" becomes
+// "consensuses-2015-08.tar".
+func stripCompressionSuffix(name string, format ArchiveFormat) string {
+
+	switch format {
+	case FormatXZ:
+		return strings.TrimSuffix(name, ".xz")
+	case FormatGzip:
+		if strings.HasSuffix(name, ".tgz") {
+			return strings.TrimSuffix(name, ".tgz") + ".tar"
+		}
+		return strings.TrimSuffix(name, ".gz")
+	case FormatBzip2:
+		if strings.HasSuffix(name, ".tbz2") {
+			return strings.TrimSuffix(name, ".tbz2") + ".tar"
+		}
+		return strings.TrimSuffix(name, ".bz2")
+	}
+
+	return name
+}
+
+// decompress wraps the given reader in a decompressor for the given format.
+func decompress(r io.Reader, format ArchiveFormat) (io.Reader, error) {
+
+	switch format {
+	case FormatXZ:
+		return xzReader(r)
+	case FormatGzip:
+		return gzip.NewReader(r)
+	case FormatBzip2:
+		return bzip2.NewReader(r), nil
+	}
+
+	return r, nil
+}
+
+// Walk over the content of the given reader, whose format is determined by its
+// magic bytes.  Archives are walked entry by entry, compressed files are
+// decompressed, and everything else is passed to callback as is.
+func walkReader(name string, info os.FileInfo, r io.Reader, callback func(string, os.FileInfo, io.Reader) error) error {
+
+	br := bufio.NewReader(r)
+
+	switch format := DetectFormat(br); format {
+	case FormatTar:
+		return walkTar(br, callback)
+	case FormatZip:
+		return walkZipStream(br, callback)
+	case FormatXZ, FormatGzip, FormatBzip2:
+		dr, err := decompress(br, format)
+		if err != nil {
+			return err
+		}
+		if err = walkReader(stripCompressionSuffix(name, format), info, dr, callback); err != nil {
+			return err
+		}
+		// The callback might not have consumed the entire stream, e.g.,
+		// because the tar trailer ends early.  We consume the rest of the
+		// stream to give the decoder a chance to verify its checksums.
+		_, err = io.Copy(ioutil.Discard, dr)
+		return err
+	default:
+		return callback(name, info, br)
+	}
+}
+
+// Open the given file and walk over its content.  Zip archives on disk are
+// read directly rather than buffered in memory.  Named pipes and other files
+// that aren't regular are read as a stream of concatenated documents.  Errors
+// are returned as *WalkError.
+func walkFile(path string, info os.FileInfo, callback func(string, os.FileInfo, io.Reader) error) error {
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return &WalkError{path, 0, err}
+	}
+	defer fd.Close()
+
+	// The given FileInfo doesn't follow symlinks, e.g., /dev/stdin.
+	stat, err := fd.Stat()
+	if err != nil {
+		return &WalkError{path, 0, err}
+	}
+
+	cr := &countingReader{r: fd}
+	br := bufio.NewReader(cr)
+	if !stat.Mode().IsRegular() {
+		err = walkStream(path, br, callback)
+	} else if DetectFormat(br) == FormatZip {
+		err = walkZip(fd, stat.Size(), callback)
+	} else {
+		err = walkReader(path, info, br, callback)
+	}
+
+	if err != nil {
+		if _, ok := err.(*WalkError); ok {
+			return err
+		}
+		return &WalkError{path, cr.n, err}
+	}
+
+	return nil
+}
+
+// Walk over the entries of path, open each one, and pass it to callback.
+// Archives and compressed files that we come across are walked recursively,
+// so path can be, e.g., a directory containing monthly tarballs.  If we fail to
+// walk a file, the file's path and the error are passed to onError.  The walk
+// continues if onError returns nil, and is aborted otherwise.  If visited is
+// not nil, it's called with the path and FileInfo of every file on disk right
+// before we open it.  If path is "-", we read from stdin.
+func walkPath(path string, callback func(string, os.FileInfo, io.Reader) error, onError func(string, error) error, visited func(string, os.FileInfo)) error {
+
+	if path == stdinPath {
+		if err := walkStdin(callback); err != nil {
+			return onError("<stdin>", err)
+		}
+		return nil
+	}
+
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return onError(path, &WalkError{path, 0, err})
+		}
+
+		if info.IsDir() {
+			return callback(path, info, nil)
+		}
+
+		if visited != nil {
+			visited(path, info)
+		}
+
+		if err := walkFile(path, info, callback); err != nil {
+			return onError(path, err)
+		}
+
+		return nil
+	}
+
+	return filepath.Walk(path, walkFn)
+}
+
+// ExpandArchivePaths turns the given comma-separated list of files,
+// directories, tarballs, and glob patterns into a sorted list of unique paths.
+// The path "-" stands for stdin and is kept as is.  CollecTor names its files
+// and tarballs after their document type and the time period they cover, so
+// sorting paths by their base name puts the files of each document type in
+// chronological order, even if they are spread over several directories.
+// Files of different document types are not interleaved, e.g.,
+// "consensuses-2015-09.tar.xz" comes before "server-descriptors-2015-08.tar.xz".
+func ExpandArchivePaths(pathList string) ([]string, error) {
+
+	unique := make(map[string]bool)
+	for _, pattern := range strings.Split(pathList, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if pattern == stdinPath {
+			unique[stdinPath] = true
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern \"%s\": %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("\"%s\" does not match any file or directory.", pattern)
+		}
+
+		for _, match := range matches {
+			unique[filepath.Clean(match)] = true
+		}
+	}
+
+	paths := make([]string, 0, len(unique))
+	for path := range unique {
+		paths = append(paths, path)
+	}
+	sortDataPaths(paths)
+
+	return paths, nil
+}
+
+// sortDataPaths sorts the given paths by their base name, and paths with the
+// same base name by their directory.
+func sortDataPaths(paths []string) {
+
+	sort.Slice(paths, func(i, j int) bool {
+		baseI, baseJ := filepath.Base(paths[i]), filepath.Base(paths[j])
+		if baseI != baseJ {
+			return baseI < baseJ
+		}
+		return paths[i] < paths[j]
+	})
+}
+
+// Walk over the entries of all given paths, one after another, and pass them
+// to the same callback, so that analyses see one continuous stream across
+// archive boundaries.  Every path can be a plain document, an archive (tar or
+// zip), a compressed file (xz, gzip, or bzip2), or a directory containing any
+// of these.  Formats are detected by their magic bytes rather than by file
+// name suffix.  Errors are passed to onError, which decides whether to continue
+// or to abort the walk.  The optional visited function learns about every file
+// on disk that we open.
+func walkArchiveData(paths []string, callback func(string, os.FileInfo, io.Reader) error, onError func(string, error) error, visited func(string, os.FileInfo)) error {
+
+	for _, path := range paths {
+		if err := walkPath(path, callback, onError, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeTar returns a tarball containing the given files.
+func makeTar(t *testing.T, files map[string]string, names ...string) []byte {
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		content := files[name]
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// gzipBytes returns the gzip-compressed form of the given bytes.
+func gzipBytes(t *testing.T, blurb []byte) []byte {
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(blurb); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	zw.Create("consensus")
+	zw.Close()
+
+	tarball := makeTar(t, map[string]string{"consensus": "foo"}, "consensus")
+
+	tests := []struct {
+		name   string
+		blurb  []byte
+		format ArchiveFormat
+	}{
+		{"tar", tarball, FormatTar},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, FormatXZ},
+		{"gzip", gzipBytes(t, tarball), FormatGzip},
+		{"bzip2", []byte("BZh91AY&SY"), FormatBzip2},
+		{"zip", zipped.Bytes(), FormatZip},
+		{"plain", []byte("@type network-status-consensus-3 1.0\n"), FormatPlain},
+		{"empty", []byte{}, FormatPlain},
+	}
+
+	for _, test := range tests {
+		br := bufio.NewReader(bytes.NewReader(test.blurb))
+		if format := DetectFormat(br); format != test.format {
+			t.Errorf("%s: expected format %s but got %s.", test.name, test.format, format)
+		}
+		// Detection must not consume the content.
+		if rest, _ := ioutil.ReadAll(br); !bytes.Equal(rest, test.blurb) {
+			t.Errorf("%s: DetectFormat consumed content.", test.name)
+		}
+	}
+}
+
+func TestWalkReaderNested(t *testing.T) {
+
+	files := map[string]string{
+		"consensuses-2015-08/01/2015-08-01-00-00-00-consensus": "first",
+		"consensuses-2015-08/01/2015-08-01-01-00-00-consensus": "second",
+	}
+	inner := makeTar(t, files,
+		"consensuses-2015-08/01/2015-08-01-00-00-00-consensus",
+		"consensuses-2015-08/01/2015-08-01-01-00-00-consensus")
+	// A gzip-compressed tarball inside another tarball.
+	outer := makeTar(t, map[string]string{"consensuses-2015-08.tar.gz": string(gzipBytes(t, inner))}, "consensuses-2015-08.tar.gz")
+
+	var names, contents []string
+	callback := func(name string, info os.FileInfo, r io.Reader) error {
+		blurb, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		contents = append(contents, string(blurb))
+		return nil
+	}
+
+	if err := walkReader("outer.tar", nil, bytes.NewReader(outer), callback); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 2 {
+		t.Fatalf("Expected 2 files but got %d: %s", len(names), names)
+	}
+	for i, expected := range []string{"first", "second"} {
+		if contents[i] != expected {
+			t.Errorf("Expected content %q of %s but got %q.", expected, names[i], contents[i])
+		}
+	}
+}
+
+func TestStripCompressionSuffix(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		format   ArchiveFormat
+		expected string
+	}{
+		{"consensuses-2015-08.tar.xz", FormatXZ, "consensuses-2015-08.tar"},
+		{"consensuses-2015-08.tgz", FormatGzip, "consensuses-2015-08.tar"},
+		{"consensus.gz", FormatGzip, "consensus"},
+		{"consensuses-2015-08.tbz2", FormatBzip2, "consensuses-2015-08.tar"},
+		{"consensus", FormatPlain, "consensus"},
+	}
+
+	for _, test := range tests {
+		if name := stripCompressionSuffix(test.name, test.format); name != test.expected {
+			t.Errorf("Expected %q but got %q.", test.expected, name)
+		}
+	}
+}
+
+func TestExpandArchivePaths(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sybilhunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		"b/consensuses-2015-08.tar.xz",
+		"a/consensuses-2015-09.tar.xz",
+		"a/server-descriptors-2015-08.tar.xz",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Overlapping patterns must not yield duplicates.
+	pathList := filepath.Join(dir, "*", "*.tar.xz") + ", " + filepath.Join(dir, "a", "consensuses-2015-09.tar.xz")
+	paths, err := ExpandArchivePaths(pathList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "b/consensuses-2015-08.tar.xz"),
+		filepath.Join(dir, "a/consensuses-2015-09.tar.xz"),
+		filepath.Join(dir, "a/server-descriptors-2015-08.tar.xz"),
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %s but got %s.", expected, paths)
+	}
+
+	if _, err := ExpandArchivePaths(filepath.Join(dir, "*.zip")); err == nil {
+		t.Error("Expected error for pattern without matches.")
+	}
+}
@@ -0,0 +1,141 @@
+// Parses the sanitized bridge network statuses and bridge descriptors that
+// CollecTor publishes.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// CollecTor scrubs bridge addresses before publishing bridge documents.  It
+// replaces IP addresses with a keyed hash over the address and the bridge's
+// fingerprint, so a bridge that changes its fingerprint also gets a new
+// scrubbed address, even if its actual address stays the same.  Fingerprints
+// are replaced with their SHA-1 hash, which remains stable over time.
+//
+// As a result, we cannot group bridges by address.  Instead, we group them by
+// nickname and OR port, which CollecTor leaves intact.
+
+// BridgeNetworkStatus is a sanitized bridge network status, as published by
+// the bridge authority.  Bridge network statuses have no valid-after time, so
+// we use their publication time instead.  Analyses that only need router
+// statuses can use the embedded consensus.  The embedded status flags hold the
+// bridges' flags.
+type BridgeNetworkStatus struct {
+	*tor.Consensus
+	StatusFlags
+
+	// Fingerprint of the bridge authority that published the status.
+	Authority tor.Fingerprint
+}
+
+// NewBridgeNetworkStatus allocates and returns a new bridge network status.
+func NewBridgeNetworkStatus() *BridgeNetworkStatus {
+
+	return &BridgeNetworkStatus{Consensus: tor.NewConsensus(), StatusFlags: newStatusFlags()}
+}
+
+// Merge merges the given object set into the bridge network status.
+func (status *BridgeNetworkStatus) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*BridgeNetworkStatus)
+	if !ok {
+		return
+	}
+
+	status.Consensus.Merge(other.Consensus)
+	status.StatusFlags.merge(&other.StatusFlags)
+}
+
+// BridgeDescriptors is a set of sanitized bridge descriptors.  Except for the
+// scrubbed addresses and fingerprints, they are router descriptors.
+type BridgeDescriptors struct {
+	*tor.RouterDescriptors
+}
+
+// Merge merges the given object set into the bridge descriptors.
+func (descs *BridgeDescriptors) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*BridgeDescriptors)
+	if !ok {
+		return
+	}
+
+	descs.RouterDescriptors.Merge(other.RouterDescriptors)
+}
+
+// bridgeKey returns the key by which we group bridges with the given nickname
+// and OR port.
+func bridgeKey(nickname string, orPort uint16) string {
+
+	return fmt.Sprintf("%s:%d", nickname, orPort)
+}
+
+// ParseBridgeNetworkStatus parses a sanitized bridge network status.
+func ParseBridgeNetworkStatus(r io.Reader) (tor.ObjectSet, error) {
+
+	bridgeStatus := NewBridgeNetworkStatus()
+
+	err := parseStatusDocument(r, bridgeStatus.Consensus, &bridgeStatus.StatusFlags, parseRouterLine, func(status *tor.RouterStatus, words []string) error {
+		var err error
+		switch words[0] {
+		case "published":
+			if len(words) != 3 {
+				return fmt.Errorf("Malformed \"published\" line.")
+			}
+			bridgeStatus.ValidAfter, err = time.Parse(statusTimeLayout, words[1]+" "+words[2])
+		case "fingerprint":
+			if len(words) == 2 && status == nil {
+				bridgeStatus.Authority = tor.Fingerprint(strings.ToUpper(words[1]))
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if bridgeStatus.ValidAfter.IsZero() {
+		return nil, fmt.Errorf("Bridge network status has no \"published\" line.")
+	}
+
+	return bridgeStatus, nil
+}
+
+// ParseBridgeDescriptors parses sanitized bridge descriptors.  They follow the
+// format of router descriptors, so we let zoossh parse them as such.
+func ParseBridgeDescriptors(r io.Reader) (tor.ObjectSet, error) {
+
+	blurb, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the bridge descriptor's annotation with the one of a router
+	// descriptor.
+	if bytes.HasPrefix(blurb, []byte("@type")) {
+		if i := bytes.IndexByte(blurb, '\n'); i != -1 {
+			blurb = blurb[i+1:]
+		}
+	}
+	blurb = append([]byte(descAnnotation), blurb...)
+
+	objects, err := tor.ParseUnknown(bytes.NewReader(blurb))
+	if err != nil {
+		return nil, err
+	}
+
+	descs, ok := objects.(*tor.RouterDescriptors)
+	if !ok {
+		return nil, fmt.Errorf("Expected bridge descriptors but got %T.", objects)
+	}
+
+	return &BridgeDescriptors{descs}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// An excerpt of a sanitized bridge network status, as published by CollecTor.
+const bridgeStatusExcerpt = `@type bridge-network-status 1.2
+published 2019-05-01 00:04:03
+flag-thresholds stable-uptime=1159163 stable-mtbf=2367424 fast-speed=47000 guard-wfu=98.000% guard-tk=691200 guard-bw-inc-exits=1073000 guard-bw-exc-exits=1004000 enough-mtbf=1 ignoring-advertised-bws=0
+fingerprint BA44A889E64B93FAA2B114E02C2A279A8555C533
+r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 10.247.84.111 9001 0
+a [fd9f:2e19:3bcf::39:9aab]:9001
+s Fast Running Stable Valid
+w Bandwidth=55
+p reject 1-65535
+r CalyxInstitute14 ABG9JIWtRdmE7EFZyI/AZuXjMA4 g4tM4vNh0ojHEpw3V9nLDVqUGNE 2019-04-30 18:01:16 10.61.153.221 443 0
+s Running Valid
+w Bandwidth=0
+p reject 1-65535
+`
+
+func TestParseBridgeNetworkStatus(t *testing.T) {
+
+	objects, err := ParseBridgeNetworkStatus(strings.NewReader(bridgeStatusExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse bridge network status: %s", err)
+	}
+	bridgeStatus := objects.(*BridgeNetworkStatus)
+
+	if !bridgeStatus.ValidAfter.Equal(time.Date(2019, 5, 1, 0, 4, 3, 0, time.UTC)) {
+		t.Errorf("Got publication time %s.", bridgeStatus.ValidAfter)
+	}
+	if bridgeStatus.Authority != "BA44A889E64B93FAA2B114E02C2A279A8555C533" {
+		t.Errorf("Got authority fingerprint %s.", bridgeStatus.Authority)
+	}
+	if bridgeStatus.Length() != 2 {
+		t.Fatalf("Expected 2 bridges but got %d.", bridgeStatus.Length())
+	}
+
+	fpr := tor.Fingerprint("000A10D43011EA4928A35F610405F92B4433B4DC")
+	status, exists := bridgeStatus.Get(fpr)
+	if !exists {
+		t.Fatal("Could not find seele.")
+	}
+	if status.Digest != "DC974854BE193113352DD41DAE6E288D4B1F6EE0" {
+		t.Errorf("Got descriptor digest %s.", status.Digest)
+	}
+	if bridgeKey(status.Nickname, status.Address.IPv4ORPort) != "seele:9001" {
+		t.Errorf("Got bridge key %s.", bridgeKey(status.Nickname, status.Address.IPv4ORPort))
+	}
+	if status.Bandwidth != 55 || status.Address.IPv6ORPort != 9001 {
+		t.Errorf("Got bandwidth %d and IPv6 OR port %d.", status.Bandwidth, status.Address.IPv6ORPort)
+	}
+
+	if !status.Flags.Fast || !status.Flags.Stable || status.Flags.Guard {
+		t.Errorf("Got flags %+v.", status.Flags)
+	}
+}
+
+func TestParseBridgeNetworkStatusErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"no published", "fingerprint BA44A889E64B93FAA2B114E02C2A279A8555C533\n"},
+		{"malformed published", "published 2019-05-01\n"},
+		{"bad published time", "published 2019-05-01 25:04:03\n"},
+		{"short r line", "published 2019-05-01 00:04:03\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 2019-04-30 20:37:24 10.247.84.111 9001 0\n"},
+		{"bad digest", "published 2019-05-01 00:04:03\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw !!!! 2019-04-30 20:37:24 10.247.84.111 9001 0\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseBridgeNetworkStatus(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Parses the bandwidth files that bandwidth scanners, i.e., Torflow and sbws,
+// produce for the directory authorities.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// BandwidthMeasurement is a bandwidth scanner's measurement of a relay.
+type BandwidthMeasurement struct {
+	Fingerprint tor.Fingerprint
+	Nickname    string
+	// Measured bandwidth in kilobytes per second, as it ends up in votes.
+	Bandwidth uint64
+	// Bandwidth in bytes per second that the relay advertised in its
+	// descriptor.  It's 0 if the file doesn't tell us.
+	Advertised uint64
+}
+
+// GetFingerprint implements the tor.Object interface.
+func (m *BandwidthMeasurement) GetFingerprint() tor.Fingerprint {
+
+	return m.Fingerprint
+}
+
+// String implements the tor.Object interface.
+func (m *BandwidthMeasurement) String() string {
+
+	return fmt.Sprintf("%s,%s,%d,%d", m.Fingerprint, m.Nickname, m.Bandwidth, m.Advertised)
+}
+
+// Ratio returns the ratio between the relay's measured and advertised
+// bandwidth, or 0 if the advertised bandwidth is unknown.
+func (m *BandwidthMeasurement) Ratio() float64 {
+
+	if m.Advertised == 0 {
+		return 0
+	}
+
+	return float64(m.Bandwidth) * 1000 / float64(m.Advertised)
+}
+
+// Header keys that tell bandwidth scanners apart.  Bandwidth files don't name
+// the bandwidth authority that they belong to, but every authority runs its
+// own scanner, which measures from its own country.
+var bandwidthSourceKeys = []string{"software", "scanner_country", "destinations_countries"}
+
+// BandwidthFile is a bandwidth file, keyed by the relays' fingerprints.
+type BandwidthFile struct {
+	Timestamp    time.Time
+	Version      string
+	Software     string
+	Measurements map[tor.Fingerprint]*BandwidthMeasurement
+
+	// Source identifies the scanner that produced the file, e.g.,
+	// "sbws:US:ZZ".  Files without a header only tell us their path, so
+	// SetBandwidthSource then fills in their directory.
+	Source string
+}
+
+// NewBandwidthFile allocates and returns a new bandwidth file.
+func NewBandwidthFile() *BandwidthFile {
+
+	return &BandwidthFile{Measurements: make(map[tor.Fingerprint]*BandwidthMeasurement)}
+}
+
+// Iterate implements the tor.ObjectSet interface.
+func (file *BandwidthFile) Iterate(filter *tor.ObjectFilter) <-chan tor.Object {
+
+	ch := make(chan tor.Object)
+
+	go func() {
+		for _, m := range file.Measurements {
+			if filter == nil || filter.IsEmpty() || filter.HasFingerprint(m.Fingerprint) {
+				ch <- m
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// GetObject implements the tor.ObjectSet interface.
+func (file *BandwidthFile) GetObject(fpr tor.Fingerprint) (tor.Object, bool) {
+
+	m, exists := file.Measurements[fpr]
+
+	return m, exists
+}
+
+// Length implements the tor.ObjectSet interface.
+func (file *BandwidthFile) Length() int {
+
+	return len(file.Measurements)
+}
+
+// Merge implements the tor.ObjectSet interface.
+func (file *BandwidthFile) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*BandwidthFile)
+	if !ok {
+		return
+	}
+
+	for fpr, m := range other.Measurements {
+		file.Measurements[fpr] = m
+	}
+}
+
+// parseBandwidthLine parses a relay line of a bandwidth file, which consists
+// of space-separated key=value pairs.  It returns nil if the scanner asks
+// authorities not to vote on the relay's measurement.
+func parseBandwidthLine(line string) (*BandwidthMeasurement, error) {
+
+	m := new(BandwidthMeasurement)
+	values := make(map[string]string)
+	for _, word := range strings.Fields(line) {
+		keyValue := strings.SplitN(word, "=", 2)
+		if len(keyValue) == 2 {
+			values[keyValue[0]] = keyValue[1]
+		}
+	}
+
+	if values["vote"] == "0" {
+		return nil, nil
+	}
+
+	fpr := strings.TrimPrefix(values["node_id"], "$")
+	if len(fpr) != 40 {
+		return nil, fmt.Errorf("Malformed node_id in bandwidth line \"%s\".", line)
+	}
+	m.Fingerprint = tor.Fingerprint(strings.ToUpper(fpr))
+	m.Nickname = values["nick"]
+
+	var err error
+	if m.Bandwidth, err = strconv.ParseUint(values["bw"], 10, 64); err != nil {
+		return nil, fmt.Errorf("Malformed bw in bandwidth line \"%s\".", line)
+	}
+
+	// sbws gives us the relay's last observed bandwidth, and Torflow its
+	// descriptor bandwidth.
+	for _, key := range []string{"desc_bw_obs_last", "desc_bw"} {
+		if value, exists := values[key]; exists {
+			m.Advertised, _ = strconv.ParseUint(value, 10, 64)
+			break
+		}
+	}
+
+	return m, nil
+}
+
+// ParseBandwidthFile parses a bandwidth file.  The file starts with a Unix
+// timestamp, optionally followed by a header of key=value lines that ends with
+// a line of four or five "=", followed by one line per relay.
+func ParseBandwidthFile(r io.Reader) (tor.ObjectSet, error) {
+
+	file := NewBandwidthFile()
+	inHeader := true
+	header := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "@type") {
+			continue
+		}
+
+		if file.Timestamp.IsZero() {
+			seconds, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Expected Unix timestamp in bandwidth file but got \"%s\".", line)
+			}
+			file.Timestamp = time.Unix(seconds, 0).UTC()
+			continue
+		}
+
+		if line == "====" || line == "=====" {
+			inHeader = false
+			continue
+		}
+
+		// Header lines consist of a single key=value pair.  Files of version
+		// 1.0.0 have no header.
+		if inHeader && !strings.Contains(line, " ") {
+			keyValue := strings.SplitN(line, "=", 2)
+			if len(keyValue) == 2 {
+				header[keyValue[0]] = keyValue[1]
+			}
+			continue
+		}
+		inHeader = false
+
+		m, err := parseBandwidthLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			file.Measurements[m.Fingerprint] = m
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if file.Timestamp.IsZero() {
+		return nil, fmt.Errorf("Bandwidth file has no timestamp.")
+	}
+
+	file.Version = header["version"]
+	file.Software = header["software"]
+	var source []string
+	for _, key := range bandwidthSourceKeys {
+		if value, exists := header[key]; exists {
+			source = append(source, value)
+		}
+	}
+	file.Source = strings.Join(source, ":")
+
+	return file, nil
+}
+
+// SetBandwidthSource sets the source of the given object set to the directory
+// of the given path, if it is a bandwidth file whose header doesn't tell us
+// its source.  Torflow's files have no header, so every scanner's files have
+// to be in a directory of their own.
+func SetBandwidthSource(objects tor.ObjectSet, path string) {
+
+	if file, ok := objects.(*BandwidthFile); ok && file.Source == "" {
+		file.Source = filepath.Dir(path)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// An excerpt of a bandwidth file that sbws produced, as published by
+// CollecTor.
+const sbwsExcerpt = `@type bandwidth-file 1.0
+1556668800
+version=1.2.0
+software=sbws
+software_version=1.1.0
+scanner_country=US
+destinations_countries=ZZ
+latest_bandwidth=2019-05-01T00:00:00
+=====
+bw=9730 desc_bw_avg=1073741824 desc_bw_obs_last=10446000 nick=CalyxInstitute14 node_id=$0011BD2485AD45D984EC4159C88FC066E5E3300E success=4
+bw=1 nick=seele node_id=$000a10d43011ea4928a35f610405f92b4433b4dc vote=0 unmeasured=1
+`
+
+// An excerpt of a bandwidth file that Torflow produced.  Files of version
+// 1.0.0 have no header.
+const torflowExcerpt = `1556668800
+node_id=$0011BD2485AD45D984EC4159C88FC066E5E3300E bw=9730 nick=CalyxInstitute14 measured_at=1556665200 updated_at=1556665200 pid_error=0.1 pid_error_sum=0.1 pid_bw=9730 pid_delta=0 circ_fail=0.0 desc_bw=10200000
+node_id=$000A10D43011EA4928A35F610405F92B4433B4DC bw=18 nick=seele measured_at=1556665200
+`
+
+func TestParseBandwidthFile(t *testing.T) {
+
+	timestamp := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		document   string
+		version    string
+		source     string
+		relays     int
+		advertised uint64
+	}{
+		{"sbws", sbwsExcerpt, "1.2.0", "sbws:US:ZZ", 1, 10446000},
+		{"Torflow", torflowExcerpt, "", "", 2, 10200000},
+	}
+
+	for _, test := range tests {
+		objects, err := ParseBandwidthFile(strings.NewReader(test.document))
+		if err != nil {
+			t.Fatalf("%s: could not parse bandwidth file: %s", test.name, err)
+		}
+		file := objects.(*BandwidthFile)
+
+		if !file.Timestamp.Equal(timestamp) {
+			t.Errorf("%s: got timestamp %s.", test.name, file.Timestamp)
+		}
+		if file.Version != test.version {
+			t.Errorf("%s: expected version %q but got %q.", test.name, test.version, file.Version)
+		}
+		if file.Source != test.source {
+			t.Errorf("%s: expected source %q but got %q.", test.name, test.source, file.Source)
+		}
+		// Relays that authorities mustn't vote on are left out.
+		if file.Length() != test.relays {
+			t.Errorf("%s: expected %d relays but got %d.", test.name, test.relays, file.Length())
+		}
+
+		object, exists := file.GetObject("0011BD2485AD45D984EC4159C88FC066E5E3300E")
+		if !exists {
+			t.Fatalf("%s: could not find CalyxInstitute14.", test.name)
+		}
+		m := object.(*BandwidthMeasurement)
+		if m.Nickname != "CalyxInstitute14" || m.Bandwidth != 9730 || m.Advertised != test.advertised {
+			t.Errorf("%s: got measurement %s.", test.name, m)
+		}
+	}
+}
+
+func TestSetBandwidthSource(t *testing.T) {
+
+	objects, err := ParseBandwidthFile(strings.NewReader(torflowExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse bandwidth file: %s", err)
+	}
+	SetBandwidthSource(objects, "bwauth/moria1/2019-05-01-00-00-00-bandwidth")
+	if source := objects.(*BandwidthFile).Source; source != "bwauth/moria1" {
+		t.Errorf("Expected source \"bwauth/moria1\" but got %q.", source)
+	}
+
+	// The header takes precedence over the path.
+	objects, err = ParseBandwidthFile(strings.NewReader(sbwsExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse bandwidth file: %s", err)
+	}
+	SetBandwidthSource(objects, "bwauth/moria1/2019-05-01-00-00-00-bandwidth")
+	if source := objects.(*BandwidthFile).Source; source != "sbws:US:ZZ" {
+		t.Errorf("Expected source \"sbws:US:ZZ\" but got %q.", source)
+	}
+}
+
+func TestBandwidthRatio(t *testing.T) {
+
+	tests := []struct {
+		m     BandwidthMeasurement
+		ratio float64
+	}{
+		{BandwidthMeasurement{Bandwidth: 9730, Advertised: 10446000}, 0.9314570170400153},
+		{BandwidthMeasurement{Bandwidth: 500, Advertised: 250000}, 2},
+		{BandwidthMeasurement{Bandwidth: 9730}, 0},
+	}
+
+	for _, test := range tests {
+		if ratio := test.m.Ratio(); ratio != test.ratio {
+			t.Errorf("Expected ratio %f for %s but got %f.", test.ratio, &test.m, ratio)
+		}
+	}
+}
+
+func TestParseBandwidthFileErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"no timestamp", ""},
+		{"malformed timestamp", "2019-05-01T00:00:00\n"},
+		{"malformed node_id", "1556668800\nnode_id=$0011BD2485AD45D984EC bw=9730 nick=CalyxInstitute14\n"},
+		{"missing node_id", "1556668800\nbw=9730 nick=CalyxInstitute14\n"},
+		{"malformed bw", "1556668800\nnode_id=$0011BD2485AD45D984EC4159C88FC066E5E3300E bw=fast nick=CalyxInstitute14\n"},
+		{"missing bw", "1556668800\n=====\nnode_id=$0011BD2485AD45D984EC4159C88FC066E5E3300E nick=CalyxInstitute14\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseBandwidthFile(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
@@ -84,6 +84,14 @@ func FindFastRelays(channel chan tor.ObjectSet, params *CmdLineParams, group *sy
 
 	// Iterate over all consensus files.
 	for objects := range channel {
-		DetermineRelays(objects.(*tor.Consensus), params.BwFraction)
+		// We report every consensus as it arrives.
+		if objects == EndOfBatch {
+			continue
+		}
+		consensus := AsConsensus(objects)
+		if consensus == nil {
+			continue
+		}
+		DetermineRelays(consensus, params.BwFraction)
 	}
 }
@@ -0,0 +1,306 @@
+// Compares the bandwidth histories in extra-info descriptors to find relays
+// with near-identical traffic, and relays whose history contradicts their
+// consensus bandwidth.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	// Two relays must have at least this many history intervals in common for
+	// us to compare their traffic, i.e., six hours of 15-minute intervals.
+	minCommonIntervals = 24
+	// Relays whose average traffic differs by more than this factor are
+	// unlikely to have near-identical traffic curves, so we don't compare
+	// them.
+	maxHistoryRateRatio = 2
+	// A relay's history contradicts its consensus bandwidth if one of them is
+	// larger than the other by this factor.
+	historyContradictionFactor = 10
+	// Correlation threshold that's used if -threshold isn't in (0, 1].
+	defaultHistoryCorrelation = 0.95
+)
+
+// relayHistory accumulates a relay's bandwidth histories, and the bandwidth
+// that consensuses attribute to it.
+type relayHistory struct {
+	Fingerprint tor.Fingerprint
+	Nickname    string
+
+	// Bytes per second that the relay wrote and read, keyed by the end of the
+	// interval in Unix time.
+	Written map[int64]float64
+	Read    map[int64]float64
+
+	// The latest extra-info descriptor and router status that we saw.
+	ExtraInfoDigest string
+	Published       time.Time
+	Status          *tor.RouterStatus
+
+	BandwidthSum uint64
+	Statuses     uint64
+}
+
+// newRelayHistory allocates and returns a new relay history.
+func newRelayHistory(fpr tor.Fingerprint) *relayHistory {
+
+	return &relayHistory{
+		Fingerprint: fpr,
+		Written:     make(map[int64]float64),
+		Read:        make(map[int64]float64),
+	}
+}
+
+// addHistory adds the given bandwidth history to the given rates.  Intervals
+// are aligned to their length, so that the histories of relays that report at
+// slightly different times are still comparable.
+func addHistory(rates map[int64]float64, history *BandwidthHistory) {
+
+	if history == nil {
+		return
+	}
+
+	seconds := history.Interval.Seconds()
+	for i, t := range history.Times() {
+		rates[t.Truncate(history.Interval).Unix()] = float64(history.Values[i]) / seconds
+	}
+}
+
+// meanRate returns the average of the given rates.
+func meanRate(rates map[int64]float64) float64 {
+
+	if len(rates) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, rate := range rates {
+		sum += rate
+	}
+
+	return sum / float64(len(rates))
+}
+
+// correlateRates returns the Pearson correlation of the given rates over the
+// intervals that both have in common, and the number of these intervals.
+func correlateRates(rates1, rates2 map[int64]float64) (float64, int) {
+
+	var a, b []float64
+	for t, rate1 := range rates1 {
+		if rate2, exists := rates2[t]; exists {
+			a = append(a, rate1)
+			b = append(b, rate2)
+		}
+	}
+
+	if len(a) < minCommonIntervals {
+		return math.NaN(), len(a)
+	}
+
+	return PearsonCorrelation(a, b), len(a)
+}
+
+// historyContradicts returns true if the given relay's average written bytes
+// per second contradict the average bandwidth in the consensuses it was in.
+func historyContradicts(relay *relayHistory) (bool, float64, float64) {
+
+	if len(relay.Written) == 0 || relay.Statuses == 0 {
+		return false, 0, 0
+	}
+
+	// Consensus bandwidth is in kilobytes per second.
+	historyKBps := meanRate(relay.Written) / 1000
+	consensusKBps := float64(relay.BandwidthSum) / float64(relay.Statuses)
+
+	// Relays that relay next to nothing would otherwise dominate the ratio.
+	ratio := math.Max(historyKBps, 1) / math.Max(consensusKBps, 1)
+
+	return ratio >= historyContradictionFactor || ratio <= 1.0/historyContradictionFactor, historyKBps, consensusKBps
+}
+
+// relaysByRate sorts relays by their average traffic.
+type relaysByRate struct {
+	Relays []*relayHistory
+	Means  []float64
+}
+
+// Len implements the Sorter interface.
+func (r relaysByRate) Len() int {
+	return len(r.Relays)
+}
+
+// Swap implements the Sorter interface.
+func (r relaysByRate) Swap(i, j int) {
+	r.Relays[i], r.Relays[j] = r.Relays[j], r.Relays[i]
+	r.Means[i], r.Means[j] = r.Means[j], r.Means[i]
+}
+
+// Less implements the Sorter interface.
+func (r relaysByRate) Less(i, j int) bool {
+	return r.Means[i] < r.Means[j]
+}
+
+// printDescSimilarity prints the similarity of the server descriptors that the
+// given relays' latest router statuses reference.
+func printDescSimilarity(relay1, relay2 *relayHistory, params *CmdLineParams) {
+
+	if params.Descriptors == nil || relay1.Status == nil || relay2.Status == nil {
+		return
+	}
+
+	desc1, err := params.Descriptors.GetForStatus(relay1.Status)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	desc2, err := params.Descriptors.GetForStatus(relay2.Status)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	fmt.Println(CalcDescSimilarity(desc1, desc2))
+}
+
+// reportBandwidthHistories prints pairs of relays whose traffic is
+// near-identical, followed by relays whose history contradicts their consensus
+// bandwidth.
+func reportBandwidthHistories(histories map[tor.Fingerprint]*relayHistory, params *CmdLineParams) {
+
+	threshold := params.Threshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = defaultHistoryCorrelation
+	}
+
+	// Sort relays by their average traffic, so that we only have to compare
+	// each relay to its successors with similar traffic.
+	var relays []*relayHistory
+	for _, relay := range histories {
+		if len(relay.Written) >= minCommonIntervals {
+			relays = append(relays, relay)
+		}
+	}
+	means := make([]float64, len(relays))
+	for i, relay := range relays {
+		means[i] = meanRate(relay.Written)
+	}
+	sort.Sort(relaysByRate{relays, means})
+
+	log.Printf("Comparing bandwidth histories of %d relays with a correlation threshold of %.3f.\n",
+		len(relays), threshold)
+
+	count, similar := 0, 0
+	for i, relay1 := range relays {
+		for j := i + 1; j < len(relays) && means[j] <= means[i]*maxHistoryRateRatio; j++ {
+
+			relay2 := relays[j]
+			count++
+
+			written, writtenIntervals := correlateRates(relay1.Written, relay2.Written)
+			if math.IsNaN(written) || written < threshold {
+				continue
+			}
+
+			// Both histories must be similar if both relays report them.
+			read, readIntervals := correlateRates(relay1.Read, relay2.Read)
+			if !math.IsNaN(read) && read < threshold {
+				continue
+			}
+
+			similar++
+			fmt.Printf("<https://atlas.torproject.org/#details/%s> (%s)\n", relay1.Fingerprint, relay1.Nickname)
+			fmt.Printf("<https://atlas.torproject.org/#details/%s> (%s)\n", relay2.Fingerprint, relay2.Nickname)
+			fmt.Printf("Write history correlation: %.4f over %d intervals\n", written, writtenIntervals)
+			if !math.IsNaN(read) {
+				fmt.Printf("Read history correlation: %.4f over %d intervals\n", read, readIntervals)
+			}
+			fmt.Printf("Extra-info digests: %s, %s\n", relay1.ExtraInfoDigest, relay2.ExtraInfoDigest)
+			printDescSimilarity(relay1, relay2, params)
+			fmt.Println()
+		}
+	}
+
+	log.Printf("Computed %d pairwise history correlations, %d are part of output.\n", count, similar)
+
+	var fprs []string
+	for fpr := range histories {
+		fprs = append(fprs, string(fpr))
+	}
+	sort.Strings(fprs)
+
+	fmt.Println("fingerprint,nickname,history_kbps,consensus_kbps")
+	for _, fpr := range fprs {
+		relay := histories[tor.Fingerprint(fpr)]
+		if contradicts, historyKBps, consensusKBps := historyContradicts(relay); contradicts {
+			fmt.Printf("%s,%s,%.1f,%.1f\n", relay.Fingerprint, relay.Nickname, historyKBps, consensusKBps)
+		}
+	}
+}
+
+// AnalyseBandwidthHistories accumulates the bandwidth histories in extra-info
+// descriptors and the bandwidth in consensuses, and reports relays with
+// near-identical traffic, and relays whose history contradicts their consensus
+// bandwidth.
+func AnalyseBandwidthHistories(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
+
+	defer group.Done()
+
+	histories := make(map[tor.Fingerprint]*relayHistory)
+	getHistory := func(fpr tor.Fingerprint) *relayHistory {
+		relay, exists := histories[fpr]
+		if !exists {
+			relay = newRelayHistory(fpr)
+			histories[fpr] = relay
+		}
+		return relay
+	}
+
+	for objects := range channel {
+
+		// Follow mode finished a batch, so report what we have so far.
+		if objects == EndOfBatch {
+			reportBandwidthHistories(histories, params)
+			continue
+		}
+
+		if infos, ok := objects.(*ExtraInfos); ok {
+			for _, info := range infos.ExtraInfos {
+				relay := getHistory(info.Fingerprint)
+				addHistory(relay.Written, info.WriteHistory)
+				addHistory(relay.Read, info.ReadHistory)
+				if info.Published.After(relay.Published) {
+					relay.Published = info.Published
+					relay.ExtraInfoDigest = info.Digest
+					relay.Nickname = info.Nickname
+				}
+			}
+			continue
+		}
+
+		consensus := AsConsensus(objects)
+		if consensus == nil {
+			continue
+		}
+		for fpr, getStatus := range consensus.RouterStatuses {
+			status := getStatus()
+			relay := getHistory(fpr)
+			relay.Status = status
+			relay.BandwidthSum += status.Bandwidth
+			relay.Statuses++
+			if relay.Nickname == "" {
+				relay.Nickname = status.Nickname
+			}
+		}
+	}
+
+	reportBandwidthHistories(histories, params)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testHistory returns a history of 15-minute intervals with the given values,
+// whose last interval ends the given number of minutes after testEpoch.
+func testHistory(minutes int, values []uint64) *BandwidthHistory {
+
+	return &BandwidthHistory{
+		End:      testEpoch.Add(time.Duration(minutes) * time.Minute),
+		Interval: 15 * time.Minute,
+		Values:   values,
+	}
+}
+
+func TestAddHistoryAlignsIntervals(t *testing.T) {
+
+	rates1 := make(map[int64]float64)
+	rates2 := make(map[int64]float64)
+
+	// The two relays report their history five minutes apart.
+	addHistory(rates1, testHistory(60, []uint64{900, 1800}))
+	addHistory(rates2, testHistory(65, []uint64{900, 1800}))
+	addHistory(rates2, nil)
+
+	if len(rates1) != 2 || len(rates2) != 2 {
+		t.Fatalf("Expected 2 intervals each but got %v and %v.", rates1, rates2)
+	}
+	for interval, rate := range rates1 {
+		if rates2[interval] != rate {
+			t.Errorf("Expected rate %.0f at %d but got %.0f.", rate, interval, rates2[interval])
+		}
+	}
+	if rate := rates1[testEpoch.Add(time.Hour).Unix()]; rate != 2 {
+		t.Errorf("Expected 2 bytes per second but got %.2f.", rate)
+	}
+}
+
+func TestCorrelateRates(t *testing.T) {
+
+	rates1 := make(map[int64]float64)
+	rates2 := make(map[int64]float64)
+	for i := 0; i < minCommonIntervals; i++ {
+		rates1[int64(i)] = float64(i % 5)
+		rates2[int64(i)] = float64(i%5) * 2
+	}
+
+	if correlation, n := correlateRates(rates1, rates2); n != minCommonIntervals || math.Abs(correlation-1) > 1e-9 {
+		t.Errorf("Expected correlation 1 over %d intervals but got %f over %d.", minCommonIntervals, correlation, n)
+	}
+
+	// Too few intervals in common.
+	delete(rates2, 0)
+	if correlation, _ := correlateRates(rates1, rates2); !math.IsNaN(correlation) {
+		t.Errorf("Expected NaN for too few common intervals but got %f.", correlation)
+	}
+}
+
+func TestHistoryContradicts(t *testing.T) {
+
+	relay := newRelayHistory("000A10D43011EA4928A35F610405F92B4433B4DC")
+	if contradicts, _, _ := historyContradicts(relay); contradicts {
+		t.Error("Expected relay without history not to contradict anything.")
+	}
+
+	// The relay wrote 20 KB/s but claims 1,000 KB/s.
+	relay.Written[0] = 20000
+	relay.BandwidthSum = 2000
+	relay.Statuses = 2
+	contradicts, historyKBps, consensusKBps := historyContradicts(relay)
+	if !contradicts || historyKBps != 20 || consensusKBps != 1000 {
+		t.Errorf("Expected contradiction of 20 and 1000 KB/s but got %t, %.1f, and %.1f.", contradicts, historyKBps, consensusKBps)
+	}
+
+	relay.BandwidthSum = 60
+	if contradicts, _, _ := historyContradicts(relay); contradicts {
+		t.Error("Expected 20 and 30 KB/s not to contradict each other.")
+	}
+}
@@ -0,0 +1,209 @@
+// Tracks the ratio between relays' measured and advertised bandwidth over
+// time, to find relays that game bandwidth scanners.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	// Relays whose ratio deviates from the median ratio by more than this
+	// many (robust) standard deviations are outliers, unless -threshold is
+	// given.
+	defaultRatioOutlierScore = 3.5
+	// A relay's ratio jumped if it changed by at least this factor from one
+	// bandwidth file to the next.
+	ratioJumpFactor = 2
+	// Relays jumped together if their ratio changed by factors that differ
+	// by at most this fraction.
+	coordinatedJumpTolerance = 0.1
+	// Jumps are coordinated if at least this many relays jumped together.
+	minCoordinatedJumps = 3
+)
+
+// ratioJump is a jump in a relay's measured-vs-advertised ratio.
+type ratioJump struct {
+	Measurement *BandwidthMeasurement
+	Factor      float64
+}
+
+// ratioKey identifies a relay's ratio in the bandwidth files of a scanner.
+// Scanners measure differently, so we only compare a relay's ratio to its
+// previous ratio in the files of the same scanner.
+type ratioKey struct {
+	Source      string
+	Fingerprint tor.Fingerprint
+}
+
+// ratioJumps sorts jumps by their factor.
+type ratioJumps []*ratioJump
+
+// Len implements the Sorter interface.
+func (jumps ratioJumps) Len() int {
+	return len(jumps)
+}
+
+// Swap implements the Sorter interface.
+func (jumps ratioJumps) Swap(i, j int) {
+	jumps[i], jumps[j] = jumps[j], jumps[i]
+}
+
+// Less implements the Sorter interface.
+func (jumps ratioJumps) Less(i, j int) bool {
+	return jumps[i].Factor < jumps[j].Factor
+}
+
+// ratioOutliers returns the robust z-score of every relay in the given
+// bandwidth file whose log ratio deviates from the median by at least the
+// given score.  We use the median absolute deviation, so that the outliers
+// themselves don't skew what's normal.
+func ratioOutliers(file *BandwidthFile, minScore float64) map[tor.Fingerprint]float64 {
+
+	logRatios := make(map[tor.Fingerprint]float64)
+	var values []float64
+	for fpr, m := range file.Measurements {
+		if ratio := m.Ratio(); ratio > 0 {
+			logRatios[fpr] = math.Log(ratio)
+			values = append(values, logRatios[fpr])
+		}
+	}
+	med, mad := medianDeviation(values)
+
+	outliers := make(map[tor.Fingerprint]float64)
+	if mad == 0 {
+		return outliers
+	}
+	for fpr, logRatio := range logRatios {
+		if score := (logRatio - med) / mad; math.Abs(score) >= minScore {
+			outliers[fpr] = score
+		}
+	}
+
+	return outliers
+}
+
+// coordinatedJumps groups the given jumps, which happened between the same
+// two bandwidth files, into groups of relays whose ratio changed by nearly the
+// same factor.  Only groups of at least minCoordinatedJumps relays are
+// returned.
+func coordinatedJumps(jumps ratioJumps) [][]*ratioJump {
+
+	sort.Sort(jumps)
+
+	var groups [][]*ratioJump
+	var group []*ratioJump
+	for _, jump := range jumps {
+		if len(group) > 0 && jump.Factor > group[0].Factor*(1+coordinatedJumpTolerance) {
+			if len(group) >= minCoordinatedJumps {
+				groups = append(groups, group)
+			}
+			group = nil
+		}
+		group = append(group, jump)
+	}
+	if len(group) >= minCoordinatedJumps {
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// printRatioEvent prints a CSV row for the given measurement.
+func printRatioEvent(file *BandwidthFile, m *BandwidthMeasurement, event string, score float64, groupID string) {
+
+	fmt.Printf("%s,%s,%s,%s,%d,%d,%.4f,%s,%.2f,%s\n", file.Timestamp.Format("2006-01-02T15:04:05Z"),
+		file.Source, m.Fingerprint, m.Nickname, m.Bandwidth, m.Advertised, m.Ratio(), event, score, groupID)
+}
+
+// AnalyseBandwidthRatios tracks the ratio between relays' measured and
+// advertised bandwidth in bandwidth files.  It reports relays whose ratio is
+// an outlier, relays whose ratio jumped from one file of a scanner to the
+// scanner's next file, and groups of relays whose ratio jumped together.
+func AnalyseBandwidthRatios(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
+
+	defer group.Done()
+
+	minScore := params.Threshold
+	if minScore <= 0 {
+		minScore = defaultRatioOutlierScore
+	}
+	log.Printf("Reporting measured-vs-advertised ratios with a robust z-score of at least %.2f.\n", minScore)
+
+	fmt.Println("Date,Source,Fingerprint,Nickname,Measured,Advertised,Ratio,Event,Score,Group")
+
+	prevRatios := make(map[ratioKey]float64)
+	groups := 0
+	for objects := range channel {
+
+		// We report every bandwidth file as it arrives, so there's nothing
+		// left to report at the end of a batch in follow mode.
+		if objects == EndOfBatch {
+			continue
+		}
+		file, ok := objects.(*BandwidthFile)
+		if !ok {
+			continue
+		}
+
+		var fprs []string
+		for fpr := range file.Measurements {
+			fprs = append(fprs, string(fpr))
+		}
+		sort.Strings(fprs)
+
+		outliers := ratioOutliers(file, minScore)
+		var up, down ratioJumps
+		for _, fpr := range fprs {
+			m := file.Measurements[tor.Fingerprint(fpr)]
+			if score, exists := outliers[m.Fingerprint]; exists {
+				printRatioEvent(file, m, "outlier", score, "NA")
+			}
+
+			ratio := m.Ratio()
+			if ratio == 0 {
+				continue
+			}
+			key := ratioKey{file.Source, m.Fingerprint}
+			if prevRatio, exists := prevRatios[key]; exists {
+				if factor := ratio / prevRatio; factor >= ratioJumpFactor {
+					up = append(up, &ratioJump{m, factor})
+				} else if factor <= 1.0/ratioJumpFactor {
+					down = append(down, &ratioJump{m, factor})
+				}
+			}
+			prevRatios[key] = ratio
+		}
+
+		// Jumps that are part of a coordinated group are printed with the
+		// group's number, so they are easy to grep.
+		inGroup := make(map[tor.Fingerprint]string)
+		for _, jumps := range []ratioJumps{up, down} {
+			for _, jumpGroup := range coordinatedJumps(jumps) {
+				groups++
+				var members []string
+				for _, jump := range jumpGroup {
+					inGroup[jump.Measurement.Fingerprint] = fmt.Sprintf("%d", groups)
+					members = append(members, string(jump.Measurement.Fingerprint))
+				}
+				log.Printf("%d relays' ratios changed by a factor of %.2f to %.2f at %s: %s\n", len(jumpGroup),
+					jumpGroup[0].Factor, jumpGroup[len(jumpGroup)-1].Factor,
+					file.Timestamp.Format("2006-01-02T15:04:05Z"), strings.Join(members, " "))
+			}
+		}
+		for _, jump := range append(up, down...) {
+			id, exists := inGroup[jump.Measurement.Fingerprint]
+			if !exists {
+				id = "NA"
+			}
+			printRatioEvent(file, jump.Measurement, "jump", jump.Factor, id)
+		}
+	}
+}
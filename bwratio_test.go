@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// bandwidthFileAt returns a bandwidth file of the given source that was
+// produced the given number of hours after testEpoch.  Every relay advertises
+// 1,000,000 bytes per second, so its ratio is its measured bandwidth divided
+// by 1,000.
+func bandwidthFileAt(hour int, source string, bandwidths map[tor.Fingerprint]uint64) *BandwidthFile {
+
+	file := NewBandwidthFile()
+	file.Timestamp = testEpoch.Add(time.Duration(hour) * time.Hour)
+	file.Source = source
+	for fpr, bandwidth := range bandwidths {
+		file.Measurements[fpr] = &BandwidthMeasurement{Fingerprint: fpr, Bandwidth: bandwidth, Advertised: 1000000}
+	}
+
+	return file
+}
+
+func TestRatioOutliers(t *testing.T) {
+
+	bandwidths := make(map[tor.Fingerprint]uint64)
+	for i := 0; i < 10; i++ {
+		bandwidths[tor.Fingerprint(fmt.Sprintf("%040d", i))] = uint64(900 + 20*i)
+	}
+	bandwidths["FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"] = 20000
+
+	outliers := ratioOutliers(bandwidthFileAt(0, "sbws", bandwidths), defaultRatioOutlierScore)
+	if len(outliers) != 1 {
+		t.Fatalf("Expected 1 outlier but got %v.", outliers)
+	}
+	if score := outliers["FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"]; score < defaultRatioOutlierScore {
+		t.Errorf("Expected positive score of at least %.1f but got %.2f.", defaultRatioOutlierScore, score)
+	}
+
+	// Identical ratios have no deviation, so nothing stands out.
+	if outliers := ratioOutliers(bandwidthFileAt(0, "sbws", map[tor.Fingerprint]uint64{"AAAA": 1000, "BBBB": 1000}), 1); len(outliers) != 0 {
+		t.Errorf("Expected no outliers but got %v.", outliers)
+	}
+}
+
+func TestCoordinatedJumps(t *testing.T) {
+
+	var jumps ratioJumps
+	for _, factor := range []float64{5.1, 2.05, 3, 2.1, 5, 2} {
+		jumps = append(jumps, &ratioJump{&BandwidthMeasurement{}, factor})
+	}
+
+	// The jumps by 5 and 5.1 are too few to be a group, and the jump by 3
+	// is too far from the others.
+	groups := coordinatedJumps(jumps)
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("Expected 1 group of 3 jumps but got %v.", groups)
+	}
+	for i, expected := range []float64{2, 2.05, 2.1} {
+		if groups[0][i].Factor != expected {
+			t.Errorf("Expected factor %.2f but got %.2f.", expected, groups[0][i].Factor)
+		}
+	}
+}
+
+func TestAnalyseBandwidthRatiosPerSource(t *testing.T) {
+
+	fpr := tor.Fingerprint("000A10D43011EA4928A35F610405F92B4433B4DC")
+
+	// The two scanners consistently measure the relay differently, which
+	// must not look like a jump.  Only the last file, in which the first
+	// scanner's ratio jumps, is suspicious.
+	output := runAnalysis(t, AnalyseBandwidthRatios, &CmdLineParams{},
+		bandwidthFileAt(0, "sbws:US:ZZ", map[tor.Fingerprint]uint64{fpr: 1000}),
+		bandwidthFileAt(1, "sbws:DE:ZZ", map[tor.Fingerprint]uint64{fpr: 3000}),
+		bandwidthFileAt(2, "sbws:US:ZZ", map[tor.Fingerprint]uint64{fpr: 1000}),
+		EndOfBatch,
+		bandwidthFileAt(3, "sbws:DE:ZZ", map[tor.Fingerprint]uint64{fpr: 3000}),
+		bandwidthFileAt(4, "sbws:US:ZZ", map[tor.Fingerprint]uint64{fpr: 2500}))
+
+	var jumps []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, ",jump,") {
+			jumps = append(jumps, line)
+		}
+	}
+	expected := "2015-08-01T04:00:00Z,sbws:US:ZZ,000A10D43011EA4928A35F610405F92B4433B4DC,,2500,1000000,2.5000,jump,2.50,NA"
+	if len(jumps) != 1 || jumps[0] != expected {
+		t.Errorf("Expected one jump %q but got %q.", expected, jumps)
+	}
+}
@@ -0,0 +1,541 @@
+// Caches parsed object sets on disk, so that subsequent runs over the same
+// data don't have to parse it again.
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	// Bump the cache version whenever the encoding changes.  Entries written
+	// by other versions are then treated as cache misses.
+	cacheVersion = 2
+
+	cacheSuffix                  = ".gob.gz"
+	cachedConsensusType          = "consensus"
+	cachedDescType               = "descriptors"
+	cachedMicrodescConsensusType = "microdesc-consensus"
+	cachedMicrodescType          = "microdescriptors"
+	cachedExtraInfoType          = "extra-infos"
+	cachedVoteType               = "vote"
+	cachedExitListType           = "exit-list"
+	cachedBridgeStatusType       = "bridge-status"
+	cachedBridgeDescType         = "bridge-descriptors"
+	cachedBandwidthFileType      = "bandwidth-file"
+)
+
+// ObjectCache stores parsed object sets in a directory.  Entries are keyed by
+// a file's path, size, and modification time.  Every entry is a gzipped gob
+// stream consisting of a cacheHeader, followed by the object set.
+type ObjectCache struct {
+	Dir string
+}
+
+// cacheHeader describes a cache entry.  It precedes the cached object set, so
+// we can inspect entries without decoding the object set.
+type cacheHeader struct {
+	Version int
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Type    string
+	Time    time.Time
+	Length  int
+}
+
+// cachedConsensus is the serialisable representation of a tor.Consensus.
+type cachedConsensus struct {
+	ValidAfter time.Time
+	FreshUntil time.Time
+	ValidUntil time.Time
+	Statuses   []*tor.RouterStatus
+}
+
+// newCachedConsensus turns the given consensus into its serialisable
+// representation.
+func newCachedConsensus(consensus *tor.Consensus) *cachedConsensus {
+
+	cached := &cachedConsensus{
+		ValidAfter: consensus.ValidAfter,
+		FreshUntil: consensus.FreshUntil,
+		ValidUntil: consensus.ValidUntil,
+	}
+	for _, getStatus := range consensus.RouterStatuses {
+		cached.Statuses = append(cached.Statuses, getStatus())
+	}
+
+	return cached
+}
+
+// Consensus turns the serialisable representation back into a consensus.
+func (cached *cachedConsensus) Consensus() *tor.Consensus {
+
+	consensus := tor.NewConsensus()
+	consensus.ValidAfter = cached.ValidAfter
+	consensus.FreshUntil = cached.FreshUntil
+	consensus.ValidUntil = cached.ValidUntil
+	for _, status := range cached.Statuses {
+		consensus.Set(status.Fingerprint, status)
+	}
+
+	return consensus
+}
+
+// cachedFlaggedConsensus is the serialisable representation of a Consensus.
+type cachedFlaggedConsensus struct {
+	Consensus *cachedConsensus
+	Flags     StatusFlags
+}
+
+// cachedMicrodescConsensus is the serialisable representation of a
+// MicrodescConsensus.
+type cachedMicrodescConsensus struct {
+	Consensus  *cachedConsensus
+	Flags      StatusFlags
+	Microdescs map[tor.Fingerprint]string
+}
+
+// cachedVote is the serialisable representation of a Vote.
+type cachedVote struct {
+	Consensus            *cachedConsensus
+	Flags                StatusFlags
+	Authority            string
+	AuthorityFingerprint tor.Fingerprint
+}
+
+// cachedBridgeStatus is the serialisable representation of a
+// BridgeNetworkStatus.
+type cachedBridgeStatus struct {
+	Consensus *cachedConsensus
+	Flags     StatusFlags
+	Authority tor.Fingerprint
+}
+
+// cachedDescriptors is the serialisable representation of a
+// tor.RouterDescriptors.
+type cachedDescriptors struct {
+	Descriptors []*tor.RouterDescriptor
+}
+
+// newCachedDescriptors turns the given router descriptors into their
+// serialisable representation.
+func newCachedDescriptors(descs *tor.RouterDescriptors) *cachedDescriptors {
+
+	cached := new(cachedDescriptors)
+	for _, getDesc := range descs.RouterDescriptors {
+		cached.Descriptors = append(cached.Descriptors, getDesc())
+	}
+
+	return cached
+}
+
+// RouterDescriptors turns the cached descriptors back into router descriptors.
+func (cached *cachedDescriptors) RouterDescriptors() *tor.RouterDescriptors {
+
+	descs := tor.NewRouterDescriptors()
+	for _, desc := range cached.Descriptors {
+		descs.Set(desc.Fingerprint, desc)
+	}
+
+	return descs
+}
+
+// NewObjectCache returns a new object cache in the given directory, which is
+// created if it does not exist yet.
+func NewObjectCache(dir string) (*ObjectCache, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &ObjectCache{Dir: dir}, nil
+}
+
+// Key determines the cache key of the given file.
+func (c *ObjectCache) Key(path string, info os.FileInfo) string {
+
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%d\x00%d",
+		cacheVersion, path, info.Size(), info.ModTime().UnixNano())))
+
+	return hex.EncodeToString(digest[:])
+}
+
+// entryPath returns the file name of the cache entry with the given key.
+func (c *ObjectCache) entryPath(key string) string {
+
+	return filepath.Join(c.Dir, key[:2], key+cacheSuffix)
+}
+
+// Has returns true if the cache contains an entry for the given key.
+func (c *ObjectCache) Has(key string) bool {
+
+	_, err := os.Stat(c.entryPath(key))
+	return err == nil
+}
+
+// Store writes the given object set to the cache.  Object sets other than
+// (microdescriptor) consensuses, votes, bridge network statuses, router and
+// bridge descriptors, microdescriptors, extra-info descriptors, exit lists, and
+// bandwidth files are silently skipped.
+func (c *ObjectCache) Store(key, path string, info os.FileInfo, objects tor.ObjectSet) error {
+
+	var payload interface{}
+
+	header := cacheHeader{
+		Version: cacheVersion,
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Time:    ObjectSetTime(objects),
+		Length:  objects.Length(),
+	}
+
+	switch v := objects.(type) {
+	case *Consensus:
+		header.Type = cachedConsensusType
+		payload = &cachedFlaggedConsensus{newCachedConsensus(v.Consensus), v.StatusFlags}
+	case *MicrodescConsensus:
+		header.Type = cachedMicrodescConsensusType
+		payload = &cachedMicrodescConsensus{newCachedConsensus(v.Consensus), v.StatusFlags, v.Microdescs}
+	case *Microdescriptors:
+		header.Type = cachedMicrodescType
+		payload = v
+	case *ExtraInfos:
+		header.Type = cachedExtraInfoType
+		payload = v
+	case *ExitList:
+		header.Type = cachedExitListType
+		payload = v
+	case *BandwidthFile:
+		header.Type = cachedBandwidthFileType
+		payload = v
+	case *Vote:
+		header.Type = cachedVoteType
+		payload = &cachedVote{newCachedConsensus(v.Consensus), v.StatusFlags, v.Authority, v.AuthorityFingerprint}
+	case *tor.RouterDescriptors:
+		header.Type = cachedDescType
+		payload = newCachedDescriptors(v)
+	case *BridgeNetworkStatus:
+		header.Type = cachedBridgeStatusType
+		payload = &cachedBridgeStatus{newCachedConsensus(v.Consensus), v.StatusFlags, v.Authority}
+	case *BridgeDescriptors:
+		header.Type = cachedBridgeDescType
+		payload = newCachedDescriptors(v.RouterDescriptors)
+	default:
+		return nil
+	}
+
+	entryPath := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(entryPath), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that concurrent readers never see
+	// partially written entries.
+	fd, err := ioutil.TempFile(filepath.Dir(entryPath), key+".tmp")
+	if err != nil {
+		return err
+	}
+
+	gz, _ := gzip.NewWriterLevel(fd, gzip.BestSpeed)
+	enc := gob.NewEncoder(gz)
+	if err = enc.Encode(&header); err == nil {
+		err = enc.Encode(payload)
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	return os.Rename(fd.Name(), entryPath)
+}
+
+// openEntry opens the cache entry with the given file name and decodes its
+// header.  The returned decoder is positioned at the object set.
+func openEntry(entryPath string) (*os.File, *gob.Decoder, *cacheHeader, error) {
+
+	fd, err := os.Open(entryPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		fd.Close()
+		return nil, nil, nil, err
+	}
+
+	dec := gob.NewDecoder(gz)
+	header := new(cacheHeader)
+	if err := dec.Decode(header); err != nil {
+		fd.Close()
+		return nil, nil, nil, err
+	}
+
+	if header.Version != cacheVersion {
+		fd.Close()
+		return nil, nil, nil, fmt.Errorf("Cache entry %s has version %d, but we expect %d.", entryPath, header.Version, cacheVersion)
+	}
+
+	return fd, dec, header, nil
+}
+
+// Load reads the object set with the given key from the cache.  Entries that
+// cannot be decoded are removed from the cache.
+func (c *ObjectCache) Load(key string) (tor.ObjectSet, error) {
+
+	entryPath := c.entryPath(key)
+	objects, err := loadEntry(entryPath)
+	if err != nil {
+		os.Remove(entryPath)
+		return nil, fmt.Errorf("Removed unusable cache entry %s: %s", entryPath, err)
+	}
+
+	// Keep track of when we last used the entry, so we can prune stale ones.
+	now := time.Now()
+	os.Chtimes(entryPath, now, now)
+
+	return objects, nil
+}
+
+// loadEntry decodes the object set in the given cache entry.
+func loadEntry(entryPath string) (tor.ObjectSet, error) {
+
+	fd, dec, header, err := openEntry(entryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	switch header.Type {
+	case cachedConsensusType:
+		cached := new(cachedFlaggedConsensus)
+		if err := dec.Decode(cached); err != nil {
+			return nil, err
+		}
+		consensus := &Consensus{cached.Consensus.Consensus(), cached.Flags}
+		if consensus.Flags == nil {
+			consensus.Flags = make(map[tor.Fingerprint][]string)
+		}
+		return consensus, nil
+	case cachedDescType:
+		cached := new(cachedDescriptors)
+		if err := dec.Decode(cached); err != nil {
+			return nil, err
+		}
+		return cached.RouterDescriptors(), nil
+	case cachedBridgeDescType:
+		cached := new(cachedDescriptors)
+		if err := dec.Decode(cached); err != nil {
+			return nil, err
+		}
+		return &BridgeDescriptors{cached.RouterDescriptors()}, nil
+	case cachedBridgeStatusType:
+		cached := new(cachedBridgeStatus)
+		if err := dec.Decode(cached); err != nil {
+			return nil, err
+		}
+		status := &BridgeNetworkStatus{cached.Consensus.Consensus(), cached.Flags, cached.Authority}
+		if status.Flags == nil {
+			status.Flags = make(map[tor.Fingerprint][]string)
+		}
+		return status, nil
+	case cachedMicrodescConsensusType:
+		cached := new(cachedMicrodescConsensus)
+		if err := dec.Decode(cached); err != nil {
+			return nil, err
+		}
+		consensus := &MicrodescConsensus{cached.Consensus.Consensus(), cached.Flags, cached.Microdescs}
+		if consensus.Flags == nil {
+			consensus.Flags = make(map[tor.Fingerprint][]string)
+		}
+		if consensus.Microdescs == nil {
+			consensus.Microdescs = make(map[tor.Fingerprint]string)
+		}
+		return consensus, nil
+	case cachedMicrodescType:
+		descs := NewMicrodescriptors()
+		if err := dec.Decode(descs); err != nil {
+			return nil, err
+		}
+		return descs, nil
+	case cachedExtraInfoType:
+		infos := NewExtraInfos()
+		if err := dec.Decode(infos); err != nil {
+			return nil, err
+		}
+		return infos, nil
+	case cachedVoteType:
+		cached := new(cachedVote)
+		if err := dec.Decode(cached); err != nil {
+			return nil, err
+		}
+		vote := &Vote{cached.Consensus.Consensus(), cached.Flags, cached.Authority, cached.AuthorityFingerprint}
+		if vote.Flags == nil {
+			vote.Flags = make(map[tor.Fingerprint][]string)
+		}
+		return vote, nil
+	case cachedExitListType:
+		list := NewExitList()
+		if err := dec.Decode(list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	case cachedBandwidthFileType:
+		file := NewBandwidthFile()
+		if err := dec.Decode(file); err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+
+	return nil, fmt.Errorf("Unknown cache entry type \"%s\".", header.Type)
+}
+
+// walkEntries calls the given function for all entries in the cache.
+func (c *ObjectCache) walkEntries(walkFn func(string, os.FileInfo) error) error {
+
+	return filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, cacheSuffix) {
+			return nil
+		}
+		return walkFn(path, info)
+	})
+}
+
+// PrintInfo writes a summary of the cache's content to stdout.
+func (c *ObjectCache) PrintInfo() error {
+
+	var entries, unusable int
+	var totalSize int64
+	var earliest, latest time.Time
+	types := make(map[string]int)
+
+	err := c.walkEntries(func(path string, info os.FileInfo) error {
+		entries++
+		totalSize += info.Size()
+
+		fd, _, header, err := openEntry(path)
+		if err != nil {
+			unusable++
+			return nil
+		}
+		fd.Close()
+
+		types[header.Type]++
+		if earliest.IsZero() || header.Time.Before(earliest) {
+			earliest = header.Time
+		}
+		if header.Time.After(latest) {
+			latest = header.Time
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cache directory: %s\n", c.Dir)
+	fmt.Printf("Entries: %d (%d bytes)\n", entries, totalSize)
+	for typ, count := range types {
+		fmt.Printf("\t%s: %d\n", typ, count)
+	}
+	if unusable > 0 {
+		fmt.Printf("Unusable entries: %d\n", unusable)
+	}
+	if entries > unusable {
+		fmt.Printf("Documents from %s to %s\n", earliest.Format(time.RFC3339), latest.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Prune removes all entries that have not been used within the given
+// duration, as well as entries that we can no longer decode.
+func (c *ObjectCache) Prune(maxAge time.Duration) error {
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+
+	err := c.walkEntries(func(path string, info os.FileInfo) error {
+		if info.ModTime().After(cutoff) {
+			fd, _, _, err := openEntry(path)
+			if err == nil {
+				fd.Close()
+				return nil
+			}
+		}
+		removed++
+		return os.Remove(path)
+	})
+
+	log.Printf("Pruned %d cache entries.\n", removed)
+
+	return err
+}
+
+// Clear invalidates the cache by removing all of its entries.
+func (c *ObjectCache) Clear() error {
+
+	removed := 0
+	err := c.walkEntries(func(path string, info os.FileInfo) error {
+		removed++
+		return os.Remove(path)
+	})
+
+	log.Printf("Removed %d cache entries.\n", removed)
+
+	return err
+}
+
+// RunCacheCommands runs the cache commands given on the command line.  It
+// returns true if at least one command was run.
+func RunCacheCommands(params *CmdLineParams) bool {
+
+	ran := false
+
+	if params.CacheClear {
+		ran = true
+		if err := params.Cache.Clear(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if params.CachePrune != 0 {
+		ran = true
+		if err := params.Cache.Prune(params.CachePrune); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if params.CacheInfo {
+		ran = true
+		if err := params.Cache.PrintInfo(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return ran
+}
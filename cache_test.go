@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// newTestCache returns an object cache in a new temporary directory.
+func newTestCache(t *testing.T) *ObjectCache {
+
+	dir, err := ioutil.TempDir("", "sybilhunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewObjectCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cache
+}
+
+func TestObjectCacheRoundTrip(t *testing.T) {
+
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Dir)
+
+	consensus := consensusAt(3)
+	consensus.Set("0000000000000000000000000000000000000001", &tor.RouterStatus{
+		Nickname:    "foo",
+		Fingerprint: "0000000000000000000000000000000000000001",
+		Bandwidth:   1000,
+	})
+	consensus.KnownFlags = []string{"Running", "StaleDesc"}
+	consensus.Flags["0000000000000000000000000000000000000001"] = []string{"Running", "StaleDesc"}
+
+	info := &testFileInfo{"2015-08-01-03-00-00-consensus", 1234, testEpoch}
+	key := cache.Key(info.name, info)
+	if cache.Has(key) {
+		t.Fatal("Expected empty cache.")
+	}
+	if err := cache.Store(key, info.name, info, consensus); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Has(key) {
+		t.Fatal("Expected cache entry after storing.")
+	}
+
+	objects, err := cache.Load(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok := objects.(*Consensus)
+	if !ok {
+		t.Fatalf("Expected *Consensus but got %T.", objects)
+	}
+	if !loaded.ValidAfter.Equal(consensus.ValidAfter) {
+		t.Errorf("Expected valid-after %s but got %s.", consensus.ValidAfter, loaded.ValidAfter)
+	}
+	status, exists := loaded.Get("0000000000000000000000000000000000000001")
+	if !exists || status.Nickname != "foo" || status.Bandwidth != 1000 {
+		t.Errorf("Router status did not survive the cache: %+v.", status)
+	}
+	if !loaded.KnowsFlag("StaleDesc") || len(loaded.Flags["0000000000000000000000000000000000000001"]) != 2 {
+		t.Errorf("Status flags did not survive the cache: %+v.", loaded.StatusFlags)
+	}
+}
+
+func TestObjectCacheKey(t *testing.T) {
+
+	cache := &ObjectCache{}
+	info := &testFileInfo{"consensus", 1234, testEpoch}
+	key := cache.Key("consensus", info)
+
+	modified := &testFileInfo{"consensus", 1234, testEpoch.Add(time.Second)}
+	if cache.Key("consensus", modified) == key {
+		t.Error("Expected modification time to change the cache key.")
+	}
+
+	grown := &testFileInfo{"consensus", 1235, testEpoch}
+	if cache.Key("consensus", grown) == key {
+		t.Error("Expected file size to change the cache key.")
+	}
+
+	if cache.Key("other", info) == key {
+		t.Error("Expected path to change the cache key.")
+	}
+}
+
+func TestObjectCacheDropsUnusableEntries(t *testing.T) {
+
+	cache := newTestCache(t)
+	defer os.RemoveAll(cache.Dir)
+
+	info := &testFileInfo{"consensus", 1234, testEpoch}
+	good := cache.Key("good", info)
+	if err := cache.Store(good, "good", info, consensusAt(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := cache.Key("bad", info)
+	if err := os.MkdirAll(filepath.Dir(cache.entryPath(bad)), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cache.entryPath(bad), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.Load(bad); err == nil {
+		t.Error("Expected error for corrupt cache entry.")
+	}
+	if cache.Has(bad) {
+		t.Error("Expected corrupt cache entry to be removed.")
+	}
+
+	if err := cache.Prune(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.Has(good) {
+		t.Error("Expected recently used entry to survive pruning.")
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Has(good) {
+		t.Error("Expected cleared cache to be empty.")
+	}
+}
@@ -0,0 +1,291 @@
+// Saves the state of long-running analyses, so that an interrupted run can be
+// resumed.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	// Bump the checkpoint version whenever the encoding changes.
+	checkpointVersion = 1
+	checkpointFile    = "checkpoint.gob.gz"
+)
+
+// errInterrupted is returned if the user interrupted a run.
+var errInterrupted = errors.New("Interrupted.  Analyses reported their partial results.")
+
+// AnalysisState is the saved state of a single analysis.
+type AnalysisState struct {
+	// Time of the last object set that the analysis processed.
+	Time  time.Time
+	State []byte
+}
+
+// Checkpoint keeps the saved state of all analyses in a single file.  Every
+// analysis saves its state under its own name, and decides itself when to do
+// so.  Because object sets are passed to analyses in chronological order, the
+// time of the last processed object set tells us where to resume.  A
+// Checkpoint is safe for concurrent use.
+type Checkpoint struct {
+	Path     string
+	Interval time.Duration
+
+	mutex    sync.Mutex
+	analyses map[string]*AnalysisState
+	lastSave map[string]time.Time
+}
+
+// checkpointFileContent is what we write to the checkpoint file.
+type checkpointFileContent struct {
+	Version  int
+	Analyses map[string]*AnalysisState
+}
+
+// NewCheckpoint returns a new checkpoint that's stored at the given path.
+// Analyses save their state whenever the given interval has passed, and once
+// they are done.
+func NewCheckpoint(path string, interval time.Duration) *Checkpoint {
+
+	return &Checkpoint{
+		Path:     path,
+		Interval: interval,
+		analyses: make(map[string]*AnalysisState),
+		lastSave: make(map[string]time.Time),
+	}
+}
+
+// Load reads the checkpoint file.
+func (cp *Checkpoint) Load() error {
+
+	fd, err := os.Open(cp.Path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		return err
+	}
+
+	content := new(checkpointFileContent)
+	if err := gob.NewDecoder(gz).Decode(content); err != nil {
+		return err
+	}
+
+	if content.Version != checkpointVersion {
+		return fmt.Errorf("Checkpoint %s has version %d, but we expect %d.", cp.Path, content.Version, checkpointVersion)
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.analyses = content.Analyses
+
+	return nil
+}
+
+// ResumeTime returns the time of the earliest object set that one of the
+// saved analyses processed last.  Object sets up to this time don't have to be
+// passed to analyses again.
+func (cp *Checkpoint) ResumeTime() time.Time {
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	var resumeTime time.Time
+	for _, state := range cp.analyses {
+		if resumeTime.IsZero() || state.Time.Before(resumeTime) {
+			resumeTime = state.Time
+		}
+	}
+
+	return resumeTime
+}
+
+// Restore decodes the saved state of the analysis with the given name into
+// the given pointer, and returns the time of the last object set that the
+// analysis processed.  If there's no saved state, the zero time is returned.
+// Restore can be called on a nil checkpoint.
+func (cp *Checkpoint) Restore(name string, state interface{}) time.Time {
+
+	if cp == nil {
+		return time.Time{}
+	}
+
+	cp.mutex.Lock()
+	saved, exists := cp.analyses[name]
+	cp.mutex.Unlock()
+	if !exists {
+		return time.Time{}
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(saved.State)).Decode(state); err != nil {
+		log.Fatalf("Could not restore state of %s analysis from %s: %s\n", name, cp.Path, err)
+	}
+	log.Printf("Resuming %s analysis after %s.\n", name, saved.Time.Format(time.RFC3339))
+
+	return saved.Time
+}
+
+// Due returns true if the analysis with the given name should save its state.
+// Due can be called on a nil checkpoint.
+func (cp *Checkpoint) Due(name string) bool {
+
+	if cp == nil || cp.Interval <= 0 {
+		return false
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	lastSave, exists := cp.lastSave[name]
+	if !exists {
+		// Start counting when the analysis processes its first object set.
+		cp.lastSave[name] = time.Now()
+		return false
+	}
+
+	return time.Since(lastSave) >= cp.Interval
+}
+
+// Save encodes the given state of the analysis with the given name, and writes
+// the checkpoint file.  The given time is the time of the last object set that
+// the analysis processed.  Save can be called on a nil checkpoint.
+func (cp *Checkpoint) Save(name string, t time.Time, state interface{}) {
+
+	if cp == nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(state); err != nil {
+		log.Printf("Could not encode state of %s analysis: %s\n", name, err)
+		return
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	cp.analyses[name] = &AnalysisState{Time: t, State: buf.Bytes()}
+	cp.lastSave[name] = time.Now()
+
+	if err := cp.write(); err != nil {
+		log.Printf("Could not write checkpoint to %s: %s\n", cp.Path, err)
+		return
+	}
+	log.Printf("Saved state of %s analysis up to %s.\n", name, t.Format(time.RFC3339))
+}
+
+// write writes all analyses' state to the checkpoint file.  The caller must
+// hold the mutex.
+func (cp *Checkpoint) write() error {
+
+	if err := os.MkdirAll(filepath.Dir(cp.Path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash while we're writing
+	// doesn't destroy the previous checkpoint.
+	fd, err := ioutil.TempFile(filepath.Dir(cp.Path), filepath.Base(cp.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	gz, _ := gzip.NewWriterLevel(fd, gzip.BestSpeed)
+	err = gob.NewEncoder(gz).Encode(&checkpointFileContent{checkpointVersion, cp.analyses})
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+
+	return os.Rename(fd.Name(), cp.Path)
+}
+
+// alreadyProcessed returns true if the given object set is not newer than the
+// given resume time, i.e., it was processed before the checkpoint was saved.
+func alreadyProcessed(objects tor.ObjectSet, resumeTime time.Time) bool {
+
+	if resumeTime.IsZero() {
+		return false
+	}
+
+	t := ObjectSetTime(objects)
+
+	return !t.IsZero() && !t.After(resumeTime)
+}
+
+// Interrupter catches SIGINT, so that we can stop reading new files but still
+// pass on what we already parsed, and let analyses report partial results.
+// A second SIGINT terminates the process right away.
+type Interrupter struct {
+	signals chan os.Signal
+	done    chan bool
+}
+
+// NewInterrupter allocates a new interrupter and starts catching SIGINT.
+func NewInterrupter() *Interrupter {
+
+	interrupter := &Interrupter{
+		signals: make(chan os.Signal, 1),
+		done:    make(chan bool),
+	}
+	signal.Notify(interrupter.signals, os.Interrupt)
+
+	go func() {
+		if _, ok := <-interrupter.signals; !ok {
+			return
+		}
+		log.Println("Caught SIGINT.  Stopping after the files that are being parsed.  Press Ctrl+C again to exit right away.")
+		signal.Stop(interrupter.signals)
+		close(interrupter.done)
+	}()
+
+	return interrupter
+}
+
+// Done returns a channel that's closed once SIGINT was caught.
+func (interrupter *Interrupter) Done() <-chan bool {
+
+	return interrupter.done
+}
+
+// Interrupted returns true if SIGINT was caught.
+func (interrupter *Interrupter) Interrupted() bool {
+
+	select {
+	case <-interrupter.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops catching SIGINT.
+func (interrupter *Interrupter) Stop() {
+
+	signal.Stop(interrupter.signals)
+	if !interrupter.Interrupted() {
+		close(interrupter.signals)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testState stands in for the saved state of an analysis.
+type testState struct {
+	Counts map[string]int
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sybilhunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, checkpointFile)
+	cp := NewCheckpoint(path, time.Minute)
+	cp.Save("uptime", testEpoch.Add(5*time.Hour), &testState{map[string]int{"foo": 5}})
+	cp.Save("churn", testEpoch.Add(3*time.Hour), &testState{map[string]int{"bar": 3}})
+
+	loaded := NewCheckpoint(path, time.Minute)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if resumeTime := loaded.ResumeTime(); !resumeTime.Equal(testEpoch.Add(3 * time.Hour)) {
+		t.Errorf("Expected resume time %s but got %s.", testEpoch.Add(3*time.Hour), resumeTime)
+	}
+
+	var state testState
+	if last := loaded.Restore("uptime", &state); !last.Equal(testEpoch.Add(5 * time.Hour)) {
+		t.Errorf("Expected uptime analysis to resume after %s but got %s.", testEpoch.Add(5*time.Hour), last)
+	}
+	if state.Counts["foo"] != 5 {
+		t.Errorf("Expected restored count 5 but got %d.", state.Counts["foo"])
+	}
+
+	if last := loaded.Restore("fingerprints", &state); !last.IsZero() {
+		t.Errorf("Expected zero time for analysis without state but got %s.", last)
+	}
+}
+
+func TestCheckpointNil(t *testing.T) {
+
+	var cp *Checkpoint
+	var state testState
+
+	cp.Save("uptime", testEpoch, &state)
+	if cp.Due("uptime") {
+		t.Error("Expected nil checkpoint to never be due.")
+	}
+	if last := cp.Restore("uptime", &state); !last.IsZero() {
+		t.Errorf("Expected zero time from nil checkpoint but got %s.", last)
+	}
+}
+
+func TestCheckpointDue(t *testing.T) {
+
+	cp := NewCheckpoint("", time.Hour)
+	if cp.Due("uptime") {
+		t.Error("Expected first call to Due to start counting.")
+	}
+	if cp.Due("uptime") {
+		t.Error("Expected checkpoint not to be due before the interval passed.")
+	}
+
+	cp.lastSave["uptime"] = time.Now().Add(-2 * time.Hour)
+	if !cp.Due("uptime") {
+		t.Error("Expected checkpoint to be due after the interval passed.")
+	}
+
+	if NewCheckpoint("", 0).Due("uptime") {
+		t.Error("Expected checkpoint without interval to never be due.")
+	}
+}
+
+func TestAlreadyProcessed(t *testing.T) {
+
+	resumeTime := testEpoch.Add(2 * time.Hour)
+
+	tests := []struct {
+		hour     int
+		expected bool
+	}{
+		{1, true},
+		{2, true},
+		{3, false},
+	}
+
+	for _, test := range tests {
+		if got := alreadyProcessed(consensusAt(test.hour), resumeTime); got != test.expected {
+			t.Errorf("Expected %t for hour %d but got %t.", test.expected, test.hour, got)
+		}
+	}
+
+	if alreadyProcessed(consensusAt(1), time.Time{}) {
+		t.Error("Expected nothing to be processed without resume time.")
+	}
+	if alreadyProcessed(EndOfBatch, resumeTime) {
+		t.Error("Expected EndOfBatch to never count as processed.")
+	}
+}
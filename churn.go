@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"reflect"
 	"sync"
 	"time"
 
@@ -17,29 +16,46 @@ const (
 	Disappeared = false
 )
 
-// RelayFlags holds the relay flags that will be analysed.
-var RelayFlags = []string{
-	"Authority",
-	"BadExit",
-	"Exit",
-	"Fast",
-	"Guard",
-	"HSDir",
-	"Named",
-	"Running",
-	"Stable",
-	"Unnamed",
-	"V2Dir",
-	"Valid"}
-
 // Churn holds two churn values, for relays that went online and relays that
-// went offline.
+// went offline.  The weighted values hold the same, but weighted by the
+// relays' consensus bandwidth, i.e., the fraction of consensus weight that
+// appeared or disappeared.
 type Churn struct {
-	Online  float64
-	Offline float64
+	Online          float64
+	Offline         float64
+	WeightedOnline  float64
+	WeightedOffline float64
+}
+
+// Scale returns the churn values multiplied by the given factor.
+func (churn Churn) Scale(factor float64) Churn {
+
+	return Churn{churn.Online * factor, churn.Offline * factor,
+		churn.WeightedOnline * factor, churn.WeightedOffline * factor}
+}
+
+// Exceeds returns true if the churn of relays that went online (if online is
+// true) or offline (if online is false) reaches the given threshold in the
+// given metric.
+func (churn Churn) Exceeds(threshold float64, online bool, metric string) bool {
+
+	count, weighted := churn.Offline, churn.WeightedOffline
+	if online {
+		count, weighted = churn.Online, churn.WeightedOnline
+	}
+
+	switch metric {
+	case bandwidthMetric:
+		return weighted >= threshold
+	case eitherMetric:
+		return count >= threshold || weighted >= threshold
+	default:
+		return count >= threshold
+	}
 }
 
-// PerFlagMovAvg maps a relay flag, e.g., "Guard", to a moving average struct.
+// PerFlagMovAvg maps a relay flag or the name of a flag expression, e.g.,
+// "Guard", to a moving average struct.
 type PerFlagMovAvg map[string]*MovingAverage
 
 // MovingAverage represents a simple moving average.
@@ -63,11 +79,11 @@ func (ma *MovingAverage) CalcAvg() Churn {
 	for i := 0; i < ma.WindowSize; i++ {
 		total.Online += ma.Window[i].Online
 		total.Offline += ma.Window[i].Offline
+		total.WeightedOnline += ma.Window[i].WeightedOnline
+		total.WeightedOffline += ma.Window[i].WeightedOffline
 	}
-	total.Online /= float64(ma.WindowSize)
-	total.Offline /= float64(ma.WindowSize)
 
-	return total
+	return total.Scale(1 / float64(ma.WindowSize))
 }
 
 // AddValue adds a churn value to the moving average window.
@@ -76,8 +92,7 @@ func (ma *MovingAverage) AddValue(val Churn) {
 	if ma.WindowFill < ma.WindowSize {
 		ma.WindowFill++
 	}
-	ma.Window[ma.WindowIndex].Online = val.Online
-	ma.Window[ma.WindowIndex].Offline = val.Offline
+	ma.Window[ma.WindowIndex] = val
 	ma.WindowIndex = (ma.WindowIndex + 1) % ma.WindowSize
 }
 
@@ -87,8 +102,48 @@ func (ma *MovingAverage) IsWindowFull() bool {
 	return ma.WindowFill == ma.WindowSize
 }
 
-// dumpChurnRelays dumps the given relays to stderr for manual analysis.
-func dumpChurnRelays(relays *tor.Consensus, prefix string, date time.Time) {
+// churnState is the state of the churn analysis that we save in checkpoints.
+type churnState struct {
+	DocType       string
+	PrevConsensus *cachedConsensus
+	MovAvg        PerFlagMovAvg
+	Detectors     PerFlagDetector
+	PrevFlags     *StatusFlags
+	// Flags holds the flags that we print columns for, so that resumed
+	// runs print the same columns.
+	Flags []string
+}
+
+// checkDocumentType returns the document type of the given object set, and an
+// error if it differs from the given type of the previous object sets.
+// Consensuses and microdescriptor consensuses list the same relays, and bridge
+// network statuses list bridges instead of relays, so comparing one to the
+// other would yield bogus churn.
+func checkDocumentType(docType string, objects tor.ObjectSet) (string, error) {
+
+	newType := DocumentType(objects)
+	if docType != "" && newType != docType {
+		return docType, fmt.Errorf("Cannot analyse churn of both %s and %s documents.  Use -data to select one document type.", docType, newType)
+	}
+
+	return newType, nil
+}
+
+// dumpChurnRelays writes the given relays with the given flag, which went
+// online (if online is true) or offline (if online is false), as churn spike
+// to the given recorder for manual analysis.  The given consensus is the one
+// that the relays are part of, and bridges is true if it's a bridge network
+// status.  The score is nil unless the adaptive detector found the spike.  If
+// -investigate is given, we also check whether the relays that went online
+// look alike.
+func dumpChurnRelays(recorder *ChurnRecorder, relays *tor.Consensus, consensus *FlaggedConsensus, bridges bool, flag string, online bool, churn Churn, score *ChurnScore, date time.Time, params *CmdLineParams) {
+
+	// A churn value can exceed the threshold even though no relay with the
+	// flag joined or left, e.g., if the moving average still holds an
+	// earlier spike.  There is nothing to write then.
+	if relays.Length() == 0 {
+		return
+	}
 
 	// Sort relays by nickname.
 	nickname := func(relay1, relay2 tor.GetStatus) bool {
@@ -98,16 +153,70 @@ func dumpChurnRelays(relays *tor.Consensus, prefix string, date time.Time) {
 	sliceRelays := relays.ToSlice()
 	By(nickname).Sort(sliceRelays)
 
+	spike := &ChurnSpike{Date: date, Flag: flag, Score: score}
+	prefix := "-" + flag
+	if online {
+		spike.Direction = "joined"
+		spike.Churn, spike.WeightedChurn = churn.Online, churn.WeightedOnline
+		prefix = "+" + flag
+	} else {
+		spike.Direction = "left"
+		spike.Churn, spike.WeightedChurn = churn.Offline, churn.WeightedOffline
+	}
 	for _, getStatus := range sliceRelays {
 		status := getStatus()
-		log.Printf("%s <https://atlas.torproject.org/#details/%s> %s\n",
-			prefix, status.Fingerprint, status.Nickname)
+		spike.Relays = append(spike.Relays, NewChurnedRelay(status, consensus.Flags.Flags[status.Fingerprint]))
+	}
+
+	if err := recorder.Write(spike); err != nil {
+		log.Fatalf("Could not write churn spike: %s\n", err)
+	}
+
+	if score != nil {
+		log.Printf("Churn spike %s at %s: %d relays, %s churn has a score of %.2f compared to the %s baseline "+
+			"(median %.5f, deviation %.5f).\n", prefix, date.Format("2006-01-02T15:04:05Z"), len(spike.Relays),
+			score.Metric, score.Score, score.Baseline, score.Median, score.Deviation)
+	} else {
+		log.Printf("Churn spike %s at %s: %d relays, churn %.5f, weighted churn %.5f.\n", prefix,
+			date.Format("2006-01-02T15:04:05Z"), len(spike.Relays), spike.Churn, spike.WeightedChurn)
+	}
+
+	if !online || !params.Investigate {
+		return
+	}
+	cohort := InvestigateCohort(relays, consensus.Consensus, bridges, flag, date, params)
+	if cohort == nil {
+		return
+	}
+	if err := recorder.WriteCohort(cohort); err != nil {
+		log.Fatalf("Could not write churn cohort: %s\n", err)
+	}
+	if cohort.Tight() {
+		log.Printf("Relays of churn spike %s at %s probably have a single operator: %s\n", prefix,
+			date.Format("2006-01-02T15:04:05Z"), cohort)
+	} else {
+		log.Printf("Relays of churn spike %s at %s don't look alike: %s\n", prefix,
+			date.Format("2006-01-02T15:04:05Z"), cohort)
 	}
 }
 
+// consensusWeight returns the sum of the consensus bandwidth of all relays in
+// the given consensus.
+func consensusWeight(consensus *tor.Consensus) float64 {
+
+	var weight float64
+	for _, getStatus := range consensus.RouterStatuses {
+		weight += float64(getStatus().Bandwidth)
+	}
+
+	return weight
+}
+
 // determineChurn determines and returns the churn rate of the two given
 // subsequent consensuses.  For the churn rate, we adapt the formula shown in
 // Section 2.1 of: <http://www.cs.berkeley.edu/~istoica/papers/2006/churn.pdf>.
+// The weighted churn rate uses the same formula, but sums up the relays'
+// consensus bandwidth instead of counting relays.
 func determineChurn(prevConsensus, newConsensus *tor.Consensus) Churn {
 
 	goneRelays := prevConsensus.Subtract(newConsensus)
@@ -117,143 +226,358 @@ func determineChurn(prevConsensus, newConsensus *tor.Consensus) Churn {
 	newChurn := (float64(newRelays.Length()) / max)
 	goneChurn := (float64(goneRelays.Length()) / max)
 
-	return Churn{newChurn, goneChurn}
+	// Consensuses without bandwidth values have no weighted churn.
+	var newWeighted, goneWeighted float64
+	maxWeight := math.Max(consensusWeight(prevConsensus), consensusWeight(newConsensus))
+	if maxWeight > 0 {
+		newWeighted = consensusWeight(newRelays) / maxWeight
+		goneWeighted = consensusWeight(goneRelays) / maxWeight
+	}
+
+	return Churn{newChurn, goneChurn, newWeighted, goneWeighted}
 }
 
-// FilterConsensusByFlag filters the given consensus so that only relays with
-// the given flag remain.  The resulting consensus is returned.
-func FilterConsensusByFlag(consensus *tor.Consensus, flag string) *tor.Consensus {
+// printChurnHeader prints the CSV header for the given columns, either in long
+// or wide format.
+func printChurnHeader(columns []*FlagExpr, params *CmdLineParams) {
 
-	filteredConsensus := tor.NewConsensus()
+	fmt.Print("Date")
+	for _, column := range columns {
+		if params.CSVFormat == longCSVFormat {
+			fmt.Printf(",%s", column.Name)
+		} else {
+			fmt.Printf(",New%s,Gone%s,NewWeighted%s,GoneWeighted%s", column.Name, column.Name, column.Name, column.Name)
+		}
+	}
+	if params.CSVFormat == longCSVFormat {
+		fmt.Print(",NewChurn,GoneChurn,NewWeightedChurn,GoneWeightedChurn")
+	}
+	fmt.Println()
+}
 
-	for fingerprint, getStatus := range consensus.RouterStatuses {
-		status := getStatus()
+// churnColumns returns the columns of the churn analysis: the flags that the
+// given status flags know, followed by the user's flag expressions.
+func churnColumns(flags []string, params *CmdLineParams) []*FlagExpr {
+
+	var columns []*FlagExpr
+	known := make(map[string]bool)
+	for _, flag := range flags {
+		columns = append(columns, flagExprFor(flag))
+		known[flag] = true
+	}
 
-		s := reflect.ValueOf(&status.Flags).Elem()
-		flagSet := s.FieldByName(flag).Interface()
-		// Check if relay has the flag we are looking for.
-		if flagSet == true {
-			filteredConsensus.Set(fingerprint, status)
+	for _, expr := range params.FlagExprs {
+		if known[expr.Name] {
+			log.Fatalf("Flag expression \"%s\" has the name of a flag.\n", expr.Name)
+		}
+		for _, flag := range expr.Flags() {
+			if !known[flag] {
+				log.Printf("Flag expression \"%s\" references flag \"%s\", which the first consensus doesn't know.\n", expr.Name, flag)
+			}
 		}
+		columns = append(columns, expr)
 	}
 
-	return filteredConsensus
+	return columns
 }
 
-// DeterminePerFlagChurn determines the churn rate between two subsequent
-// consensuses for all relays with a given flag.  For example, for all relays
-// with the "Guard" flag, we get a churn value for relays that went online and a
-// churn value for relays that went offline.  A set of relays is dumped to
-// stderr once a churn value exceeds the given threshold.
-func DeterminePerFlagChurn(prevConsensus, newConsensus *tor.Consensus, movAvg PerFlagMovAvg, params *CmdLineParams) {
+// printChurnRow prints the given per-flag churn values for the given date in
+// the configured CSV format.  Flags whose value is missing, e.g., because of a
+// gap in the data, are printed as NA.
+func printChurnRow(date time.Time, columns []*FlagExpr, perFlag map[string]*Churn, params *CmdLineParams) {
 
-	var line string
+	dateStr := date.Format("2006-01-02T15:04:05Z")
+	format := func(churn *Churn) string {
+		if churn == nil {
+			return ",NA,NA,NA,NA"
+		}
+		return fmt.Sprintf(",%.5f,%.5f,%.5f,%.5f", churn.Online, churn.Offline,
+			churn.WeightedOnline, churn.WeightedOffline)
+	}
 
-	for _, flag := range RelayFlags {
+	if params.CSVFormat == longCSVFormat {
+		for _, column := range columns {
+			fmt.Printf("%s", dateStr)
+			for _, noColumn := range columns {
+				if noColumn != column {
+					fmt.Printf(",NA")
+				} else {
+					fmt.Printf(",T")
+				}
+			}
+			fmt.Println(format(perFlag[column.Name]))
+		}
+		return
+	}
 
-		prevFiltered := FilterConsensusByFlag(prevConsensus, flag)
-		newFiltered := FilterConsensusByFlag(newConsensus, flag)
-		churn := determineChurn(prevFiltered, newFiltered)
+	line := dateStr
+	for _, column := range columns {
+		line += format(perFlag[column.Name])
+	}
+	fmt.Println(line)
+}
 
-		// Determine moving average for captured churn values.
-		movAvg[flag].AddValue(churn)
-		churn = movAvg[flag].CalcAvg()
-		if !movAvg[flag].IsWindowFull() {
+// printMissingHours prints NA rows for the hours between the two given
+// consensuses, so that plots show a hole instead of misleading continuity.
+func printMissingHours(prevConsensus, newConsensus *tor.Consensus, columns []*FlagExpr, params *CmdLineParams) {
+
+	last := newConsensus.ValidAfter.Truncate(time.Hour)
+	for hour := prevConsensus.ValidAfter.Truncate(time.Hour).Add(time.Hour); hour.Before(last); hour = hour.Add(time.Hour) {
+		printChurnRow(hour, columns, nil, params)
+	}
+}
+
+// DeterminePerFlagChurn determines the churn rate between two consensuses for
+// all relays with a given flag, or whose flags satisfy a given flag
+// expression.  For example, for all relays with the "Guard" flag, we get a
+// churn value for relays that went online and a churn value for relays that
+// went offline, both by relay count and weighted by consensus bandwidth.  If
+// one of the consensuses doesn't know a flag that a column references, the
+// column's churn is missing.  Once a churn value exceeds the given threshold
+// in the configured churn metric, or, if the adaptive detector is configured,
+// once it is a spike with respect to the flag's baselines, the relays that
+// joined or left are written to churn-spikes.csv or churn-spikes.json in the
+// output directory.
+//
+// The consensuses can be several hours apart, in which case the churn is
+// normalised per hour.  If interpolate is true, the hours in between are
+// assigned the normalised churn, as if relays had joined and left at a
+// constant rate.  Bridges is true if the consensuses are bridge network
+// statuses.
+func DeterminePerFlagChurn(prevConsensus, newConsensus *FlaggedConsensus, columns []*FlagExpr, interpolate, bridges bool, movAvg PerFlagMovAvg, detectors PerFlagDetector, recorder *ChurnRecorder, params *CmdLineParams) {
+
+	hours := int(newConsensus.ValidAfter.Truncate(time.Hour).Sub(prevConsensus.ValidAfter.Truncate(time.Hour)) / time.Hour)
+	if hours < 1 {
+		hours = 1
+	}
+
+	perHour := make(map[string]Churn)
+	prevFiltered := make(map[string]*tor.Consensus)
+	newFiltered := make(map[string]*tor.Consensus)
+	for _, column := range columns {
+		if !column.Computable(prevConsensus.Flags) || !column.Computable(newConsensus.Flags) {
 			continue
 		}
+		flag := column.Name
+		prevFiltered[flag] = FilterConsensusByExpr(prevConsensus, column)
+		newFiltered[flag] = FilterConsensusByExpr(newConsensus, column)
+		churn := determineChurn(prevFiltered[flag], newFiltered[flag])
+		perHour[flag] = churn.Scale(1 / float64(hours))
+	}
 
-		if churn.Online >= params.Threshold {
-			dumpChurnRelays(newFiltered.Subtract(prevFiltered), "+"+flag, newConsensus.ValidAfter)
-		}
-		if churn.Offline >= params.Threshold {
-			dumpChurnRelays(prevFiltered.Subtract(newFiltered), "-"+flag, newConsensus.ValidAfter)
+	first := hours
+	if interpolate {
+		first = 1
+	}
+	for hour := first; hour <= hours; hour++ {
+
+		date := newConsensus.ValidAfter
+		if hour < hours {
+			date = prevConsensus.ValidAfter.Truncate(time.Hour).Add(time.Duration(hour) * time.Hour)
 		}
 
-		if params.CSVFormat == longCSVFormat {
-			fmt.Printf("%s", newConsensus.ValidAfter.Format("2006-01-02T15:04:05Z"))
-			for _, noFlag := range RelayFlags {
-				if noFlag != flag {
-					fmt.Printf(",NA")
-				} else {
-					fmt.Printf(",T")
-				}
+		perFlag := make(map[string]*Churn)
+		for _, column := range columns {
+			flag := column.Name
+			if _, exists := perHour[flag]; !exists {
+				continue
 			}
-			fmt.Printf(",%.5f,%.5f\n", churn.Online, churn.Offline)
-		} else {
-			if line == "" {
-				line += fmt.Sprintf("%s", newConsensus.ValidAfter.Format("2006-01-02T15:04:05Z"))
+
+			// Determine moving average for captured churn values.
+			movAvg[flag].AddValue(perHour[flag])
+			churn := movAvg[flag].CalcAvg()
+			if !movAvg[flag].IsWindowFull() {
+				continue
+			}
+			perFlag[flag] = &churn
+
+			// Interpolated hours have no relays to dump, and we keep them
+			// out of the baselines because we made them up.
+			if hour < hours {
+				continue
+			}
+
+			dumpNew := churn.Exceeds(params.Threshold, Appeared, params.ChurnMetric)
+			dumpGone := churn.Exceeds(params.Threshold, Disappeared, params.ChurnMetric)
+			var newScore, goneScore *ChurnScore
+			if params.ChurnDetector == adaptiveDetector {
+				newScore = detectors[flag].Spike(date, churn, Appeared, params)
+				goneScore = detectors[flag].Spike(date, churn, Disappeared, params)
+				dumpNew, dumpGone = newScore != nil, goneScore != nil
+				detectors[flag].Add(date, churn)
+			}
+
+			if dumpNew {
+				dumpChurnRelays(recorder, newFiltered[flag].Subtract(prevFiltered[flag]), newConsensus, bridges, flag, Appeared, churn, newScore, newConsensus.ValidAfter, params)
+			}
+			if dumpGone {
+				dumpChurnRelays(recorder, prevFiltered[flag].Subtract(newFiltered[flag]), prevConsensus, bridges, flag, Disappeared, churn, goneScore, newConsensus.ValidAfter, params)
 			}
-			line += fmt.Sprintf(",%.5f,%.5f", churn.Online, churn.Offline)
 		}
-	}
 
-	if line != "" {
-		fmt.Println(line)
+		// Until the moving average windows are full, there's nothing to
+		// print.
+		if len(perFlag) > 0 {
+			printChurnRow(date, columns, perFlag, params)
+		}
 	}
 }
 
 // AnalyseChurn determines the churn rates of a set of consecutive consensuses.
 // If the churn rate exceeds the given threshold, all new and disappeared
-// relays are dumped to stderr.
+// relays are written to the output directory.
 func AnalyseChurn(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
 
 	defer group.Done()
 
-	var newConsensus, prevConsensus *tor.Consensus
+	var newConsensus, prevConsensus *FlaggedConsensus
+	var columns []*FlagExpr
 
 	if params.WindowSize <= 0 {
 		log.Printf("Window size set to %d, but cannot be smaller than 1.  Setting it to 1.", params.WindowSize)
 		params.WindowSize = 1
 	}
 
-	log.Printf("Threshold for churn analysis is %.5f.\n", params.Threshold)
+	if params.ChurnDetector == adaptiveDetector {
+		log.Printf("Reporting churn spikes with a score of at least %.2f over %d-day baselines, applied to the '%s' churn metric.\n",
+			churnAnomalyScore(params), params.BaselineDays, params.ChurnMetric)
+	} else {
+		log.Printf("Threshold for churn analysis is %.5f, applied to the '%s' churn metric.\n", params.Threshold, params.ChurnMetric)
+	}
 
-	// Print CSV header, either in long or wide format.
-	fmt.Print("Date")
-	for _, flag := range RelayFlags {
-		if params.CSVFormat == longCSVFormat {
-			fmt.Printf(",%s", flag)
-		} else {
-			fmt.Printf(",New%s,Gone%s", flag, flag)
+	recorder, err := NewChurnRecorder(params.ChurnRecords, params.Investigate, params.Resume)
+	if err != nil {
+		log.Fatalf("Could not create churn spike file: %s\n", err)
+	}
+	defer recorder.Close()
+
+	movAvg := make(PerFlagMovAvg)
+	detectors := make(PerFlagDetector)
+
+	state := churnState{MovAvg: movAvg, Detectors: detectors}
+	resumeTime := params.Checkpoint.Restore("churn", &state)
+	// Checkpoints of older versions lack the flags, so we start over with
+	// the next consensus.
+	if state.PrevConsensus != nil && state.PrevFlags != nil {
+		prevConsensus = &FlaggedConsensus{state.PrevConsensus.Consensus(), state.PrevFlags}
+	}
+	movAvg = state.MovAvg
+	docType := state.DocType
+	detectors = state.Detectors
+
+	// The columns are the flags of the first consensus, followed by the
+	// user's flag expressions.  We can't change them once we printed the
+	// CSV header, so we only report flags that later consensuses add.
+	flags := state.Flags
+	reportedFlags := make(map[string]bool)
+	setColumns := func(consensus *FlaggedConsensus) {
+		if flags == nil {
+			flags = consensus.Flags.Known()
+		}
+		for _, flag := range flags {
+			reportedFlags[flag] = true
+		}
+		columns = churnColumns(flags, params)
+		for _, column := range columns {
+			if movAvg[column.Name] == nil {
+				movAvg[column.Name] = NewMovingAverage(params.WindowSize)
+			}
+			if detectors[column.Name] == nil {
+				detectors[column.Name] = NewChurnDetector(params.BaselineDays)
+			}
 		}
+		printChurnHeader(columns, params)
 	}
-	if params.CSVFormat == longCSVFormat {
-		fmt.Print(",NewChurn,GoneChurn")
+	if prevConsensus != nil {
+		setColumns(prevConsensus)
 	}
-	fmt.Println()
 
-	movAvg := make(PerFlagMovAvg)
-	for _, flag := range RelayFlags {
-		movAvg[flag] = NewMovingAverage(params.WindowSize)
+	reportNewFlags := func(consensus *FlaggedConsensus) {
+		for _, flag := range consensus.Flags.Known() {
+			if !reportedFlags[flag] {
+				reportedFlags[flag] = true
+				log.Printf("Consensus at %s knows flag \"%s\", which has no column.  Use -churn-flags to add it.\n",
+					consensus.ValidAfter.Format(time.RFC3339), flag)
+			}
+		}
+	}
+
+	saveState := func() {
+		if params.Checkpoint != nil && prevConsensus != nil {
+			params.Checkpoint.Save("churn", prevConsensus.ValidAfter, &churnState{docType, newCachedConsensus(prevConsensus.Consensus),
+				movAvg, detectors, prevConsensus.Flags, flags})
+		}
 	}
+	// Save our state once we're done, including after an interruption.
+	defer saveState()
 
 	// Every loop iteration processes one consensus.  We compare consensus t
 	// to consensus t - 1.
 	for objects := range channel {
 
+		// We report every consensus as it arrives, so there's nothing left to
+		// report at the end of a batch in follow mode.
+		if objects == EndOfBatch || alreadyProcessed(objects, resumeTime) {
+			continue
+		}
+
 		switch obj := objects.(type) {
-		case *tor.Consensus:
-			newConsensus = obj
+		case *Consensus, *MicrodescConsensus, *BridgeNetworkStatus:
+			newConsensus = AsFlaggedConsensus(obj)
 		default:
 			log.Fatalln("Only router status files are supported for churn analysis.")
 		}
 
+		var err error
+		if docType, err = checkDocumentType(docType, objects); err != nil {
+			log.Fatalln(err)
+		}
+		_, bridges := objects.(*BridgeNetworkStatus)
+
 		if prevConsensus == nil {
+			setColumns(newConsensus)
 			prevConsensus = newConsensus
 			continue
 		}
+		reportNewFlags(newConsensus)
+
+		// The bridge authority publishes several network statuses per hour.
+		// We only compare the first status of every hour, so that churn
+		// rates of bridges and relays are comparable.
+		if newConsensus.ValidAfter.Truncate(time.Hour).Equal(prevConsensus.ValidAfter.Truncate(time.Hour)) {
+			continue
+		}
 
 		// Are we missing consensuses?
-		if prevConsensus.ValidAfter.Add(time.Hour) != newConsensus.ValidAfter {
-			log.Printf("Missing consensuses between %s and %s.\n",
+		interpolate := false
+		if !prevConsensus.ValidAfter.Truncate(time.Hour).Add(time.Hour).Equal(newConsensus.ValidAfter.Truncate(time.Hour)) {
+			log.Printf("Missing consensuses between %s and %s.  Using gap policy '%s'.\n",
 				prevConsensus.ValidAfter.Format(time.RFC3339),
-				newConsensus.ValidAfter.Format(time.RFC3339))
-			prevConsensus = newConsensus
-			continue
+				newConsensus.ValidAfter.Format(time.RFC3339), params.ChurnGaps)
+
+			switch params.ChurnGaps {
+			case skipGaps:
+				// Start over after the gap, so that the moving averages
+				// don't carry stale values across it.
+				printMissingHours(prevConsensus.Consensus, newConsensus.Consensus, columns, params)
+				for _, column := range columns {
+					movAvg[column.Name] = NewMovingAverage(params.WindowSize)
+				}
+				prevConsensus = newConsensus
+				continue
+			case normaliseGaps:
+				printMissingHours(prevConsensus.Consensus, newConsensus.Consensus, columns, params)
+			case interpolateGaps:
+				interpolate = true
+			}
 		}
 
-		DeterminePerFlagChurn(prevConsensus, newConsensus, movAvg, params)
+		DeterminePerFlagChurn(prevConsensus, newConsensus, columns, interpolate, bridges, movAvg, detectors, recorder, params)
 
 		prevConsensus = newConsensus
+
+		if params.Checkpoint.Due("churn") {
+			saveState()
+		}
 	}
 }
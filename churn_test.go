@@ -0,0 +1,203 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+func TestCheckDocumentType(t *testing.T) {
+
+	docType, err := checkDocumentType("", consensusAt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docType, err = checkDocumentType(docType, consensusAt(1)); err != nil {
+		t.Errorf("Expected consensuses to be accepted but got: %s", err)
+	}
+
+	microdescConsensus := NewMicrodescConsensus()
+	microdescConsensus.ValidAfter = testEpoch.Add(2 * time.Hour)
+	if _, err = checkDocumentType(docType, microdescConsensus); err == nil {
+		t.Error("Expected error for consensuses mixed with microdescriptor consensuses.")
+	}
+	if _, err = checkDocumentType(docType, NewBridgeNetworkStatus()); err == nil {
+		t.Error("Expected error for consensuses mixed with bridge network statuses.")
+	}
+}
+
+// bridgeStatusAt returns a bridge network status that was published the given
+// number of minutes after testEpoch, and lists running bridges with the given
+// fingerprints.
+func bridgeStatusAt(minutes int, fprs ...tor.Fingerprint) *BridgeNetworkStatus {
+
+	bridgeStatus := NewBridgeNetworkStatus()
+	bridgeStatus.ValidAfter = testEpoch.Add(time.Duration(minutes) * time.Minute)
+	for _, fpr := range fprs {
+		status := &tor.RouterStatus{Fingerprint: fpr, Nickname: string(fpr)}
+		status.Flags.Running = true
+		bridgeStatus.Set(fpr, status)
+		bridgeStatus.Flags[fpr] = []string{"Running"}
+	}
+
+	return bridgeStatus
+}
+
+// runningColumn returns the index of the NewRunning column in the given CSV
+// header.
+func runningColumn(header string) int {
+
+	for i, column := range strings.Split(header, ",") {
+		if column == "NewRunning" {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// runningConsensusAt returns a consensus that became valid the given number of
+// hours after testEpoch, and lists running relays with the given fingerprints.
+func runningConsensusAt(hour int, fprs ...tor.Fingerprint) *Consensus {
+
+	consensus := consensusAt(hour)
+	consensus.KnownFlags = []string{"Running"}
+	for _, fpr := range fprs {
+		status := &tor.RouterStatus{Fingerprint: fpr, Nickname: string(fpr)}
+		status.Flags.Running = true
+		consensus.Set(fpr, status)
+		consensus.Flags[fpr] = []string{"Running"}
+	}
+
+	return consensus
+}
+
+func TestAnalyseChurnGaps(t *testing.T) {
+
+	defer useTestOutputDir(t)()
+
+	// Consensuses at 02:00 and 03:00 are missing, and one of the two
+	// relays was replaced in the meantime.
+	consensuses := []tor.ObjectSet{
+		runningConsensusAt(0, "AAAA", "BBBB"),
+		runningConsensusAt(1, "AAAA", "BBBB"),
+		runningConsensusAt(4, "AAAA", "CCCC"),
+		runningConsensusAt(5, "AAAA", "CCCC"),
+	}
+
+	// The churn of running relays in every row, by the hour of the row.
+	tests := []struct {
+		gaps     string
+		expected map[string]string
+	}{
+		// The gap breaks the comparison, so there's no row for 04:00.
+		{skipGaps, map[string]string{"01": "0.00000", "02": "NA", "03": "NA", "05": "0.00000"}},
+		// One new relay out of two over three hours.
+		{normaliseGaps, map[string]string{"01": "0.00000", "02": "NA", "03": "NA", "04": "0.16667", "05": "0.00000"}},
+		{interpolateGaps, map[string]string{"01": "0.00000", "02": "0.16667", "03": "0.16667", "04": "0.16667", "05": "0.00000"}},
+	}
+
+	for _, test := range tests {
+		params := &CmdLineParams{WindowSize: 1, Threshold: 2, CSVFormat: wideCSVFormat, ChurnGaps: test.gaps, ChurnRecords: csvRecords}
+		lines := strings.Split(strings.TrimSpace(runAnalysis(t, AnalyseChurn, params, consensuses...)), "\n")
+		running := runningColumn(lines[0])
+
+		rows := lines[1:]
+		if len(rows) != len(test.expected) {
+			t.Errorf("%s: expected %d rows but got %d.", test.gaps, len(test.expected), len(rows))
+			continue
+		}
+		for _, line := range rows {
+			row := strings.Split(line, ",")
+			hour := row[0][len("2015-08-01T") : len("2015-08-01T")+2]
+			if expected, exists := test.expected[hour]; !exists || row[running] != expected {
+				t.Errorf("%s: expected churn %q at %s:00 but got %s.", test.gaps, expected, hour, line)
+			}
+		}
+	}
+}
+
+func TestAnalyseChurnBridges(t *testing.T) {
+
+	defer useTestOutputDir(t)()
+
+	params := &CmdLineParams{WindowSize: 1, Threshold: 2, CSVFormat: wideCSVFormat, ChurnRecords: csvRecords}
+
+	// The bridge authority publishes every half hour.  Only the first status
+	// of every hour counts, so the bridge that is briefly gone at 00:34 is
+	// no churn.  Bridges are keyed by their hashed fingerprint, which stays
+	// the same across statuses.
+	output := runAnalysis(t, AnalyseChurn, params,
+		bridgeStatusAt(4, "AAAA", "BBBB"),
+		bridgeStatusAt(34, "AAAA"),
+		bridgeStatusAt(64, "AAAA", "BBBB"),
+		bridgeStatusAt(124, "AAAA", "CCCC"))
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header and 2 rows but got:\n%s", output)
+	}
+	if !strings.HasPrefix(lines[1], "2015-08-01T01:04:00Z") {
+		t.Errorf("Expected first row at 01:04 but got %s.", lines[1])
+	}
+
+	// Churn of running bridges.
+	running := runningColumn(lines[0])
+	if row := strings.Split(lines[1], ","); row[running] != "0.00000" || row[running+1] != "0.00000" {
+		t.Errorf("Expected no churn at 01:04 but got %s.", lines[1])
+	}
+	if row := strings.Split(lines[2], ","); row[running] != "0.50000" || row[running+1] != "0.50000" {
+		t.Errorf("Expected churn of 0.5 at 02:04 but got %s.", lines[2])
+	}
+}
+
+func TestDetermineChurnWeighted(t *testing.T) {
+
+	prevConsensus := consensusAt(0)
+	prevConsensus.Set("AAAA", &tor.RouterStatus{Fingerprint: "AAAA", Bandwidth: 100})
+	prevConsensus.Set("BBBB", &tor.RouterStatus{Fingerprint: "BBBB", Bandwidth: 300})
+	newConsensus := consensusAt(1)
+	newConsensus.Set("AAAA", &tor.RouterStatus{Fingerprint: "AAAA", Bandwidth: 100})
+	newConsensus.Set("CCCC", &tor.RouterStatus{Fingerprint: "CCCC", Bandwidth: 100})
+
+	// Half of the relays changed, but the one that left carried three
+	// quarters of the consensus weight.
+	expected := Churn{0.5, 0.5, 0.25, 0.75}
+	if churn := determineChurn(prevConsensus.Consensus, newConsensus.Consensus); churn != expected {
+		t.Errorf("Expected churn %v but got %v.", expected, churn)
+	}
+
+	// Consensuses without bandwidth values have no weighted churn.
+	expected = Churn{0.5, 0.5, 0, 0}
+	if churn := determineChurn(runningConsensusAt(0, "AAAA", "BBBB").Consensus, runningConsensusAt(1, "AAAA", "CCCC").Consensus); churn != expected {
+		t.Errorf("Expected churn %v but got %v.", expected, churn)
+	}
+}
+
+func TestChurnExceeds(t *testing.T) {
+
+	churn := Churn{0.5, 0.5, 0.25, 0.75}
+
+	tests := []struct {
+		online    bool
+		threshold float64
+		metric    string
+		expected  bool
+	}{
+		{Appeared, 0.5, countMetric, true},
+		{Appeared, 0.5, bandwidthMetric, false},
+		{Appeared, 0.5, eitherMetric, true},
+		{Disappeared, 0.6, countMetric, false},
+		{Disappeared, 0.6, bandwidthMetric, true},
+		{Disappeared, 0.6, eitherMetric, true},
+		{Disappeared, 0.8, eitherMetric, false},
+	}
+
+	for _, test := range tests {
+		if got := churn.Exceeds(test.threshold, test.online, test.metric); got != test.expected {
+			t.Errorf("Expected %t for online=%t, threshold %.1f, and metric %s but got %t.", test.expected, test.online, test.threshold, test.metric, got)
+		}
+	}
+}
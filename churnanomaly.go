@@ -0,0 +1,194 @@
+// Detects churn spikes by comparing churn values to adaptive per-flag
+// baselines rather than to a fixed threshold.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Churn values whose robust z-score reaches this value are spikes, unless
+	// -threshold is given.
+	defaultChurnAnomalyScore = 3.5
+	// The rolling baseline must hold at least a day of churn values before
+	// we score anything.
+	minRollingSamples = 24
+	// The hour-of-day baseline must hold at least this many days before we
+	// take it into account.
+	minHourlySamples = 3
+	// Flags like Authority barely churn, so their median absolute deviation
+	// is often 0.  We use this as the smallest deviation, so that tiny
+	// changes don't result in huge scores.
+	minChurnDeviation = 0.001
+
+	rollingBaseline = "rolling"
+	hourlyBaseline  = "hour-of-day"
+)
+
+// ChurnScore is the anomaly score of a churn value, together with the baseline
+// it was compared to.
+type ChurnScore struct {
+	Score     float64 `json:"score"`
+	Metric    string  `json:"metric"`
+	Baseline  string  `json:"baseline"`
+	Median    float64 `json:"median"`
+	Deviation float64 `json:"deviation"`
+}
+
+// ChurnBaseline holds past values of a churn series, both in the order they
+// arrived and by hour of day.
+type ChurnBaseline struct {
+	Days    int
+	Rolling []float64
+	Hourly  [24][]float64
+}
+
+// NewChurnBaseline allocates and returns a new churn baseline that covers the
+// given number of days.
+func NewChurnBaseline(days int) *ChurnBaseline {
+
+	return &ChurnBaseline{Days: days}
+}
+
+// appendBounded appends the given value to the given values, and drops the
+// oldest values if there are more than max of them.
+func appendBounded(values []float64, value float64, max int) []float64 {
+
+	values = append(values, value)
+	if len(values) > max {
+		values = values[len(values)-max:]
+	}
+
+	return values
+}
+
+// Add adds the given churn value of the given date to the baseline.
+func (baseline *ChurnBaseline) Add(date time.Time, value float64) {
+
+	baseline.Rolling = appendBounded(baseline.Rolling, value, 24*baseline.Days)
+	hour := date.Hour()
+	baseline.Hourly[hour] = appendBounded(baseline.Hourly[hour], value, baseline.Days)
+}
+
+// robustScore returns the robust z-score of the given value with respect to
+// the given past values, along with their median and the scaled median
+// absolute deviation.
+func robustScore(value float64, past []float64) (float64, float64, float64) {
+
+	med, mad := medianDeviation(past)
+	mad = math.Max(mad, minChurnDeviation)
+
+	return (value - med) / mad, med, mad
+}
+
+// Score returns the score of the given churn value of the given date.  It
+// returns false if the baseline doesn't hold enough values yet.
+func (baseline *ChurnBaseline) Score(date time.Time, value float64) (*ChurnScore, bool) {
+
+	if len(baseline.Rolling) < minRollingSamples {
+		return nil, false
+	}
+
+	score, med, mad := robustScore(value, baseline.Rolling)
+	result := &ChurnScore{Score: score, Baseline: rollingBaseline, Median: med, Deviation: mad}
+
+	// Some hours see more churn than others, e.g., because many relays
+	// restart at midnight.  Once we know enough days, we also compare the
+	// value to the same hour on previous days, and keep the lower score, so
+	// that only values that are unusual for both baselines are spikes.
+	if hourly := baseline.Hourly[date.Hour()]; len(hourly) >= minHourlySamples {
+		if score, med, mad := robustScore(value, hourly); score < result.Score {
+			result = &ChurnScore{Score: score, Baseline: hourlyBaseline, Median: med, Deviation: mad}
+		}
+	}
+
+	return result, true
+}
+
+// ChurnDetector keeps baselines for a flag's churn of relays that went online
+// and offline, both by relay count and weighted by consensus bandwidth.
+type ChurnDetector struct {
+	Online          *ChurnBaseline
+	Offline         *ChurnBaseline
+	WeightedOnline  *ChurnBaseline
+	WeightedOffline *ChurnBaseline
+}
+
+// PerFlagDetector maps a relay flag, e.g., "Guard", to a churn detector.
+type PerFlagDetector map[string]*ChurnDetector
+
+// NewChurnDetector allocates and returns a new churn detector whose baselines
+// cover the given number of days.
+func NewChurnDetector(days int) *ChurnDetector {
+
+	return &ChurnDetector{
+		Online:          NewChurnBaseline(days),
+		Offline:         NewChurnBaseline(days),
+		WeightedOnline:  NewChurnBaseline(days),
+		WeightedOffline: NewChurnBaseline(days),
+	}
+}
+
+// Add adds the given churn of the given date to the detector's baselines.
+func (detector *ChurnDetector) Add(date time.Time, churn Churn) {
+
+	detector.Online.Add(date, churn.Online)
+	detector.Offline.Add(date, churn.Offline)
+	detector.WeightedOnline.Add(date, churn.WeightedOnline)
+	detector.WeightedOffline.Add(date, churn.WeightedOffline)
+}
+
+// Score returns the score of the given churn of relays that went online (if
+// online is true) or offline (if online is false) in the given churn metric.
+// For the "either" metric, the higher of both scores is returned.  Score
+// returns false if the baselines don't hold enough values yet.
+func (detector *ChurnDetector) Score(date time.Time, churn Churn, online bool, metric string) (*ChurnScore, bool) {
+
+	count, weighted := detector.Offline, detector.WeightedOffline
+	countValue, weightedValue := churn.Offline, churn.WeightedOffline
+	if online {
+		count, weighted = detector.Online, detector.WeightedOnline
+		countValue, weightedValue = churn.Online, churn.WeightedOnline
+	}
+
+	var best *ChurnScore
+	if metric != bandwidthMetric {
+		if score, ok := count.Score(date, countValue); ok {
+			score.Metric = countMetric
+			best = score
+		}
+	}
+	if metric != countMetric {
+		if score, ok := weighted.Score(date, weightedValue); ok && (best == nil || score.Score > best.Score) {
+			score.Metric = bandwidthMetric
+			best = score
+		}
+	}
+
+	return best, best != nil
+}
+
+// churnAnomalyScore returns the minimum score of churn spikes.
+func churnAnomalyScore(params *CmdLineParams) float64 {
+
+	if params.Threshold <= 0 {
+		return defaultChurnAnomalyScore
+	}
+
+	return params.Threshold
+}
+
+// Spike returns the score of the given churn of relays that went online (if
+// online is true) or offline (if online is false) if the churn is a spike
+// with respect to the detector's baselines, and nil otherwise.
+func (detector *ChurnDetector) Spike(date time.Time, churn Churn, online bool, params *CmdLineParams) *ChurnScore {
+
+	score, ok := detector.Score(date, churn, online, params.ChurnMetric)
+	if !ok || score.Score < churnAnomalyScore(params) {
+		return nil
+	}
+
+	return score
+}
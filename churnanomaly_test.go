@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// noisyChurn returns churn values that alternate around 0.011, so that their
+// median absolute deviation isn't 0.
+func noisyChurn(hour int) float64 {
+
+	return 0.010 + 0.002*float64(hour%2)
+}
+
+func TestChurnBaselineScore(t *testing.T) {
+
+	baseline := NewChurnBaseline(7)
+
+	// Three days of quiet churn, except at midnight, when many relays
+	// restart.
+	for hour := 0; hour < 3*24; hour++ {
+		value := noisyChurn(hour)
+		if hour%24 == 0 {
+			value = 0.2
+		}
+		if hour == minRollingSamples-1 {
+			if _, ok := baseline.Score(testEpoch.Add(time.Duration(hour)*time.Hour), value); ok {
+				t.Error("Expected no score before the baseline holds a day of values.")
+			}
+		}
+		baseline.Add(testEpoch.Add(time.Duration(hour)*time.Hour), value)
+	}
+
+	// Midnight churn stands out from the rolling baseline, but not from
+	// previous midnights.
+	score, ok := baseline.Score(testEpoch.Add(3*24*time.Hour), 0.2)
+	if !ok {
+		t.Fatal("Expected a score for a full baseline.")
+	}
+	if score.Baseline != hourlyBaseline || score.Score != 0 {
+		t.Errorf("Expected score 0 against the %s baseline but got %.2f against the %s baseline.", hourlyBaseline, score.Score, score.Baseline)
+	}
+
+	// The same churn at 05:00 is a spike compared to both baselines.
+	score, _ = baseline.Score(testEpoch.Add((3*24+5)*time.Hour), 0.2)
+	if score.Score < defaultChurnAnomalyScore {
+		t.Errorf("Expected spike at 05:00 but got score %.2f against the %s baseline.", score.Score, score.Baseline)
+	}
+}
+
+func TestRobustScoreMinDeviation(t *testing.T) {
+
+	// Flags that never churn would otherwise turn the tiniest change into an
+	// infinite score.
+	score, med, mad := robustScore(0.002, []float64{0, 0, 0, 0})
+	if med != 0 || mad != minChurnDeviation || score != 2 {
+		t.Errorf("Expected score 2, median 0, and deviation %.3f but got %.2f, %.3f, and %.3f.", minChurnDeviation, score, med, mad)
+	}
+}
+
+func TestChurnDetectorScore(t *testing.T) {
+
+	detector := NewChurnDetector(7)
+	for hour := 0; hour < minRollingSamples; hour++ {
+		value := noisyChurn(hour)
+		detector.Add(testEpoch.Add(time.Duration(hour)*time.Hour), Churn{value, value, value, value})
+	}
+
+	// Few relays appeared, but they carry a lot of consensus weight.
+	date := testEpoch.Add(time.Duration(minRollingSamples) * time.Hour)
+	churn := Churn{0.011, 0.011, 0.5, 0.011}
+
+	tests := []struct {
+		metric   string
+		scored   string
+		expected bool
+	}{
+		{countMetric, countMetric, false},
+		{bandwidthMetric, bandwidthMetric, true},
+		{eitherMetric, bandwidthMetric, true},
+	}
+
+	for _, test := range tests {
+		score, ok := detector.Score(date, churn, Appeared, test.metric)
+		if !ok {
+			t.Fatalf("%s: expected a score for a full baseline.", test.metric)
+		}
+		if score.Metric != test.scored {
+			t.Errorf("%s: expected %s score but got %s score.", test.metric, test.scored, score.Metric)
+		}
+		if spike := score.Score >= defaultChurnAnomalyScore; spike != test.expected {
+			t.Errorf("%s: expected spike to be %t but got score %.2f.", test.metric, test.expected, score.Score)
+		}
+	}
+
+	// Relays that went offline didn't churn unusually.
+	params := &CmdLineParams{ChurnMetric: eitherMetric}
+	if score := detector.Spike(date, churn, Disappeared, params); score != nil {
+		t.Error("Expected no spike of relays that went offline.")
+	}
+}
@@ -0,0 +1,228 @@
+// Investigates churn spikes by checking whether the relays that joined the
+// network look alike.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	// A feature that at least this fraction of a cohort shares suggests that
+	// the cohort is run by a single operator.
+	cohortShareThreshold = 0.5
+	// Cohorts whose descriptors have at least this many similarities on
+	// average, as determined by CalcDescSimilarity, are similar.
+	cohortSimilarityThreshold = 4
+	// We don't investigate cohorts that are smaller than this.
+	minCohortSize = 3
+)
+
+// Runs of digits in nicknames, e.g., in "relay1" and "relay23", are replaced
+// with a placeholder, so that numbered nicknames share a pattern.
+var nicknameDigits = regexp.MustCompile(`[0-9]+`)
+
+// CohortFeature is the most common value of a feature in a cohort, e.g., the
+// most common /24 netblock, together with the fraction of relays that share
+// it.
+type CohortFeature struct {
+	Value string  `json:"value"`
+	Share float64 `json:"share"`
+}
+
+// ChurnCohort summarises what the relays that joined in a churn spike have in
+// common.
+type ChurnCohort struct {
+	Date     time.Time     `json:"date"`
+	Flag     string        `json:"flag"`
+	Relays   int           `json:"relays"`
+	Netblock CohortFeature `json:"netblock"`
+	Version  CohortFeature `json:"version"`
+	ORPort   CohortFeature `json:"or_port"`
+	Nickname CohortFeature `json:"nickname"`
+	// NetworkVersion is the most common version in the consensus that the
+	// cohort joined.
+	NetworkVersion string `json:"network_version"`
+
+	// Descriptors is the number of relays whose descriptors we found, and
+	// MeanSimilarity the mean number of similarities over all pairs of them.
+	Descriptors    int     `json:"descriptors"`
+	MeanSimilarity float64 `json:"mean_similarity"`
+
+	// Indicators lists the features that suggest that the cohort is run by
+	// a single operator.  The cohort is tight if there are any.
+	Indicators []string `json:"indicators"`
+}
+
+// Tight returns true if the cohort is likely run by a single operator.
+func (cohort *ChurnCohort) Tight() bool {
+
+	return len(cohort.Indicators) > 0
+}
+
+// String implements the Stringer interface for pretty printing.
+func (cohort *ChurnCohort) String() string {
+
+	indicators := "none"
+	if cohort.Tight() {
+		indicators = strings.Join(cohort.Indicators, ", ")
+	}
+
+	descs := ""
+	if cohort.Descriptors >= 2 {
+		descs = fmt.Sprintf(", %.2f similarities between %d descriptors", cohort.MeanSimilarity, cohort.Descriptors)
+	}
+
+	netblock := ""
+	if cohort.Netblock.Value != "" {
+		netblock = fmt.Sprintf("%.0f%% in %s, ", cohort.Netblock.Share*100, cohort.Netblock.Value)
+	}
+
+	return fmt.Sprintf("%d relays, %s%.0f%% on version %s (network: %s), %.0f%% on OR port %s, "+
+		"%.0f%% with nickname %s%s; indicators: %s",
+		cohort.Relays, netblock, cohort.Version.Share*100, cohort.Version.Value, cohort.NetworkVersion,
+		cohort.ORPort.Share*100, cohort.ORPort.Value, cohort.Nickname.Share*100, cohort.Nickname.Value,
+		descs, indicators)
+}
+
+// mostCommon returns the most common of the given values, and the fraction of
+// the given total that shares it.  Ties are broken alphabetically, so that the
+// result is deterministic.
+func mostCommon(counts map[string]int, total int) CohortFeature {
+
+	var values []string
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	var feature CohortFeature
+	best := 0
+	for _, value := range values {
+		if counts[value] > best {
+			feature.Value, best = value, counts[value]
+		}
+	}
+	if total > 0 {
+		feature.Share = float64(best) / float64(total)
+	}
+
+	return feature
+}
+
+// nicknamePattern returns the given nickname with digits replaced by "#".
+func nicknamePattern(nickname string) string {
+
+	return nicknameDigits.ReplaceAllString(strings.ToLower(nickname), "#")
+}
+
+// cohortDescSimilarity returns the number of given relays whose descriptors
+// are in the descriptor store, and the mean similarity over all pairs of
+// these descriptors.
+func cohortDescSimilarity(statuses []*tor.RouterStatus, params *CmdLineParams) (int, float64) {
+
+	var descs []*tor.RouterDescriptor
+	for _, status := range statuses {
+		if desc, err := params.Descriptors.GetForStatus(status); err == nil {
+			descs = append(descs, desc)
+		}
+	}
+	if missing := len(statuses) - len(descs); missing > 0 {
+		log.Printf("Could not find descriptors of %d out of %d relays.\n", missing, len(statuses))
+	}
+
+	var total float64
+	pairs := 0
+	for i := 0; i < len(descs); i++ {
+		for j := i + 1; j < len(descs); j++ {
+			total += CalcDescSimilarity(descs[i], descs[j]).SimilarityScore
+			pairs++
+		}
+	}
+	if pairs == 0 {
+		return len(descs), 0
+	}
+
+	return len(descs), total / float64(pairs)
+}
+
+// networkVersion returns the most common version in the given consensus.
+func networkVersion(consensus *tor.Consensus) string {
+
+	versions := make(map[string]int)
+	for _, getStatus := range consensus.RouterStatuses {
+		versions[getStatus().TorVersion]++
+	}
+
+	return mostCommon(versions, consensus.Length()).Value
+}
+
+// InvestigateCohort determines what the given relays, which joined the given
+// consensus with the given flag, have in common.  If -descdir is given, the
+// relays' descriptors are compared as well.  Bridge network statuses are
+// sanitised and list made-up addresses, so if bridges is true, we leave out
+// the netblock.  It returns nil for cohorts that are too small to tell.
+func InvestigateCohort(relays, consensus *tor.Consensus, bridges bool, flag string, date time.Time, params *CmdLineParams) *ChurnCohort {
+
+	if relays.Length() < minCohortSize {
+		return nil
+	}
+
+	cohort := &ChurnCohort{Date: date, Flag: flag, Relays: relays.Length(), NetworkVersion: networkVersion(consensus)}
+	netblocks := make(map[string]int)
+	versions := make(map[string]int)
+	orPorts := make(map[string]int)
+	nicknames := make(map[string]int)
+
+	var statuses []*tor.RouterStatus
+	for _, getStatus := range relays.RouterStatuses {
+		status := getStatus()
+		statuses = append(statuses, status)
+
+		if ipv4 := status.Address.IPv4Address.To4(); ipv4 != nil && !bridges {
+			netblock := net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+			netblocks[netblock.String()]++
+		}
+		versions[status.TorVersion]++
+		orPorts[fmt.Sprintf("%d", status.Address.IPv4ORPort)]++
+		nicknames[nicknamePattern(status.Nickname)]++
+	}
+
+	cohort.Netblock = mostCommon(netblocks, cohort.Relays)
+	cohort.Version = mostCommon(versions, cohort.Relays)
+	cohort.ORPort = mostCommon(orPorts, cohort.Relays)
+	cohort.Nickname = mostCommon(nicknames, cohort.Relays)
+
+	// Most new relays run the network's most common version, and many use
+	// the default OR ports or nickname, so none of these says much about
+	// their operator.
+	if cohort.Netblock.Share >= cohortShareThreshold {
+		cohort.Indicators = append(cohort.Indicators, "netblock")
+	}
+	if cohort.Version.Share >= cohortShareThreshold && cohort.Version.Value != cohort.NetworkVersion {
+		cohort.Indicators = append(cohort.Indicators, "version")
+	}
+	if cohort.ORPort.Share >= cohortShareThreshold && cohort.ORPort.Value != "9001" && cohort.ORPort.Value != "443" {
+		cohort.Indicators = append(cohort.Indicators, "or_port")
+	}
+	if cohort.Nickname.Share >= cohortShareThreshold && cohort.Nickname.Value != "unnamed" {
+		cohort.Indicators = append(cohort.Indicators, "nickname")
+	}
+
+	if params.Descriptors != nil {
+		cohort.Descriptors, cohort.MeanSimilarity = cohortDescSimilarity(statuses, params)
+		if cohort.Descriptors >= minCohortSize && cohort.MeanSimilarity >= cohortSimilarityThreshold {
+			cohort.Indicators = append(cohort.Indicators, "descriptors")
+		}
+	}
+
+	return cohort
+}
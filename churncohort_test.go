@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// cohortAt returns a consensus of relays that joined together, and the
+// consensus that they joined, in which most relays run a newer version.
+func cohortAt(hour int) (*tor.Consensus, *tor.Consensus) {
+
+	relays := consensusAt(hour).Consensus
+	network := consensusAt(hour).Consensus
+	for i := 1; i <= 3; i++ {
+		fpr := tor.Fingerprint(fmt.Sprintf("%040d", i))
+		status := &tor.RouterStatus{Fingerprint: fpr, Nickname: fmt.Sprintf("Relay%d", i), TorVersion: "0.2.4.1",
+			Address: tor.RouterAddress{IPv4Address: net.ParseIP(fmt.Sprintf("10.1.2.%d", i)), IPv4ORPort: 9001}}
+		relays.Set(fpr, status)
+		network.Set(fpr, status)
+	}
+	for i := 4; i <= 10; i++ {
+		fpr := tor.Fingerprint(fmt.Sprintf("%040d", i))
+		network.Set(fpr, &tor.RouterStatus{Fingerprint: fpr, TorVersion: "0.3.5.8"})
+	}
+
+	return relays, network
+}
+
+func TestNicknamePattern(t *testing.T) {
+
+	for nickname, expected := range map[string]string{"Relay1": "relay#", "relay23a4": "relay#a#", "Unnamed": "unnamed"} {
+		if pattern := nicknamePattern(nickname); pattern != expected {
+			t.Errorf("Expected pattern %s for %s but got %s.", expected, nickname, pattern)
+		}
+	}
+}
+
+func TestMostCommon(t *testing.T) {
+
+	// Ties are broken alphabetically.
+	feature := mostCommon(map[string]int{"b": 2, "a": 2, "c": 1}, 5)
+	if feature.Value != "a" || feature.Share != 0.4 {
+		t.Errorf("Expected a with share 0.4 but got %s with share %.2f.", feature.Value, feature.Share)
+	}
+}
+
+func TestInvestigateCohort(t *testing.T) {
+
+	relays, network := cohortAt(0)
+	params := &CmdLineParams{}
+
+	cohort := InvestigateCohort(relays, network, false, "Running", testEpoch, params)
+	if cohort.Netblock.Value != "10.1.2.0/24" || cohort.NetworkVersion != "0.3.5.8" {
+		t.Errorf("Expected netblock 10.1.2.0/24 and network version 0.3.5.8 but got: %s", cohort)
+	}
+	// The default OR port doesn't count.
+	if expected := []string{"netblock", "version", "nickname"}; !reflect.DeepEqual(cohort.Indicators, expected) {
+		t.Errorf("Expected indicators %v but got %v.", expected, cohort.Indicators)
+	}
+
+	// Sanitised bridge addresses say nothing about their operator.
+	cohort = InvestigateCohort(relays, network, true, "Running", testEpoch, params)
+	if cohort.Netblock.Value != "" {
+		t.Errorf("Expected no netblock for bridges but got %s.", cohort.Netblock.Value)
+	}
+	if expected := []string{"version", "nickname"}; !reflect.DeepEqual(cohort.Indicators, expected) {
+		t.Errorf("Expected indicators %v but got %v.", expected, cohort.Indicators)
+	}
+
+	// Relays that run the network's most common version don't stand out.
+	cohort = InvestigateCohort(relays, relays, true, "Running", testEpoch, params)
+	if expected := []string{"nickname"}; !reflect.DeepEqual(cohort.Indicators, expected) {
+		t.Errorf("Expected only the nickname to be an indicator but got %v.", cohort.Indicators)
+	}
+
+	delete(relays.RouterStatuses, "0000000000000000000000000000000000000001")
+	if cohort = InvestigateCohort(relays, network, false, "Running", testEpoch, params); cohort != nil {
+		t.Errorf("Expected no cohort for %d relays but got: %s", relays.Length(), cohort)
+	}
+}
+
+func TestWriteCohortBridges(t *testing.T) {
+
+	defer useTestOutputDir(t)()
+
+	recorder, err := NewChurnRecorder(csvRecords, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relays, network := cohortAt(0)
+	if err := recorder.WriteCohort(InvestigateCohort(relays, network, true, "Running", testEpoch, &CmdLineParams{})); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+
+	blurb, err := ioutil.ReadFile(filepath.Join(outputDir, churnCohortsFile+"."+csvRecords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(blurb)), "\n")
+	expected := "2015-08-01T00:00:00Z,Running,3,NA,NA,0.2.4.1,1.00,0.3.5.8,9001,1.00,relay#,1.00,0,NA,version nickname"
+	if len(lines) != 2 || lines[1] != expected {
+		t.Errorf("Expected cohort %q but got:\n%s", expected, blurb)
+	}
+}
@@ -0,0 +1,262 @@
+// Writes the relays that joined or left the network in a churn spike as
+// structured records to the output directory.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	csvRecords  = "csv"
+	jsonRecords = "json"
+
+	// Churn spikes and the cohorts of relays that joined in them are written
+	// to these files in the output directory, with the record format as
+	// extension.
+	churnRecordsFile = "churn-spikes"
+	churnCohortsFile = "churn-cohorts"
+)
+
+// ChurnedRelay holds the attributes of a relay that joined or left the
+// network.
+type ChurnedRelay struct {
+	Fingerprint tor.Fingerprint `json:"fingerprint"`
+	Nickname    string          `json:"nickname"`
+	Address     string          `json:"address"`
+	ORPort      uint16          `json:"or_port"`
+	DirPort     uint16          `json:"dir_port"`
+	IPv6Address string          `json:"ipv6_address,omitempty"`
+	IPv6ORPort  uint16          `json:"ipv6_or_port,omitempty"`
+	Version     string          `json:"version"`
+	Bandwidth   uint64          `json:"bandwidth"`
+	Flags       []string        `json:"flags"`
+}
+
+// ChurnSpike is a set of relays with a given flag that joined or left the
+// network, together with the churn that made us report them.
+type ChurnSpike struct {
+	Date          time.Time       `json:"date"`
+	Flag          string          `json:"flag"`
+	Direction     string          `json:"direction"`
+	Churn         float64         `json:"churn"`
+	WeightedChurn float64         `json:"weighted_churn"`
+	Score         *ChurnScore     `json:"score,omitempty"`
+	Relays        []*ChurnedRelay `json:"relays"`
+}
+
+// NewChurnedRelay returns the attributes of the relay with the given status
+// and flags.
+func NewChurnedRelay(status *tor.RouterStatus, flags []string) *ChurnedRelay {
+
+	relay := &ChurnedRelay{
+		Fingerprint: status.Fingerprint,
+		Nickname:    status.Nickname,
+		ORPort:      status.Address.IPv4ORPort,
+		DirPort:     status.Address.IPv4DirPort,
+		IPv6ORPort:  status.Address.IPv6ORPort,
+		Version:     status.TorVersion,
+		Bandwidth:   status.Bandwidth,
+		Flags:       flags,
+	}
+	if status.Address.IPv4Address != nil {
+		relay.Address = status.Address.IPv4Address.String()
+	}
+	if status.Address.IPv6Address != nil {
+		relay.IPv6Address = status.Address.IPv6Address.String()
+	}
+
+	return relay
+}
+
+// recordFile is a file in the output directory that holds either CSV or JSON
+// records.
+type recordFile struct {
+	fd      *os.File
+	csv     *csv.Writer
+	encoder *json.Encoder
+}
+
+// newRecordFile creates the given file in the output directory, with the given
+// format as extension.  CSV files start with the given header.  If resume is
+// true, records are appended to an existing file.
+func newRecordFile(name, format string, header []string, resume bool) (*recordFile, error) {
+
+	fileName := name + "." + format
+
+	var fd *os.File
+	var err error
+	if resume {
+		var directory string
+		if directory, err = getOutputDir(); err != nil {
+			return nil, err
+		}
+		fd, err = os.OpenFile(filepath.Join(directory, fileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	} else {
+		fd, err = createOutputFile(fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := &recordFile{fd: fd}
+	if format == jsonRecords {
+		file.encoder = json.NewEncoder(fd)
+		return file, nil
+	}
+
+	file.csv = csv.NewWriter(fd)
+	if info, err := fd.Stat(); err == nil && info.Size() == 0 {
+		file.csv.Write(header)
+	}
+
+	return file, nil
+}
+
+// write writes the given record, either as one line of JSON, or as the given
+// CSV rows.
+func (file *recordFile) write(record interface{}, rows [][]string) error {
+
+	if file.encoder != nil {
+		return file.encoder.Encode(record)
+	}
+
+	// WriteAll flushes, so that records show up right away in follow mode.
+	file.csv.WriteAll(rows)
+
+	return file.csv.Error()
+}
+
+// ChurnRecorder writes churn spikes, and optionally the cohorts of relays that
+// joined in them, as CSV or JSON to the output directory.
+type ChurnRecorder struct {
+	spikes  *recordFile
+	cohorts *recordFile
+}
+
+// NewChurnRecorder creates the churn spike file in the given format, and the
+// cohort file if cohorts is true.  If resume is true, records are appended to
+// existing files.
+func NewChurnRecorder(format string, cohorts, resume bool) (*ChurnRecorder, error) {
+
+	recorder := new(ChurnRecorder)
+	var err error
+
+	recorder.spikes, err = newRecordFile(churnRecordsFile, format, []string{"date", "flag", "direction", "churn",
+		"weighted_churn", "score", "baseline", "fingerprint", "nickname", "address", "or_port", "dir_port",
+		"ipv6_address", "ipv6_or_port", "version", "bandwidth", "flags"}, resume)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Writing relays of churn spikes to \"%s\".\n", recorder.spikes.fd.Name())
+
+	if !cohorts {
+		return recorder, nil
+	}
+
+	recorder.cohorts, err = newRecordFile(churnCohortsFile, format, []string{"date", "flag", "relays", "netblock",
+		"netblock_share", "version", "version_share", "network_version", "or_port", "or_port_share", "nickname", "nickname_share",
+		"descriptors", "mean_similarity", "indicators"}, resume)
+	if err != nil {
+		recorder.spikes.fd.Close()
+		return nil, err
+	}
+	log.Printf("Writing cohorts of churn spikes to \"%s\".\n", recorder.cohorts.fd.Name())
+
+	return recorder, nil
+}
+
+// Write writes the given churn spike.  JSON files hold one spike per line,
+// and CSV files one relay per line.
+func (recorder *ChurnRecorder) Write(spike *ChurnSpike) error {
+
+	score, baseline := "NA", "NA"
+	if spike.Score != nil {
+		score = fmt.Sprintf("%.2f", spike.Score.Score)
+		baseline = spike.Score.Baseline
+	}
+
+	var rows [][]string
+	for _, relay := range spike.Relays {
+		address, ipv6Address, ipv6ORPort := "NA", "NA", "NA"
+		if relay.Address != "" {
+			address = relay.Address
+		}
+		if relay.IPv6Address != "" {
+			ipv6Address = relay.IPv6Address
+			ipv6ORPort = strconv.Itoa(int(relay.IPv6ORPort))
+		}
+
+		rows = append(rows, []string{
+			spike.Date.Format("2006-01-02T15:04:05Z"),
+			spike.Flag,
+			spike.Direction,
+			fmt.Sprintf("%.5f", spike.Churn),
+			fmt.Sprintf("%.5f", spike.WeightedChurn),
+			score,
+			baseline,
+			string(relay.Fingerprint),
+			relay.Nickname,
+			address,
+			strconv.Itoa(int(relay.ORPort)),
+			strconv.Itoa(int(relay.DirPort)),
+			ipv6Address,
+			ipv6ORPort,
+			relay.Version,
+			strconv.FormatUint(relay.Bandwidth, 10),
+			strings.Join(relay.Flags, " ")})
+	}
+
+	return recorder.spikes.write(spike, rows)
+}
+
+// WriteCohort writes the given cohort.  Both JSON and CSV files hold one
+// cohort per line.
+func (recorder *ChurnRecorder) WriteCohort(cohort *ChurnCohort) error {
+
+	netblock, netblockShare, meanSimilarity := "NA", "NA", "NA"
+	if cohort.Netblock.Value != "" {
+		netblock = cohort.Netblock.Value
+		netblockShare = fmt.Sprintf("%.2f", cohort.Netblock.Share)
+	}
+	if cohort.Descriptors >= 2 {
+		meanSimilarity = fmt.Sprintf("%.2f", cohort.MeanSimilarity)
+	}
+
+	return recorder.cohorts.write(cohort, [][]string{{
+		cohort.Date.Format("2006-01-02T15:04:05Z"),
+		cohort.Flag,
+		strconv.Itoa(cohort.Relays),
+		netblock,
+		netblockShare,
+		cohort.Version.Value,
+		fmt.Sprintf("%.2f", cohort.Version.Share),
+		cohort.NetworkVersion,
+		cohort.ORPort.Value,
+		fmt.Sprintf("%.2f", cohort.ORPort.Share),
+		cohort.Nickname.Value,
+		fmt.Sprintf("%.2f", cohort.Nickname.Share),
+		strconv.Itoa(cohort.Descriptors),
+		meanSimilarity,
+		strings.Join(cohort.Indicators, " ")}})
+}
+
+// Close closes the recorder's files.
+func (recorder *ChurnRecorder) Close() error {
+
+	if recorder.cohorts != nil {
+		recorder.cohorts.fd.Close()
+	}
+
+	return recorder.spikes.fd.Close()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// useTestOutputDir points the output directory to a new temporary directory,
+// and returns a function that removes it again.
+func useTestOutputDir(t *testing.T) func() {
+
+	dir, err := ioutil.TempDir("", "sybilhunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDir := outputDir
+	outputDir = dir
+
+	return func() {
+		outputDir = prevDir
+		os.RemoveAll(dir)
+	}
+}
+
+// testSpike returns a spike of two relays that joined the network.
+func testSpike() *ChurnSpike {
+
+	alice := &tor.RouterStatus{Fingerprint: "AAAA", Nickname: "alice", TorVersion: "0.3.5.8", Bandwidth: 100,
+		Address: tor.RouterAddress{IPv4Address: net.ParseIP("1.1.1.1"), IPv4ORPort: 9001,
+			IPv6Address: net.ParseIP("2001:db8::1"), IPv6ORPort: 9002}}
+	bob := &tor.RouterStatus{Fingerprint: "BBBB", Nickname: "bob", TorVersion: "0.3.5.8", Bandwidth: 200,
+		Address: tor.RouterAddress{IPv4Address: net.ParseIP("2.2.2.2"), IPv4ORPort: 443, IPv4DirPort: 80}}
+
+	return &ChurnSpike{
+		Date:      testEpoch,
+		Flag:      "Running",
+		Direction: "joined",
+		Churn:     0.5,
+		Relays:    []*ChurnedRelay{NewChurnedRelay(alice, []string{"Guard", "Running"}), NewChurnedRelay(bob, []string{"Running"})},
+	}
+}
+
+func TestChurnRecorderCSV(t *testing.T) {
+
+	defer useTestOutputDir(t)()
+
+	recorder, err := NewChurnRecorder(csvRecords, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Write(testSpike()); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+
+	// Resuming appends to the file without repeating the header.
+	if recorder, err = NewChurnRecorder(csvRecords, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Write(testSpike()); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+
+	blurb, err := ioutil.ReadFile(filepath.Join(outputDir, churnRecordsFile+"."+csvRecords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(blurb)), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "date,flag,direction") {
+		t.Fatalf("Expected header and 4 relays but got:\n%s", blurb)
+	}
+	expected := []string{
+		"2015-08-01T00:00:00Z,Running,joined,0.50000,0.00000,NA,NA,AAAA,alice,1.1.1.1,9001,0,2001:db8::1,9002,0.3.5.8,100,Guard Running",
+		"2015-08-01T00:00:00Z,Running,joined,0.50000,0.00000,NA,NA,BBBB,bob,2.2.2.2,443,80,NA,NA,0.3.5.8,200,Running",
+	}
+	for i, line := range lines[1:] {
+		if line != expected[i%2] {
+			t.Errorf("Expected record %q but got %q.", expected[i%2], line)
+		}
+	}
+}
+
+func TestChurnRecorderJSON(t *testing.T) {
+
+	defer useTestOutputDir(t)()
+
+	recorder, err := NewChurnRecorder(jsonRecords, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spike := testSpike()
+	spike.Score = &ChurnScore{Score: 4.2, Metric: countMetric, Baseline: rollingBaseline}
+	if err := recorder.Write(spike); err != nil {
+		t.Fatal(err)
+	}
+	recorder.Close()
+
+	blurb, err := ioutil.ReadFile(filepath.Join(outputDir, churnRecordsFile+"."+jsonRecords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ChurnSpike
+	if err := json.Unmarshal(blurb, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Relays) != 2 || decoded.Relays[0].IPv6Address != "2001:db8::1" || decoded.Score.Score != 4.2 {
+		t.Errorf("Expected decoded spike to match but got %s.", blurb)
+	}
+}
+
+func TestDumpChurnRelaysSkipsEmptySpikes(t *testing.T) {
+
+	defer useTestOutputDir(t)()
+
+	recorder, err := NewChurnRecorder(jsonRecords, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumpChurnRelays(recorder, tor.NewConsensus(), AsFlaggedConsensus(consensusAt(0)), false, "Guard", Appeared, Churn{Online: 0.5}, nil, testEpoch, &CmdLineParams{})
+	recorder.Close()
+
+	if info, err := os.Stat(recorder.spikes.fd.Name()); err != nil || info.Size() != 0 {
+		t.Errorf("Expected empty spike file but got %v and %v.", info, err)
+	}
+}
@@ -0,0 +1,60 @@
+// Parses network status consensuses, so that we learn about all the flags that
+// the directory authorities vote on, including the ones that zoossh doesn't
+// know about.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// Consensus is a network status consensus.  Analyses that only need router
+// statuses can use the embedded consensus.  Unlike the consensus' router
+// flags, the embedded status flags hold the flags of the consensus'
+// known-flags line, and all flags of its relays.
+type Consensus struct {
+	*tor.Consensus
+	StatusFlags
+}
+
+// NewConsensus allocates and returns a new consensus.
+func NewConsensus() *Consensus {
+
+	return &Consensus{Consensus: tor.NewConsensus(), StatusFlags: newStatusFlags()}
+}
+
+// Merge merges the given object set into the consensus.
+func (consensus *Consensus) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*Consensus)
+	if !ok {
+		return
+	}
+
+	consensus.Consensus.Merge(other.Consensus)
+	consensus.StatusFlags.merge(&other.StatusFlags)
+}
+
+// ParseConsensus parses a network status consensus.
+func ParseConsensus(r io.Reader) (tor.ObjectSet, error) {
+
+	consensus := NewConsensus()
+
+	// Consensuses have no lines that the other network status documents
+	// lack.
+	err := parseStatusDocument(r, consensus.Consensus, &consensus.StatusFlags, parseRouterLine, func(*tor.RouterStatus, []string) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if consensus.ValidAfter.IsZero() {
+		return nil, fmt.Errorf("Consensus has no valid-after time.")
+	}
+
+	return consensus, nil
+}
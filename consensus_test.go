@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// An excerpt of the consensus of 2019-05-01 00:00:00, as published by
+// CollecTor.
+const consensusExcerpt = `@type network-status-consensus-3 1.0
+network-status-version 3
+vote-status consensus
+consensus-method 28
+valid-after 2019-05-01 00:00:00
+fresh-until 2019-05-01 01:00:00
+valid-until 2019-05-01 03:00:00
+voting-delay 300 300
+known-flags Authority BadExit Exit Fast Guard HSDir NoEdConsensus Running Stable StaleDesc V2Dir Valid
+r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 9001 0
+s Running Stable V2Dir Valid
+v Tor 0.3.5.8
+pr Cons=1-2 Desc=1-2 DirCache=1-2 HSDir=1-2 HSIntro=3-4 HSRend=1-2 Link=1-5 LinkAuth=1,3 Microdesc=1-2 Relay=1-2
+w Bandwidth=18
+p reject 1-65535
+r CalyxInstitute14 ABG9JIWtRdmE7EFZyI/AZuXjMA4 g4tM4vNh0ojHEpw3V9nLDVqUGNE 2019-04-30 18:01:16 162.247.74.201 443 80
+a [2620:18c:0:192::201]:443
+s Exit Fast Guard HSDir Running StaleDesc Stable V2Dir Valid
+v Tor 0.3.5.8
+w Bandwidth=9730
+p accept 20-23,43,53,79-81
+directory-footer
+bandwidth-weights Wbd=0 Wbe=0
+`
+
+func TestParseConsensus(t *testing.T) {
+
+	objects, err := ParseConsensus(strings.NewReader(consensusExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse consensus: %s", err)
+	}
+	consensus := objects.(*Consensus)
+
+	if !consensus.ValidAfter.Equal(time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Got valid-after time %s.", consensus.ValidAfter)
+	}
+	if consensus.Length() != 2 {
+		t.Fatalf("Expected 2 router statuses but got %d.", consensus.Length())
+	}
+	if !consensus.KnowsFlag("StaleDesc") || !consensus.KnowsFlag("NoEdConsensus") {
+		t.Errorf("Flags missing from known flags %v.", consensus.KnownFlags)
+	}
+
+	status, exists := consensus.Get("0011BD2485AD45D984EC4159C88FC066E5E3300E")
+	if !exists {
+		t.Fatal("Could not find CalyxInstitute14.")
+	}
+	if status.Digest != "838B4CE2F361D288C7129C3757D9CB0D5A9418D1" {
+		t.Errorf("Got descriptor digest %s.", status.Digest)
+	}
+	if status.Address.IPv4ORPort != 443 || status.Address.IPv4DirPort != 80 || status.Address.IPv6ORPort != 443 {
+		t.Errorf("Got address %v.", status.Address)
+	}
+	if status.Bandwidth != 9730 || status.TorVersion != "0.3.5.8" || !status.Accept {
+		t.Errorf("Got bandwidth %d, version %s, and accept %t.", status.Bandwidth, status.TorVersion, status.Accept)
+	}
+	if !status.Flags.Exit || !status.Flags.Guard {
+		t.Errorf("Got router flags %v.", status.Flags)
+	}
+
+	flags := consensus.Flags[tor.Fingerprint("0011BD2485AD45D984EC4159C88FC066E5E3300E")]
+	expected := []string{"Exit", "Fast", "Guard", "HSDir", "Running", "StaleDesc", "Stable", "V2Dir", "Valid"}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Errorf("Expected flags %v but got %v.", expected, flags)
+	}
+}
+
+func TestParseConsensusErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"short r line", "valid-after 2019-05-01 00:00:00\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 2019-04-30 20:37:24 67.161.31.147 9001 0\n"},
+		{"bad identity", "valid-after 2019-05-01 00:00:00\n" +
+			"r seele !!!! 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 9001 0\n"},
+		{"bad OR port", "valid-after 2019-05-01 00:00:00\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 90010 0\n"},
+		{"malformed valid-after", "valid-after 2019-05-01\n"},
+		{"no valid-after", "network-status-version 3\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseConsensus(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
@@ -97,6 +97,12 @@ func BandwidthContribution(channel chan tor.ObjectSet, params *CmdLineParams, gr
 	// Iterate over all consensuses.
 	for objects := range channel {
 
+		// Follow mode finished a batch, so report the contributions so far.
+		if objects == EndOfBatch {
+			logContribution(contribution)
+			continue
+		}
+
 		totalBw = 0
 		cloudBw = 0
 		totalCount = 0
@@ -104,7 +110,7 @@ func BandwidthContribution(channel chan tor.ObjectSet, params *CmdLineParams, gr
 
 		// Iterate over single relays in consensus.
 		switch v := objects.(type) {
-		case *tor.Consensus:
+		case *Consensus:
 			for _, getStatus := range v.RouterStatuses {
 
 				status := getStatus()
@@ -130,6 +136,12 @@ func BandwidthContribution(channel chan tor.ObjectSet, params *CmdLineParams, gr
 		fmt.Printf("%d, %d, %d, %d, %.3f\n", cloudCount, totalCount, cloudBw, totalBw, bwfraction)
 	}
 
+	logContribution(contribution)
+}
+
+// logContribution logs the bandwidth that every netblock contributed.
+func logContribution(contribution Contribution) {
+
 	for netname, bw := range contribution {
 		log.Printf("%s contributed %d of bandwidth.\n", netname, bw)
 	}
@@ -0,0 +1,179 @@
+// Restricts analysed data to a date range, based on file names if possible, and
+// on parsed timestamps otherwise.
+
+package main
+
+import (
+	"path"
+	"regexp"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// fileNameTime describes a CollecTor file naming convention that carries a
+// timestamp.  The pattern's first submatch is the timestamp, formatted
+// according to the layout.
+type fileNameTime struct {
+	pattern *regexp.Regexp
+	layout  string
+}
+
+// fileNameTimes holds the CollecTor file naming conventions that we can
+// extract timestamps from.
+var fileNameTimes = []fileNameTime{
+	// Consensuses, microdescriptor consensuses, votes, bandwidth files, exit
+	// lists, and concatenated documents in CollecTor's "recent" directory,
+	// e.g., 2015-07-31-15-00-00-consensus.
+	{regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})(-[a-z0-9A-F-]+)?$`), "2006-01-02-15-04-05"},
+	// Bridge network statuses, e.g., 20150731-150000-<fingerprint>.
+	{regexp.MustCompile(`^(\d{8}-\d{6})-[0-9A-F]{40}$`), "20060102-150405"},
+}
+
+// Descriptors are named after their digest, but the monthly directories that
+// CollecTor puts them in tell us when they were published, e.g.,
+// server-descriptors-2015-07/.
+var monthlyDirectory = regexp.MustCompile(`(?:server-descriptors|extra-infos|microdescs|bridge-server-descriptors|bridge-extra-infos)-(\d{4}-\d{2})(?:/|$)`)
+
+// DateInRange returns true if the given time is within the given date range.
+// Both bounds are inclusive, and zero bounds are open.
+func DateInRange(t, startDate, endDate time.Time) bool {
+
+	if !startDate.IsZero() && t.Before(startDate) {
+		return false
+	}
+
+	if !endDate.IsZero() && t.After(endDate) {
+		return false
+	}
+
+	return true
+}
+
+// spanInRange returns true if the time span [start, start+duration] overlaps
+// with the given date range.
+func spanInRange(start time.Time, duration time.Duration, startDate, endDate time.Time) bool {
+
+	if !startDate.IsZero() && start.Add(duration).Before(startDate) {
+		return false
+	}
+
+	if !endDate.IsZero() && start.After(endDate) {
+		return false
+	}
+
+	return true
+}
+
+// fileInRange tries to extract the time that's part of CollecTor file names,
+// e.g., 2015-07-31-15-00-00-consensus, or of the monthly directories that
+// contain descriptors.  It returns false if the time is outside of the given
+// date range, and true otherwise, including when the file name has no time.
+// By trusting that the file name contains a time stamp (and CollecTor's file
+// names do), we can discard irrelevant files significantly faster than by
+// parsing their content.  Files that we can't discard here are checked by
+// FilterObjectSet after parsing.
+func fileInRange(fileName string, startDate, endDate time.Time) bool {
+
+	if startDate.IsZero() && endDate.IsZero() {
+		return true
+	}
+
+	base := path.Base(fileName)
+	for _, convention := range fileNameTimes {
+		matches := convention.pattern.FindStringSubmatch(base)
+		if matches == nil {
+			continue
+		}
+		date, err := time.Parse(convention.layout, matches[1])
+		if err != nil {
+			continue
+		}
+		return DateInRange(date, startDate, endDate)
+	}
+
+	if matches := monthlyDirectory.FindStringSubmatch(fileName); matches != nil {
+		month, err := time.Parse("2006-01", matches[1])
+		if err == nil {
+			end := month.AddDate(0, 1, 0).Add(-time.Nanosecond)
+			return spanInRange(month, end.Sub(month), startDate, endDate)
+		}
+	}
+
+	// Parse the file if we are unable to extract the timestamp.
+	return true
+}
+
+// FilterObjectSet removes everything from the given object set that's outside
+// of the given date range.  Consensuses and votes are judged by their
+// valid-after time, bridge network statuses and router, bridge, and extra-info
+// descriptors by their publication time, exit lists by their download time,
+// and bandwidth files by their timestamp.  Microdescriptors have no time, so
+// they are kept.  If nothing remains, nil is returned.
+func FilterObjectSet(objects tor.ObjectSet, startDate, endDate time.Time) tor.ObjectSet {
+
+	if startDate.IsZero() && endDate.IsZero() {
+		return objects
+	}
+
+	switch v := objects.(type) {
+	case *Consensus:
+		if !DateInRange(v.ValidAfter, startDate, endDate) {
+			return nil
+		}
+	case *MicrodescConsensus:
+		if !DateInRange(v.ValidAfter, startDate, endDate) {
+			return nil
+		}
+	case *Vote:
+		if !DateInRange(v.ValidAfter, startDate, endDate) {
+			return nil
+		}
+	case *ExitList:
+		if !DateInRange(v.Downloaded, startDate, endDate) {
+			return nil
+		}
+	case *BandwidthFile:
+		if !DateInRange(v.Timestamp, startDate, endDate) {
+			return nil
+		}
+	case *BridgeNetworkStatus:
+		if !DateInRange(v.ValidAfter, startDate, endDate) {
+			return nil
+		}
+	case *BridgeDescriptors:
+		filtered := FilterObjectSet(v.RouterDescriptors, startDate, endDate)
+		if filtered == nil {
+			return nil
+		}
+		return &BridgeDescriptors{filtered.(*tor.RouterDescriptors)}
+	case *tor.RouterDescriptors:
+		filtered := tor.NewRouterDescriptors()
+		for fpr, getDesc := range v.RouterDescriptors {
+			if desc := getDesc(); DateInRange(desc.Published, startDate, endDate) {
+				filtered.Set(fpr, desc)
+			}
+		}
+		if filtered.Length() == 0 {
+			return nil
+		}
+		if filtered.Length() < v.Length() {
+			return filtered
+		}
+	case *ExtraInfos:
+		filtered := NewExtraInfos()
+		for digest, info := range v.ExtraInfos {
+			if DateInRange(info.Published, startDate, endDate) {
+				filtered.ExtraInfos[digest] = info
+			}
+		}
+		if filtered.Length() == 0 {
+			return nil
+		}
+		if filtered.Length() < v.Length() {
+			return filtered
+		}
+	}
+
+	return objects
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileInRange(t *testing.T) {
+
+	start := time.Date(2015, 8, 1, 6, 0, 0, 0, time.UTC)
+	end := time.Date(2015, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"consensuses-2015-08/01/2015-08-01-05-00-00-consensus", false},
+		{"consensuses-2015-08/01/2015-08-01-06-00-00-consensus", true},
+		{"consensuses-2015-08/01/2015-08-01-13-00-00-consensus", false},
+		{"bridge-descriptors-2015-08/statuses/20150801-093712-4A0CCD2DDC7995083D73F5D667100C8A5831F16D", true},
+		// Descriptors are judged by their monthly directory.
+		{"server-descriptors-2015-08/0/0/00a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3", true},
+		{"server-descriptors-2015-07/0/0/00a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3", false},
+		// Files without time are parsed.
+		{"my-consensus.txt", true},
+	}
+
+	for _, test := range tests {
+		if fileInRange(test.name, start, end) != test.expected {
+			t.Errorf("Expected %t for %s.", test.expected, test.name)
+		}
+	}
+}
+
+func TestFilterObjectSet(t *testing.T) {
+
+	start := testEpoch.Add(2 * time.Hour)
+	end := testEpoch.Add(4 * time.Hour)
+
+	if FilterObjectSet(consensusAt(1), start, end) != nil {
+		t.Error("Expected consensus before the date range to be removed.")
+	}
+	if FilterObjectSet(consensusAt(4), start, end) == nil {
+		t.Error("Expected consensus at the end of the date range to be kept.")
+	}
+
+	descs := descriptorsAt(1)
+	for fpr, getDesc := range descriptorsAt(3).RouterDescriptors {
+		descs.Set(fpr+"1", getDesc())
+	}
+	filtered := FilterObjectSet(descs, start, end)
+	if filtered == nil || filtered.Length() != 1 {
+		t.Fatalf("Expected one descriptor in the date range but got %v.", filtered)
+	}
+	if !ObjectSetTime(filtered).Equal(testEpoch.Add(3 * time.Hour)) {
+		t.Errorf("Kept the wrong descriptor, published at %s.", ObjectSetTime(filtered))
+	}
+}
+
+func TestParseDate(t *testing.T) {
+
+	tests := []struct {
+		date     string
+		endOfDay bool
+		expected time.Time
+	}{
+		{"2015-08-01", false, time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"2015-08-01", true, time.Date(2015, 8, 1, 23, 59, 59, 999999999, time.UTC)},
+		{"2015-08-01T06:00:00Z", true, time.Date(2015, 8, 1, 6, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		if date := parseDate(test.date, test.endOfDay); !date.Equal(test.expected) {
+			t.Errorf("Expected %s for %s but got %s.", test.expected, test.date, date)
+		}
+	}
+}
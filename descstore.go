@@ -0,0 +1,345 @@
+// Indexes router descriptors by digest, so that looking up the descriptor of a
+// router status doesn't require a filesystem search.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	descAnnotation = "@type server-descriptor 1.0\n"
+	descStart      = "router "
+	descDigestEnd  = "\nrouter-signature\n"
+	descEnd        = "-----END SIGNATURE-----\n"
+)
+
+// CollecTor names files containing a single descriptor after the descriptor's
+// digest.
+var digestFileName = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// descLocation tells us where to find a descriptor.  Descriptors in files on
+// disk are referenced by path, offset, and length.  We cannot seek in
+// compressed archives, so descriptors in archives are copied to the store's
+// spill file while we build the index.  Their path is then empty, and their
+// offset and length refer to the spill file.
+type descLocation struct {
+	path   string
+	offset int64
+	length int64
+}
+
+// descCacheEntry is an element of the descriptor store's LRU list.
+type descCacheEntry struct {
+	digest string
+	desc   *tor.RouterDescriptor
+}
+
+// DescriptorStore maps descriptor digests to router descriptors.  The index is
+// built once, the first time a descriptor is requested.  Recently used
+// descriptors are kept in an LRU cache, so they don't have to be parsed again.
+// A DescriptorStore is safe for concurrent use.
+type DescriptorStore struct {
+	Dir       string
+	CacheSize int
+
+	buildOnce sync.Once
+	index     map[string]*descLocation
+
+	// Uncompressed copies of the descriptors that we found in archives.  The
+	// file is removed right after we create it, so it disappears once we
+	// exit.
+	spill     *os.File
+	spillSize int64
+
+	mutex   sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// NewDescriptorStore returns a new descriptor store for the given directory,
+// which can contain files, directories, and tarballs of router descriptors.
+// At most cacheSize parsed descriptors are kept in memory.
+func NewDescriptorStore(dir string, cacheSize int) *DescriptorStore {
+
+	if cacheSize < 1 {
+		cacheSize = 1
+	}
+
+	return &DescriptorStore{
+		Dir:       dir,
+		CacheSize: cacheSize,
+		index:     make(map[string]*descLocation),
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// splitDescriptors finds all router descriptors in the given blurb, and calls
+// the given function with every descriptor's digest, offset, and length.
+func splitDescriptors(blurb []byte, found func(digest string, offset, length int)) {
+
+	offset := 0
+	for {
+		start := bytes.Index(blurb[offset:], []byte(descStart))
+		if start == -1 {
+			return
+		}
+		start += offset
+
+		// Descriptors start at the beginning of a line.
+		if start > 0 && blurb[start-1] != '\n' {
+			offset = start + len(descStart)
+			continue
+		}
+
+		digestEnd := bytes.Index(blurb[start:], []byte(descDigestEnd))
+		if digestEnd == -1 {
+			return
+		}
+		digestEnd += start + len(descDigestEnd)
+
+		end := bytes.Index(blurb[digestEnd:], []byte(descEnd))
+		if end == -1 {
+			return
+		}
+		end += digestEnd + len(descEnd)
+
+		// The digest covers everything from "router" to "router-signature".
+		digest := sha1.Sum(blurb[start:digestEnd])
+		found(hex.EncodeToString(digest[:]), start, end-start)
+
+		offset = end
+	}
+}
+
+// spillDescriptor appends the given raw descriptor to the spill file, which
+// is created on first use, and returns the descriptor's location.
+func (store *DescriptorStore) spillDescriptor(raw []byte) (*descLocation, error) {
+
+	if store.spill == nil {
+		fd, err := ioutil.TempFile("", "sybilhunter-descriptors")
+		if err != nil {
+			return nil, err
+		}
+		os.Remove(fd.Name())
+		store.spill = fd
+	}
+
+	if _, err := store.spill.Write(raw); err != nil {
+		return nil, err
+	}
+	location := &descLocation{offset: store.spillSize, length: int64(len(raw))}
+	store.spillSize += int64(len(raw))
+
+	return location, nil
+}
+
+// indexArchiveEntry copies all descriptors in the given archive entry to the
+// spill file, and adds them to the index.
+func (store *DescriptorStore) indexArchiveEntry(path string, info os.FileInfo, r io.Reader) error {
+
+	if info.IsDir() {
+		return nil
+	}
+
+	blurb, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	splitDescriptors(blurb, func(digest string, offset, length int) {
+		if err != nil {
+			return
+		}
+		var location *descLocation
+		if location, err = store.spillDescriptor(blurb[offset : offset+length]); err == nil {
+			store.index[digest] = location
+		}
+	})
+
+	return err
+}
+
+// indexFile adds all descriptors in the given file to the index.
+func (store *DescriptorStore) indexFile(path string, info os.FileInfo) error {
+
+	// Trust CollecTor's file names, so we don't have to read the file.
+	if name := strings.ToLower(filepath.Base(path)); digestFileName.MatchString(name) {
+		store.index[name] = &descLocation{path: path, length: info.Size()}
+		return nil
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	br := bufio.NewReader(fd)
+	if DetectFormat(br) != FormatPlain {
+		return walkFile(path, info, store.indexArchiveEntry)
+	}
+
+	blurb, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+
+	splitDescriptors(blurb, func(digest string, offset, length int) {
+		store.index[digest] = &descLocation{path: path, offset: int64(offset), length: int64(length)}
+	})
+
+	return nil
+}
+
+// build walks the descriptor directory and builds the digest index.
+func (store *DescriptorStore) build() {
+
+	if store.Dir == "" {
+		return
+	}
+
+	log.Printf("Indexing router descriptors in \"%s\".\n", store.Dir)
+	start := time.Now()
+
+	err := filepath.Walk(store.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Skipping %s: %s\n", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := store.indexFile(path, info); err != nil {
+			log.Printf("Skipping %s: %s\n", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not index all router descriptors: %s\n", err)
+	}
+
+	log.Printf("Indexed %d router descriptors after %s.\n", len(store.index), time.Since(start))
+	if store.spillSize > 0 {
+		log.Printf("Copied %d bytes of archived router descriptors to a temporary file.\n", store.spillSize)
+	}
+}
+
+// read reads the raw descriptor at the given location.
+func (store *DescriptorStore) read(location *descLocation) ([]byte, error) {
+
+	fd := store.spill
+	if location.path != "" {
+		var err error
+		if fd, err = os.Open(location.path); err != nil {
+			return nil, err
+		}
+		defer fd.Close()
+	}
+
+	raw := make([]byte, location.length)
+	if _, err := fd.ReadAt(raw, location.offset); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// load reads and parses the descriptor with the given digest at the given
+// location.
+func (store *DescriptorStore) load(digest string, location *descLocation) (*tor.RouterDescriptor, error) {
+
+	raw, err := store.read(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// Our raw descriptors can lack the annotation that tells the parser what
+	// it's looking at.
+	var r io.Reader = bytes.NewReader(raw)
+	if !bytes.HasPrefix(raw, []byte("@type")) {
+		r = io.MultiReader(strings.NewReader(descAnnotation), r)
+	}
+
+	objects, err := tor.ParseUnknown(r)
+	if err != nil {
+		return nil, err
+	}
+
+	descs, ok := objects.(*tor.RouterDescriptors)
+	if !ok {
+		return nil, fmt.Errorf("Expected router descriptor with digest %s.", digest)
+	}
+	for _, getDesc := range descs.RouterDescriptors {
+		return getDesc(), nil
+	}
+
+	return nil, fmt.Errorf("Found no router descriptor with digest %s.", digest)
+}
+
+// Get returns the router descriptor with the given digest.
+func (store *DescriptorStore) Get(digest string) (*tor.RouterDescriptor, error) {
+
+	if store == nil {
+		return nil, fmt.Errorf("No descriptor directory given.")
+	}
+
+	store.buildOnce.Do(store.build)
+	digest = strings.ToLower(digest)
+
+	store.mutex.Lock()
+	if elem, exists := store.entries[digest]; exists {
+		store.lru.MoveToFront(elem)
+		store.mutex.Unlock()
+		return elem.Value.(*descCacheEntry).desc, nil
+	}
+	store.mutex.Unlock()
+
+	location, exists := store.index[digest]
+	if !exists {
+		return nil, fmt.Errorf("Could not find descriptor with digest %s.", digest)
+	}
+
+	desc, err := store.load(digest, location)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exists := store.entries[digest]; !exists {
+		store.entries[digest] = store.lru.PushFront(&descCacheEntry{digest, desc})
+		if store.lru.Len() > store.CacheSize {
+			oldest := store.lru.Back()
+			store.lru.Remove(oldest)
+			delete(store.entries, oldest.Value.(*descCacheEntry).digest)
+		}
+	}
+
+	return desc, nil
+}
+
+// GetForStatus returns the router descriptor that the given router status
+// references.
+func (store *DescriptorStore) GetForStatus(status *tor.RouterStatus) (*tor.RouterDescriptor, error) {
+
+	return store.Get(status.Digest)
+}
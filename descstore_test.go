@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A shortened server descriptor.  The store only cares about the lines that
+// delimit descriptors.
+const shortDescriptor = `router seele 67.161.31.147 9001 0 0
+platform Tor 0.3.5.8 on Linux
+published 2019-04-30 20:37:24
+fingerprint 000A 10D4 3011 EA49 28A3 5F61 0405 F92B 4433 B4DC
+router-signature
+-----BEGIN SIGNATURE-----
+AAAA
+-----END SIGNATURE-----
+`
+
+func TestDescriptorStoreArchive(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "descstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Put the descriptor behind another entry and an annotation, so that it
+	// doesn't start at the beginning of the decompressed archive.
+	padding := "@type server-descriptor 1.0\n"
+	entries := map[string]string{
+		"server-descriptors/a": "not a descriptor\n",
+		"server-descriptors/b": padding + shortDescriptor,
+	}
+
+	fd, err := os.Create(filepath.Join(dir, "server-descriptors.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(fd)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"server-descriptors/a", "server-descriptors/b"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(entries[name])), Typeflag: tar.TypeReg})
+		tw.Write([]byte(entries[name]))
+	}
+	tw.Close()
+	gz.Close()
+	fd.Close()
+
+	store := NewDescriptorStore(dir, 1)
+	store.build()
+
+	signed := shortDescriptor[:strings.Index(shortDescriptor, "router-signature\n")+len("router-signature\n")]
+	sum := sha1.Sum([]byte(signed))
+	digest := hex.EncodeToString(sum[:])
+
+	location, exists := store.index[digest]
+	if !exists {
+		t.Fatalf("Descriptor with digest %s is not in the index.", digest)
+	}
+	if location.path != "" || location.length != int64(len(shortDescriptor)) {
+		t.Errorf("Expected descriptor in spill file but got %+v.", location)
+	}
+
+	raw, err := store.read(location)
+	if err != nil {
+		t.Fatalf("Could not read descriptor: %s", err)
+	}
+	if string(raw) != shortDescriptor {
+		t.Errorf("Read wrong descriptor:\n%s", raw)
+	}
+}
+
+func TestDescriptorStoreSkipsBadFiles(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "descstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A truncated gzip file must not keep us from indexing the others.
+	ioutil.WriteFile(filepath.Join(dir, "a-broken.gz"), []byte{0x1f, 0x8b, 0x08}, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b-plain"), []byte(shortDescriptor), 0600)
+
+	store := NewDescriptorStore(dir, 1)
+	store.build()
+
+	if len(store.index) != 1 {
+		t.Errorf("Expected 1 indexed descriptor but got %d.", len(store.index))
+	}
+}
+
+func TestDescriptorStorePlainFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "descstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Two descriptors in one file.
+	other := strings.Replace(shortDescriptor, "seele", "other", 1)
+	ioutil.WriteFile(filepath.Join(dir, "descriptors"), []byte(shortDescriptor+other), 0600)
+
+	store := NewDescriptorStore(dir, 1)
+	store.build()
+
+	if len(store.index) != 2 {
+		t.Fatalf("Expected 2 indexed descriptors but got %d.", len(store.index))
+	}
+	if store.spill != nil {
+		t.Error("Expected no spill file for plain descriptor files.")
+	}
+
+	for _, location := range store.index {
+		raw, err := store.read(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw) != shortDescriptor && string(raw) != other {
+			t.Errorf("Read wrong descriptor:\n%s", raw)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Compares directory authority votes to find relays that authorities disagree
+// on.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	// Measured bandwidths diverge sharply if the largest is this many times
+	// the smallest.
+	voteBandwidthFactor = 5
+	// A flag is contested if at least this fraction of the authorities that
+	// vote on it disagrees with the rest.
+	voteFlagSplit = 1.0 / 3
+)
+
+// voteRound holds the votes for a single consensus, keyed by the
+// authorities' fingerprints.
+type voteRound struct {
+	ValidAfter time.Time
+	Votes      map[tor.Fingerprint]*Vote
+}
+
+// newVoteRound allocates and returns a new vote round for the consensus that
+// becomes valid at the given time.
+func newVoteRound(validAfter time.Time) *voteRound {
+
+	return &voteRound{ValidAfter: validAfter, Votes: make(map[tor.Fingerprint]*Vote)}
+}
+
+// sortedVotes returns the round's votes, sorted by authority nickname.
+func (round *voteRound) sortedVotes() []*Vote {
+
+	var nicknames []string
+	byNickname := make(map[string]*Vote)
+	for _, vote := range round.Votes {
+		nicknames = append(nicknames, vote.Authority)
+		byNickname[vote.Authority] = vote
+	}
+	sort.Strings(nicknames)
+
+	votes := make([]*Vote, len(nicknames))
+	for i, nickname := range nicknames {
+		votes[i] = byNickname[nickname]
+	}
+
+	return votes
+}
+
+// voteDisagreement summarises how the votes on a relay differ.
+type voteDisagreement struct {
+	Listed         int
+	Minority       bool
+	ContestedFlags []string
+	BandwidthRatio float64
+}
+
+// Diverges returns true if the votes on the relay diverge sharply, or if only
+// a minority of authorities listed the relay.
+func (d *voteDisagreement) Diverges() bool {
+
+	return d.Minority || len(d.ContestedFlags) > 0 || d.BandwidthRatio >= voteBandwidthFactor
+}
+
+// compareVotes determines how the given votes differ on the relay with the
+// given fingerprint.
+func compareVotes(fpr tor.Fingerprint, votes []*Vote) *voteDisagreement {
+
+	d := new(voteDisagreement)
+
+	var listing []*Vote
+	for _, vote := range votes {
+		if _, exists := vote.Get(fpr); exists {
+			listing = append(listing, vote)
+		}
+	}
+	d.Listed = len(listing)
+	d.Minority = d.Listed*2 < len(votes)
+
+	// Count how many of the authorities that listed the relay and vote on a
+	// flag assigned it.
+	assigned := make(map[string]int)
+	for _, vote := range listing {
+		for _, flag := range vote.Flags[fpr] {
+			assigned[flag]++
+		}
+	}
+	for flag, yes := range assigned {
+		total := 0
+		for _, vote := range listing {
+			if vote.KnowsFlag(flag) {
+				total++
+			}
+		}
+		no := total - yes
+		if no > 0 && float64(MinInt(yes, no))/float64(total) >= voteFlagSplit {
+			d.ContestedFlags = append(d.ContestedFlags, flag)
+		}
+	}
+	sort.Strings(d.ContestedFlags)
+
+	// Only bandwidth authorities measure relays.
+	var measured []float64
+	for _, vote := range listing {
+		if status, _ := vote.Get(fpr); status.Measured > 0 {
+			measured = append(measured, float64(status.Measured))
+		}
+	}
+	if len(measured) >= 2 {
+		sort.Float64s(measured)
+		d.BandwidthRatio = measured[len(measured)-1] / math.Max(measured[0], 1)
+	}
+
+	return d
+}
+
+// printVoteRound prints the given round's votes for every relay, one authority
+// per line, along with how the votes on the relay differ.
+func printVoteRound(round *voteRound, params *CmdLineParams) {
+
+	votes := round.sortedVotes()
+
+	fprs := make(map[tor.Fingerprint]bool)
+	for _, vote := range votes {
+		for obj := range vote.Iterate(params.Filter) {
+			fprs[obj.GetFingerprint()] = true
+		}
+	}
+	var sorted []string
+	for fpr := range fprs {
+		sorted = append(sorted, string(fpr))
+	}
+	sort.Strings(sorted)
+
+	minority, diverging := 0, 0
+	date := round.ValidAfter.Format("2006-01-02T15:04:05Z")
+	for _, fprStr := range sorted {
+		fpr := tor.Fingerprint(fprStr)
+		d := compareVotes(fpr, votes)
+		if d.Minority {
+			minority++
+		}
+		if d.Diverges() {
+			diverging++
+		}
+
+		isMinority := "F"
+		if d.Minority {
+			isMinority = "T"
+		}
+
+		for _, vote := range votes {
+			status, exists := vote.Get(fpr)
+			if !exists {
+				continue
+			}
+			measured := "NA"
+			if status.Measured > 0 {
+				measured = fmt.Sprintf("%d", status.Measured)
+			}
+			fmt.Printf("%s,%s,%s,%s,%s,%s,%d,%d,%s,%s,%.2f\n", date, fpr, status.Nickname,
+				vote.Authority, strings.Join(vote.Flags[fpr], " "), measured, d.Listed,
+				len(votes), isMinority, strings.Join(d.ContestedFlags, " "), d.BandwidthRatio)
+		}
+	}
+
+	log.Printf("%d votes for %s list %d relays.  %d relays are in a minority of votes, and votes on %d relays diverge.\n",
+		len(votes), date, len(sorted), minority, diverging)
+}
+
+// AnalyseVotes compares the votes that directory authorities cast for each
+// consensus, and reports which authorities listed each relay, and which flags
+// and measured bandwidth they assigned.  Relays that only a minority of
+// authorities listed, and relays whose votes diverge sharply, are highlighted.
+func AnalyseVotes(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
+
+	defer group.Done()
+
+	fmt.Println("Date,Fingerprint,Nickname,Authority,Flags,Measured,Listed,Votes,Minority,ContestedFlags,BandwidthRatio")
+
+	var round *voteRound
+	for objects := range channel {
+
+		// Follow mode finished a batch, so report the votes we have so far.
+		// Votes that arrive in the next batch will be reported separately.
+		if objects == EndOfBatch {
+			if round != nil {
+				printVoteRound(round, params)
+				round = nil
+			}
+			continue
+		}
+
+		vote, ok := objects.(*Vote)
+		if !ok {
+			continue
+		}
+
+		// Object sets arrive in chronological order, so all votes for a
+		// consensus arrive before the votes for the next one.
+		if round != nil && !vote.ValidAfter.Equal(round.ValidAfter) {
+			printVoteRound(round, params)
+			round = nil
+		}
+		if round == nil {
+			round = newVoteRound(vote.ValidAfter)
+		}
+		round.Votes[vote.AuthorityFingerprint] = vote
+	}
+
+	if round != nil {
+		printVoteRound(round, params)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// testVote returns a vote by the given authority that lists the given relay
+// with the given flags and measured bandwidth, unless flags is nil.
+func testVote(authority string, fpr tor.Fingerprint, flags []string, measured uint64) *Vote {
+
+	vote := NewVote()
+	vote.ValidAfter = testEpoch
+	vote.Authority = authority
+	vote.AuthorityFingerprint = tor.Fingerprint(authority)
+	vote.KnownFlags = []string{"Exit", "Fast", "Guard", "Running", "Valid"}
+	if flags != nil {
+		vote.Set(fpr, &tor.RouterStatus{Fingerprint: fpr, Measured: measured})
+		vote.Flags[fpr] = flags
+	}
+
+	return vote
+}
+
+func TestCompareVotes(t *testing.T) {
+
+	fpr := tor.Fingerprint("000A10D43011EA4928A35F610405F92B4433B4DC")
+	votes := []*Vote{
+		testVote("dannenberg", fpr, []string{"Exit", "Fast", "Running", "Valid"}, 1000),
+		testVote("gabelmoo", fpr, []string{"Fast", "Running", "Valid"}, 0),
+		testVote("moria1", fpr, []string{"Fast", "Running", "Valid"}, 6000),
+		testVote("tor26", fpr, nil, 0),
+	}
+
+	d := compareVotes(fpr, votes)
+	if d.Listed != 3 || d.Minority {
+		t.Errorf("Expected relay to be listed in a majority of 3 votes but got %d and %t.", d.Listed, d.Minority)
+	}
+	// One out of three authorities assigned Exit.
+	if !reflect.DeepEqual(d.ContestedFlags, []string{"Exit"}) {
+		t.Errorf("Expected Exit to be contested but got %v.", d.ContestedFlags)
+	}
+	// gabelmoo isn't a bandwidth authority.
+	if d.BandwidthRatio != 6 {
+		t.Errorf("Expected bandwidth ratio 6 but got %.2f.", d.BandwidthRatio)
+	}
+	if !d.Diverges() {
+		t.Error("Expected votes to diverge.")
+	}
+}
+
+func TestCompareVotesMinority(t *testing.T) {
+
+	fpr := tor.Fingerprint("000A10D43011EA4928A35F610405F92B4433B4DC")
+	votes := []*Vote{
+		testVote("dannenberg", fpr, []string{"Fast", "Running", "Valid"}, 1000),
+		testVote("gabelmoo", fpr, nil, 0),
+		testVote("moria1", fpr, nil, 0),
+	}
+
+	d := compareVotes(fpr, votes)
+	if d.Listed != 1 || !d.Minority || !d.Diverges() {
+		t.Errorf("Expected relay to be listed in a minority of votes but got %+v.", d)
+	}
+	if len(d.ContestedFlags) != 0 || d.BandwidthRatio != 0 {
+		t.Errorf("Expected a single vote not to disagree with itself but got %+v.", d)
+	}
+}
@@ -0,0 +1,172 @@
+// Joins exit lists with router statuses to find relays whose exit traffic
+// leaves from an IP address other than their OR address, and relays that
+// share an exit address.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// exitRelay accumulates the OR addresses that consensuses list for a relay,
+// and the exit addresses that exit lists observed.
+type exitRelay struct {
+	Nickname string
+	// OR addresses in consensuses.
+	ORAddresses map[string]bool
+	// Exit addresses in exit lists, and when they were last observed.
+	ExitAddresses map[string]time.Time
+}
+
+// newExitRelay allocates and returns a new exit relay.
+func newExitRelay() *exitRelay {
+
+	return &exitRelay{
+		ORAddresses:   make(map[string]bool),
+		ExitAddresses: make(map[string]time.Time),
+	}
+}
+
+// sortedKeys returns the keys of the given map in sorted order.
+func sortedKeys(set map[string]bool) []string {
+
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// reportExitAddresses prints relays that exit from an address other than their
+// OR address, followed by exit addresses that several relays share.
+func reportExitAddresses(relays map[tor.Fingerprint]*exitRelay) {
+
+	var fprs []string
+	for fpr := range relays {
+		fprs = append(fprs, string(fpr))
+	}
+	sort.Strings(fprs)
+
+	foreign := 0
+	sharedBy := make(map[string]map[string]bool)
+	fmt.Println("Fingerprint,Nickname,ORAddresses,ExitAddress,LastSeen")
+	for _, fpr := range fprs {
+		relay := relays[tor.Fingerprint(fpr)]
+
+		var exitAddrs []string
+		for addr := range relay.ExitAddresses {
+			exitAddrs = append(exitAddrs, addr)
+			if sharedBy[addr] == nil {
+				sharedBy[addr] = make(map[string]bool)
+			}
+			sharedBy[addr][fpr] = true
+		}
+		sort.Strings(exitAddrs)
+
+		// Without router statuses, we cannot tell if an exit address is
+		// foreign.
+		if len(relay.ORAddresses) == 0 {
+			continue
+		}
+
+		for _, addr := range exitAddrs {
+			if relay.ORAddresses[addr] {
+				continue
+			}
+			foreign++
+			fmt.Printf("%s,%s,%s,%s,%s\n", fpr, relay.Nickname, strings.Join(sortedKeys(relay.ORAddresses), " "),
+				addr, relay.ExitAddresses[addr].Format("2006-01-02T15:04:05Z"))
+		}
+	}
+
+	var addrs []string
+	for addr, fprs := range sharedBy {
+		if len(fprs) > 1 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	fmt.Println("ExitAddress,Relays,Fingerprints")
+	for _, addr := range addrs {
+		fmt.Printf("%s,%d,%s\n", addr, len(sharedBy[addr]), strings.Join(sortedKeys(sharedBy[addr]), " "))
+	}
+
+	log.Printf("Found %d foreign exit addresses, and %d exit addresses shared by several relays.\n", foreign, len(addrs))
+}
+
+// AnalyseExitAddresses joins the exit addresses in exit lists with the OR
+// addresses in router statuses.  It reports relays whose exit traffic leaves
+// from an address other than their OR address, and groups of relays that share
+// an exit address.
+func AnalyseExitAddresses(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
+
+	defer group.Done()
+
+	relays := make(map[tor.Fingerprint]*exitRelay)
+	getRelay := func(fpr tor.Fingerprint) *exitRelay {
+		relay, exists := relays[fpr]
+		if !exists {
+			relay = newExitRelay()
+			relays[fpr] = relay
+		}
+		return relay
+	}
+
+	haveStatuses := false
+	for objects := range channel {
+
+		// Follow mode finished a batch, so report what we have so far.
+		if objects == EndOfBatch {
+			reportExitAddresses(relays)
+			continue
+		}
+
+		if list, ok := objects.(*ExitList); ok {
+			for obj := range list.Iterate(params.Filter) {
+				node := obj.(*ExitNode)
+				relay := getRelay(node.Fingerprint)
+				for _, addr := range node.Addresses {
+					if addr.Time.After(relay.ExitAddresses[addr.Address.String()]) {
+						relay.ExitAddresses[addr.Address.String()] = addr.Time
+					}
+				}
+			}
+			continue
+		}
+
+		consensus := AsConsensus(objects)
+		if consensus == nil {
+			continue
+		}
+		haveStatuses = true
+
+		// We only care about relays that exit, but exit lists may arrive after
+		// the consensuses, so we have to remember all relays.
+		for fpr, getStatus := range consensus.RouterStatuses {
+			status := getStatus()
+			relay := getRelay(fpr)
+			relay.Nickname = status.Nickname
+			for _, addr := range []net.IP{status.Address.IPv4Address, status.Address.IPv6Address} {
+				if addr != nil {
+					relay.ORAddresses[addr.String()] = true
+				}
+			}
+		}
+	}
+
+	if !haveStatuses {
+		log.Println("Found no consensuses, so we cannot tell which exit addresses are foreign.  Add consensuses to -data.")
+	}
+	reportExitAddresses(relays)
+}
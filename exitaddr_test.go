@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// captureStdout returns what the given function writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		blurb, _ := ioutil.ReadAll(r)
+		output <- blurb
+	}()
+
+	f()
+	w.Close()
+
+	return string(<-output)
+}
+
+// runAnalysis passes the given object sets to the given analysis, and returns
+// what it writes to stdout.
+func runAnalysis(t *testing.T, analysis func(chan tor.ObjectSet, *CmdLineParams, *sync.WaitGroup), params *CmdLineParams, objects ...tor.ObjectSet) string {
+
+	return captureStdout(t, func() {
+		var group sync.WaitGroup
+		channel := make(chan tor.ObjectSet)
+		group.Add(1)
+		go analysis(channel, params, &group)
+		for _, set := range objects {
+			channel <- set
+		}
+		close(channel)
+		group.Wait()
+	})
+}
+
+func TestAnalyseExitAddresses(t *testing.T) {
+
+	consensus := consensusAt(0)
+	consensus.Set("AAAA", &tor.RouterStatus{Nickname: "alice", Fingerprint: "AAAA",
+		Address: tor.RouterAddress{IPv4Address: net.ParseIP("1.1.1.1")}})
+	consensus.Set("BBBB", &tor.RouterStatus{Nickname: "bob", Fingerprint: "BBBB",
+		Address: tor.RouterAddress{IPv4Address: net.ParseIP("2.2.2.2")}})
+
+	seen := testEpoch.Add(30 * time.Minute)
+	list := NewExitList()
+	list.ExitNodes["AAAA"] = &ExitNode{Fingerprint: "AAAA", Addresses: []ExitAddress{
+		{net.ParseIP("1.1.1.1"), seen},
+		{net.ParseIP("3.3.3.3"), seen},
+	}}
+	list.ExitNodes["BBBB"] = &ExitNode{Fingerprint: "BBBB", Addresses: []ExitAddress{
+		{net.ParseIP("3.3.3.3"), seen},
+	}}
+
+	// Exit lists may arrive before the consensuses that they are joined with.
+	output := runAnalysis(t, AnalyseExitAddresses, new(CmdLineParams), list, consensus)
+
+	expected := "Fingerprint,Nickname,ORAddresses,ExitAddress,LastSeen\n" +
+		"AAAA,alice,1.1.1.1,3.3.3.3,2015-08-01T00:30:00Z\n" +
+		"BBBB,bob,2.2.2.2,3.3.3.3,2015-08-01T00:30:00Z\n" +
+		"ExitAddress,Relays,Fingerprints\n" +
+		"3.3.3.3,2,AAAA BBBB\n"
+	if output != expected {
+		t.Errorf("Expected output\n%s\nbut got\n%s", expected, output)
+	}
+}
+
+func TestAnalyseExitAddressesReportsBatches(t *testing.T) {
+
+	list := NewExitList()
+	list.ExitNodes["AAAA"] = &ExitNode{Fingerprint: "AAAA", Addresses: []ExitAddress{
+		{net.ParseIP("3.3.3.3"), testEpoch},
+	}}
+
+	output := runAnalysis(t, AnalyseExitAddresses, new(CmdLineParams), list, EndOfBatch)
+	if n := strings.Count(output, "ExitAddress,Relays,Fingerprints\n"); n != 2 {
+		t.Errorf("Expected a report after the batch and at the end but got %d:\n%s", n, output)
+	}
+}
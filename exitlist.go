@@ -0,0 +1,178 @@
+// Parses the exit lists that TorDNSEL publishes.  They tell us which IP
+// addresses exit relays actually use for their exit traffic.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// ExitAddress is an IP address that TorDNSEL saw an exit relay use, and when
+// it last saw it.
+type ExitAddress struct {
+	Address net.IP
+	Time    time.Time
+}
+
+// ExitNode is an exit relay in an exit list.
+type ExitNode struct {
+	Fingerprint tor.Fingerprint
+	Published   time.Time
+	LastStatus  time.Time
+	Addresses   []ExitAddress
+}
+
+// GetFingerprint implements the tor.Object interface.
+func (node *ExitNode) GetFingerprint() tor.Fingerprint {
+
+	return node.Fingerprint
+}
+
+// String implements the tor.Object interface.
+func (node *ExitNode) String() string {
+
+	var addrs []string
+	for _, addr := range node.Addresses {
+		addrs = append(addrs, addr.Address.String())
+	}
+
+	return fmt.Sprintf("%s,%s,%s,%s", node.Fingerprint, node.Published.Format(time.RFC3339),
+		node.LastStatus.Format(time.RFC3339), strings.Join(addrs, " "))
+}
+
+// ExitList is an exit list, keyed by the exit relays' fingerprints.
+type ExitList struct {
+	Downloaded time.Time
+	ExitNodes  map[tor.Fingerprint]*ExitNode
+}
+
+// NewExitList allocates and returns a new exit list.
+func NewExitList() *ExitList {
+
+	return &ExitList{ExitNodes: make(map[tor.Fingerprint]*ExitNode)}
+}
+
+// Iterate implements the tor.ObjectSet interface.
+func (list *ExitList) Iterate(filter *tor.ObjectFilter) <-chan tor.Object {
+
+	ch := make(chan tor.Object)
+
+	go func() {
+		for _, node := range list.ExitNodes {
+			if filter == nil || filter.IsEmpty() || filter.HasFingerprint(node.Fingerprint) {
+				ch <- node
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// GetObject implements the tor.ObjectSet interface.
+func (list *ExitList) GetObject(fpr tor.Fingerprint) (tor.Object, bool) {
+
+	node, exists := list.ExitNodes[fpr]
+
+	return node, exists
+}
+
+// Length implements the tor.ObjectSet interface.
+func (list *ExitList) Length() int {
+
+	return len(list.ExitNodes)
+}
+
+// Merge implements the tor.ObjectSet interface.
+func (list *ExitList) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*ExitList)
+	if !ok {
+		return
+	}
+
+	if other.Downloaded.After(list.Downloaded) {
+		list.Downloaded = other.Downloaded
+	}
+	for fpr, node := range other.ExitNodes {
+		list.ExitNodes[fpr] = node
+	}
+}
+
+// parseExitListTime parses the date and time in the given words.
+func parseExitListTime(keyword string, words []string) (time.Time, error) {
+
+	if len(words) != 2 {
+		return time.Time{}, fmt.Errorf("Malformed \"%s\" line.", keyword)
+	}
+
+	return time.Parse(statusTimeLayout, words[0]+" "+words[1])
+}
+
+// ParseExitList parses an exit list.
+func ParseExitList(r io.Reader) (tor.ObjectSet, error) {
+
+	list := NewExitList()
+	var node *ExitNode
+	var err error
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "Downloaded":
+			list.Downloaded, err = parseExitListTime(words[0], words[1:])
+		case "ExitNode":
+			if len(words) != 2 {
+				return nil, fmt.Errorf("Malformed \"ExitNode\" line.")
+			}
+			node = &ExitNode{Fingerprint: tor.Fingerprint(strings.ToUpper(words[1]))}
+			list.ExitNodes[node.Fingerprint] = node
+		case "Published":
+			if node != nil {
+				node.Published, err = parseExitListTime(words[0], words[1:])
+			}
+		case "LastStatus":
+			if node != nil {
+				node.LastStatus, err = parseExitListTime(words[0], words[1:])
+			}
+		case "ExitAddress":
+			if node == nil {
+				continue
+			}
+			if len(words) != 4 {
+				return nil, fmt.Errorf("Malformed \"ExitAddress\" line.")
+			}
+			addr := ExitAddress{Address: net.ParseIP(words[1])}
+			if addr.Address == nil {
+				return nil, fmt.Errorf("Malformed exit address \"%s\".", words[1])
+			}
+			addr.Time, err = parseExitListTime(words[0], words[2:])
+			node.Addresses = append(node.Addresses, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if list.Downloaded.IsZero() {
+		return nil, fmt.Errorf("Exit list has no \"Downloaded\" line.")
+	}
+
+	return list, nil
+}
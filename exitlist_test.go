@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// An excerpt of an exit list, as published by CollecTor.
+const exitListExcerpt = `@type tordnsel 1.0
+Downloaded 2019-05-01 00:02:00
+ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E
+Published 2019-04-30 18:01:16
+LastStatus 2019-04-30 19:02:28
+ExitAddress 162.247.74.201 2019-04-30 19:10:31
+ExitNode 00c4b4731658d3b4987132a3f77100cfcb190d97
+Published 2019-04-30 13:37:46
+LastStatus 2019-04-30 14:02:48
+ExitAddress 199.249.230.64 2019-04-30 14:08:47
+ExitAddress 199.249.230.65 2019-04-30 23:08:47
+`
+
+func TestParseExitList(t *testing.T) {
+
+	objects, err := ParseExitList(strings.NewReader(exitListExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse exit list: %s", err)
+	}
+	list := objects.(*ExitList)
+
+	if !list.Downloaded.Equal(time.Date(2019, 5, 1, 0, 2, 0, 0, time.UTC)) {
+		t.Errorf("Got download time %s.", list.Downloaded)
+	}
+	if list.Length() != 2 {
+		t.Fatalf("Expected 2 exit relays but got %d.", list.Length())
+	}
+
+	// Fingerprints are upper-cased, so that they match the ones in
+	// consensuses.
+	object, exists := list.GetObject(tor.Fingerprint("00C4B4731658D3B4987132A3F77100CFCB190D97"))
+	if !exists {
+		t.Fatal("Could not find exit relay by its upper-case fingerprint.")
+	}
+	node := object.(*ExitNode)
+
+	if !node.Published.Equal(time.Date(2019, 4, 30, 13, 37, 46, 0, time.UTC)) ||
+		!node.LastStatus.Equal(time.Date(2019, 4, 30, 14, 2, 48, 0, time.UTC)) {
+		t.Errorf("Got publication time %s and last status %s.", node.Published, node.LastStatus)
+	}
+	if len(node.Addresses) != 2 {
+		t.Fatalf("Expected 2 exit addresses but got %d.", len(node.Addresses))
+	}
+	if node.Addresses[1].Address.String() != "199.249.230.65" ||
+		!node.Addresses[1].Time.Equal(time.Date(2019, 4, 30, 23, 8, 47, 0, time.UTC)) {
+		t.Errorf("Got exit address %s at %s.", node.Addresses[1].Address, node.Addresses[1].Time)
+	}
+
+	expected := "00C4B4731658D3B4987132A3F77100CFCB190D97,2019-04-30T13:37:46Z,2019-04-30T14:02:48Z,199.249.230.64 199.249.230.65"
+	if node.String() != expected {
+		t.Errorf("Expected %q but got %q.", expected, node.String())
+	}
+}
+
+func TestParseExitListErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"no Downloaded", "ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\n"},
+		{"malformed Downloaded", "Downloaded 2019-05-01\n"},
+		{"malformed ExitNode", "Downloaded 2019-05-01 00:02:00\nExitNode\n"},
+		{"malformed Published", "Downloaded 2019-05-01 00:02:00\n" +
+			"ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\nPublished 2019-04-30T18:01:16\n"},
+		{"short ExitAddress", "Downloaded 2019-05-01 00:02:00\n" +
+			"ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\nExitAddress 162.247.74.201\n"},
+		{"bad exit address", "Downloaded 2019-05-01 00:02:00\n" +
+			"ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\nExitAddress 162.247.74 2019-04-30 19:10:31\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseExitList(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
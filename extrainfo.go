@@ -0,0 +1,268 @@
+// Parses extra-info descriptors, which carry the bandwidth histories that
+// relays report.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	extraInfoStart     = "extra-info "
+	extraInfoDigestEnd = "\nrouter-signature\n"
+)
+
+// BandwidthHistory holds the number of bytes that a relay reported to have
+// relayed in consecutive intervals.
+type BandwidthHistory struct {
+	// End of the last interval.
+	End      time.Time
+	Interval time.Duration
+	Values   []uint64
+}
+
+// Times returns the end of every interval in the history.
+func (history *BandwidthHistory) Times() []time.Time {
+
+	times := make([]time.Time, len(history.Values))
+	for i := range history.Values {
+		times[i] = history.End.Add(-time.Duration(len(history.Values)-1-i) * history.Interval)
+	}
+
+	return times
+}
+
+// ExtraInfo holds the content of an extra-info descriptor that we care about.
+// The digest is the one that the relay's server descriptor references in its
+// "extra-info-digest" line.
+type ExtraInfo struct {
+	Nickname     string
+	Fingerprint  tor.Fingerprint
+	Digest       string
+	Published    time.Time
+	WriteHistory *BandwidthHistory
+	ReadHistory  *BandwidthHistory
+}
+
+// GetFingerprint implements the tor.Object interface.
+func (info *ExtraInfo) GetFingerprint() tor.Fingerprint {
+
+	return info.Fingerprint
+}
+
+// String implements the tor.Object interface.
+func (info *ExtraInfo) String() string {
+
+	var written, read int
+	if info.WriteHistory != nil {
+		written = len(info.WriteHistory.Values)
+	}
+	if info.ReadHistory != nil {
+		read = len(info.ReadHistory.Values)
+	}
+
+	return fmt.Sprintf("%s,%s,%s,%s,%d,%d", info.Fingerprint, info.Nickname, info.Digest,
+		info.Published.Format(time.RFC3339), written, read)
+}
+
+// ExtraInfos is a set of extra-info descriptors, keyed by their digest.
+type ExtraInfos struct {
+	ExtraInfos map[string]*ExtraInfo
+}
+
+// NewExtraInfos allocates and returns a new set of extra-info descriptors.
+func NewExtraInfos() *ExtraInfos {
+
+	return &ExtraInfos{ExtraInfos: make(map[string]*ExtraInfo)}
+}
+
+// Iterate implements the tor.ObjectSet interface.
+func (infos *ExtraInfos) Iterate(filter *tor.ObjectFilter) <-chan tor.Object {
+
+	ch := make(chan tor.Object)
+
+	go func() {
+		for _, info := range infos.ExtraInfos {
+			if filter == nil || filter.IsEmpty() || filter.HasFingerprint(info.Fingerprint) {
+				ch <- info
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// GetObject implements the tor.ObjectSet interface.  If there are several
+// extra-info descriptors for the given fingerprint, the latest one is
+// returned.
+func (infos *ExtraInfos) GetObject(fpr tor.Fingerprint) (tor.Object, bool) {
+
+	var latest *ExtraInfo
+	for _, info := range infos.ExtraInfos {
+		if info.Fingerprint == fpr && (latest == nil || info.Published.After(latest.Published)) {
+			latest = info
+		}
+	}
+
+	return latest, latest != nil
+}
+
+// Length implements the tor.ObjectSet interface.
+func (infos *ExtraInfos) Length() int {
+
+	return len(infos.ExtraInfos)
+}
+
+// Merge implements the tor.ObjectSet interface.
+func (infos *ExtraInfos) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*ExtraInfos)
+	if !ok {
+		return
+	}
+
+	for digest, info := range other.ExtraInfos {
+		infos.ExtraInfos[digest] = info
+	}
+}
+
+// parseBandwidthHistory parses the arguments of a "write-history" or
+// "read-history" line, e.g.:
+// 2015-08-01 00:05:06 (900 s) 1234,5678,9012
+func parseBandwidthHistory(words []string) (*BandwidthHistory, error) {
+
+	if len(words) < 4 {
+		return nil, fmt.Errorf("Expected at least 4 words in bandwidth history but got %d.", len(words))
+	}
+
+	end, err := time.Parse(statusTimeLayout, words[0]+" "+words[1])
+	if err != nil {
+		return nil, err
+	}
+
+	seconds, err := strconv.ParseUint(strings.TrimPrefix(words[2], "("), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if seconds == 0 {
+		return nil, fmt.Errorf("Bandwidth history has an interval of 0 seconds.")
+	}
+
+	history := &BandwidthHistory{End: end, Interval: time.Duration(seconds) * time.Second}
+
+	// Relays that haven't relayed anything yet have no values.
+	if len(words) < 5 {
+		return history, nil
+	}
+	for _, value := range strings.Split(words[4], ",") {
+		bytes, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		history.Values = append(history.Values, bytes)
+	}
+
+	return history, nil
+}
+
+// parseExtraInfo parses a single raw extra-info descriptor.
+func parseExtraInfo(raw []byte, digest string) (*ExtraInfo, error) {
+
+	info := &ExtraInfo{Digest: digest}
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+
+		var err error
+		switch words[0] {
+		case "extra-info":
+			if len(words) != 3 {
+				return nil, fmt.Errorf("Expected 3 words in \"extra-info\" line but got %d.", len(words))
+			}
+			info.Nickname = words[1]
+			info.Fingerprint = tor.Fingerprint(strings.ToUpper(words[2]))
+		case "published":
+			if len(words) != 3 {
+				return nil, fmt.Errorf("Expected 3 words in \"published\" line but got %d.", len(words))
+			}
+			info.Published, err = time.Parse(statusTimeLayout, words[1]+" "+words[2])
+		case "write-history":
+			info.WriteHistory, err = parseBandwidthHistory(words[1:])
+		case "read-history":
+			info.ReadHistory, err = parseBandwidthHistory(words[1:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if info.Fingerprint == "" {
+		return nil, fmt.Errorf("Extra-info descriptor %s has no fingerprint.", digest)
+	}
+
+	return info, scanner.Err()
+}
+
+// ParseExtraInfos parses one or more extra-info descriptors.
+func ParseExtraInfos(r io.Reader) (tor.ObjectSet, error) {
+
+	blurb, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := NewExtraInfos()
+	offset := 0
+	for {
+		start := bytes.Index(blurb[offset:], []byte(extraInfoStart))
+		if start == -1 {
+			break
+		}
+		start += offset
+
+		// Descriptors start at the beginning of a line.
+		if start > 0 && blurb[start-1] != '\n' {
+			offset = start + len(extraInfoStart)
+			continue
+		}
+
+		end := bytes.Index(blurb[start:], []byte(extraInfoDigestEnd))
+		if end == -1 {
+			return nil, fmt.Errorf("Extra-info descriptor at byte offset %d has no signature.", start)
+		}
+		end += start + len(extraInfoDigestEnd)
+
+		// The digest covers everything from "extra-info" to
+		// "router-signature".
+		digest := sha1.Sum(blurb[start:end])
+		info, err := parseExtraInfo(blurb[start:end], hex.EncodeToString(digest[:]))
+		if err != nil {
+			return nil, err
+		}
+		infos.ExtraInfos[info.Digest] = info
+
+		offset = end
+	}
+
+	if infos.Length() == 0 {
+		return nil, fmt.Errorf("Found no extra-info descriptors.")
+	}
+
+	return infos, nil
+}
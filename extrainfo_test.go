@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Two extra-info descriptors in the format that CollecTor publishes.  The
+// second relay hasn't relayed anything yet, so its history has no values.
+const extraInfoExcerpt = `@type extra-info 1.0
+extra-info seele 000A10D43011EA4928A35F610405F92B4433B4DC
+identity-ed25519
+-----BEGIN ED25519 CERT-----
+AQQABphSAXmjSwQg6gX6+JYwuTf7dxBvKWkbaBe6MhU/uUtFcMN/1ohsAQAgBABC
+-----END ED25519 CERT-----
+published 2019-05-01 00:17:55
+write-history 2019-04-30 23:51:29 (86400 s) 1083392,1201152,907264
+read-history 2019-04-30 23:51:29 (86400 s) 2157568,2396160,1808384
+dirreq-write-history 2019-04-30 23:51:29 (86400 s) 0,0,0
+geoip-db-digest 6346E26E2BC96F8511588CE2695E9B0339A75D32
+router-sig-ed25519 2iJ7RTPFyWrHGExHuQSNGqlEHpAMPRljjv3YWiy3vF/mg6tf4KQWX8p5IM6XNNNsvHfdcDJgpVBKOCsGyp0eCw
+router-signature
+-----BEGIN SIGNATURE-----
+Kk3H9ttoKCOVfV5GgTGcV8HaPrhXy7PBSl1GLi8fMjLhMHwdBj4nTC6ZWgX2Fma5
+-----END SIGNATURE-----
+@type extra-info 1.0
+extra-info CalyxInstitute14 0011BD2485AD45D984EC4159C88FC066E5E3300E
+published 2019-05-01 03:05:07
+write-history 2019-05-01 02:41:10 (900 s)
+router-signature
+-----BEGIN SIGNATURE-----
+ZzaCQiztE+dYUjKLeZpRqZRaYXcy3dLnsyNi7lEKR8qUBYtDyOzDaVTXFQoU6B8x
+-----END SIGNATURE-----
+`
+
+func TestParseExtraInfos(t *testing.T) {
+
+	objects, err := ParseExtraInfos(strings.NewReader(extraInfoExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse extra-info descriptors: %s", err)
+	}
+	infos := objects.(*ExtraInfos)
+	if infos.Length() != 2 {
+		t.Fatalf("Expected 2 extra-info descriptors but got %d.", infos.Length())
+	}
+
+	// The digests are the SHA-1 digests over everything from "extra-info"
+	// up to and including the "router-signature" line.
+	info, exists := infos.ExtraInfos["ec4fa41353a643df799baa22541673bf401c50ce"]
+	if !exists {
+		t.Fatalf("Could not find seele's extra-info descriptor among %v.", infos.ExtraInfos)
+	}
+	if info.Nickname != "seele" || info.Fingerprint != "000A10D43011EA4928A35F610405F92B4433B4DC" {
+		t.Errorf("Got nickname %s and fingerprint %s.", info.Nickname, info.Fingerprint)
+	}
+	if !info.Published.Equal(time.Date(2019, 5, 1, 0, 17, 55, 0, time.UTC)) {
+		t.Errorf("Got publication time %s.", info.Published)
+	}
+	if !reflect.DeepEqual(info.WriteHistory.Values, []uint64{1083392, 1201152, 907264}) {
+		t.Errorf("Got write history %v.", info.WriteHistory.Values)
+	}
+	times := info.ReadHistory.Times()
+	if info.ReadHistory.Interval != 24*time.Hour || len(times) != 3 ||
+		!times[0].Equal(time.Date(2019, 4, 28, 23, 51, 29, 0, time.UTC)) {
+		t.Errorf("Got read history interval %s and times %v.", info.ReadHistory.Interval, times)
+	}
+
+	info, exists = infos.ExtraInfos["66023508b5f71b33e631053b63ba5760124bb143"]
+	if !exists {
+		t.Fatalf("Could not find CalyxInstitute14's extra-info descriptor among %v.", infos.ExtraInfos)
+	}
+	if info.WriteHistory == nil || len(info.WriteHistory.Values) != 0 || info.ReadHistory != nil {
+		t.Errorf("Got write history %v and read history %v.", info.WriteHistory, info.ReadHistory)
+	}
+}
+
+func TestParseExtraInfosErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"no descriptors", "@type extra-info 1.0\n"},
+		{"no signature", "extra-info seele 000A10D43011EA4928A35F610405F92B4433B4DC\npublished 2019-05-01 00:17:55\n"},
+		{"short extra-info line", "extra-info seele\nrouter-signature\n"},
+		{"malformed published line", "extra-info seele 000A10D43011EA4928A35F610405F92B4433B4DC\n" +
+			"published 2019-05-01\nrouter-signature\n"},
+		{"short history", "extra-info seele 000A10D43011EA4928A35F610405F92B4433B4DC\n" +
+			"write-history 2019-04-30 23:51:29\nrouter-signature\n"},
+		{"zero interval", "extra-info seele 000A10D43011EA4928A35F610405F92B4433B4DC\n" +
+			"write-history 2019-04-30 23:51:29 (0 s) 1,2\nrouter-signature\n"},
+		{"bad history value", "extra-info seele 000A10D43011EA4928A35F610405F92B4433B4DC\n" +
+			"read-history 2019-04-30 23:51:29 (900 s) 1,x\nrouter-signature\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseExtraInfos(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
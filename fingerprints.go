@@ -7,6 +7,7 @@ import (
 	"log"
 	"sort"
 	"sync"
+	"time"
 
 	tor "git.torproject.org/user/phw/zoossh.git"
 )
@@ -66,19 +67,71 @@ func AnalyseFingerprints(channel chan tor.ObjectSet, params *CmdLineParams, grou
 	// representation instead.
 	var fprAnalysis map[string]FprStats = map[string]FprStats{}
 
+	var lastTime time.Time
+	var warnedBridges bool
+	resumeTime := params.Checkpoint.Restore("fingerprints", &fprAnalysis)
+	saveState := func() {
+		if params.Checkpoint != nil && !lastTime.IsZero() {
+			params.Checkpoint.Save("fingerprints", lastTime, fprAnalysis)
+		}
+	}
+	// Save our state once we're done, including after an interruption.
+	defer saveState()
+
 	for objects := range channel {
+		// Follow mode finished a batch, so report what we have so far.
+		if objects == EndOfBatch {
+			printFingerprints(fprAnalysis)
+			continue
+		}
+		if alreadyProcessed(objects, resumeTime) {
+			continue
+		}
+
 		switch v := objects.(type) {
-		case *tor.Consensus:
-			for fpr, getVal := range v.RouterStatuses {
+		case *Consensus, *MicrodescConsensus:
+			for fpr, getVal := range AsConsensus(v).RouterStatuses {
 				countFingerprints(fpr, getVal().Address.String(), fprAnalysis)
 			}
 		case *tor.RouterDescriptors:
 			for fpr, getVal := range v.RouterDescriptors {
 				countFingerprints(fpr, getVal().Address.String(), fprAnalysis)
 			}
+		case *BridgeNetworkStatus:
+			for fpr, getVal := range v.RouterStatuses {
+				status := getVal()
+				countFingerprints(fpr, bridgeKey(status.Nickname, status.Address.IPv4ORPort), fprAnalysis)
+			}
+		case *BridgeDescriptors:
+			for fpr, getVal := range v.RouterDescriptors.RouterDescriptors {
+				desc := getVal()
+				countFingerprints(fpr, bridgeKey(desc.Nickname, desc.ORPort), fprAnalysis)
+			}
+		}
+
+		switch objects.(type) {
+		case *BridgeNetworkStatus, *BridgeDescriptors:
+			if !warnedBridges {
+				log.Println("Bridge addresses are scrubbed together with the bridge's fingerprint, so we group bridges by nickname and OR port instead.")
+				warnedBridges = true
+			}
+		}
+
+		if t := ObjectSetTime(objects); !t.IsZero() {
+			lastTime = t
+		}
+		if params.Checkpoint.Due("fingerprints") {
+			saveState()
 		}
 	}
 
+	printFingerprints(fprAnalysis)
+}
+
+// printFingerprints prints the IP addresses in the given analysis, sorted by
+// their number of unique fingerprints.
+func printFingerprints(fprAnalysis map[string]FprStats) {
+
 	vs := ValueSorter{
 		keys: make([]string, 0),
 		vals: make([]int, 0),
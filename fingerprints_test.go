@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+func TestAnalyseFingerprintsGroupsBridges(t *testing.T) {
+
+	// Scrubbed addresses change together with the fingerprint, so bridges
+	// that change their fingerprint are grouped by nickname and OR port.
+	status1 := bridgeStatusAt(0)
+	status1.Set("AAAA", &tor.RouterStatus{Nickname: "seele", Fingerprint: "AAAA",
+		Address: tor.RouterAddress{IPv4ORPort: 9001}})
+	status2 := bridgeStatusAt(60)
+	status2.Set("BBBB", &tor.RouterStatus{Nickname: "seele", Fingerprint: "BBBB",
+		Address: tor.RouterAddress{IPv4ORPort: 9001}})
+
+	output := runAnalysis(t, AnalyseFingerprints, new(CmdLineParams), status1, status2)
+	if !strings.HasPrefix(output, "seele:9001 (2 unique fingerprints)\n") {
+		t.Errorf("Expected both fingerprints under seele:9001 but got:\n%s", output)
+	}
+}
@@ -0,0 +1,186 @@
+// Watches the data paths for new files and passes them to running analyses.
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// endOfBatch is the type of EndOfBatch.  It's an empty object set.
+type endOfBatch struct{}
+
+// Iterate implements the tor.ObjectSet interface.
+func (endOfBatch) Iterate(*tor.ObjectFilter) <-chan tor.Object {
+
+	objects := make(chan tor.Object)
+	close(objects)
+
+	return objects
+}
+
+// GetObject implements the tor.ObjectSet interface.
+func (endOfBatch) GetObject(tor.Fingerprint) (tor.Object, bool) {
+
+	return nil, false
+}
+
+// Length implements the tor.ObjectSet interface.
+func (endOfBatch) Length() int {
+
+	return 0
+}
+
+// Merge implements the tor.ObjectSet interface.
+func (endOfBatch) Merge(tor.ObjectSet) {
+}
+
+// EndOfBatch is passed to all analyses in follow mode, once after the files
+// that existed when we started, and then after every batch of new files.
+// Analyses that only report at the end of a run report what they have so far
+// when they receive it.  Analyses that report every object set as it arrives
+// have nothing left to report and ignore it.  It's never passed on outside of
+// follow mode.
+var EndOfBatch tor.ObjectSet = endOfBatch{}
+
+// fileChanged returns true if the two given FileInfos don't describe the same
+// file content.
+func fileChanged(before, after os.FileInfo) bool {
+
+	return before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime())
+}
+
+// snapshotFiles returns the state of all files in the given data paths.
+// Stdin cannot be watched, so it's ignored.
+func snapshotFiles(paths []string) map[string]os.FileInfo {
+
+	snapshot := make(map[string]os.FileInfo)
+
+	for _, path := range paths {
+		if path == stdinPath {
+			continue
+		}
+
+		filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			// Files might disappear while we walk.  We'll see them again in
+			// the next round if they come back.
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			snapshot[path] = info
+			return nil
+		})
+	}
+
+	return snapshot
+}
+
+// Follower keeps track of the files that were already processed, and finds new
+// and modified ones.  A file is only reported once its size and modification
+// time didn't change between two polls, so we don't read files that are still
+// being written, e.g., by rsync.
+type Follower struct {
+	processed map[string]os.FileInfo
+	previous  map[string]os.FileInfo
+}
+
+// NewFollower allocates and returns a new follower.  The given snapshot holds
+// the files that were already processed, in the state in which we read them.
+func NewFollower(snapshot map[string]os.FileInfo) *Follower {
+
+	processed := make(map[string]os.FileInfo)
+	for path, info := range snapshot {
+		processed[path] = info
+	}
+
+	return &Follower{
+		processed: processed,
+		previous:  snapshot,
+	}
+}
+
+// Poll takes a new snapshot of the given data paths and returns the sorted
+// paths of all files that are new or were modified, and are now complete.
+func (f *Follower) Poll(paths []string) []string {
+
+	current := snapshotFiles(paths)
+	ready := make([]string, 0)
+
+	for path, info := range current {
+		if processed, exists := f.processed[path]; exists && !fileChanged(processed, info) {
+			continue
+		}
+		if previous, exists := f.previous[path]; !exists || fileChanged(previous, info) {
+			continue
+		}
+		ready = append(ready, path)
+		f.processed[path] = info
+	}
+	f.previous = current
+
+	sortDataPaths(ready)
+
+	return ready
+}
+
+// followArchiveData polls the data paths for new files and passes their
+// object sets through the given reorder buffer, until the given interrupter
+// caught SIGINT.  After every batch of new files, EndOfBatch is sent to the
+// analyses.  The given snapshot holds the files that were already processed.
+func followArchiveData(snapshot map[string]os.FileInfo, buffer *ReorderBuffer, report *IngestionReport, interrupter *Interrupter, channels []chan tor.ObjectSet, params *CmdLineParams) error {
+
+	follower := NewFollower(snapshot)
+	log.Printf("Following \"%s\" for new files every %s.\n", params.ArchiveData, params.FollowInterval)
+
+	for {
+		select {
+		case <-time.After(params.FollowInterval):
+		case <-interrupter.Done():
+			return errInterrupted
+		}
+
+		// Glob patterns may match new files, e.g., a new monthly tarball.
+		paths, err := ExpandArchivePaths(params.ArchiveData)
+		if err != nil {
+			log.Printf("Could not expand -data argument: %s\n", err)
+			continue
+		}
+
+		ready := follower.Poll(paths)
+		if len(ready) == 0 {
+			continue
+		}
+		log.Printf("Found %d new file(s).\n", len(ready))
+
+		pool := NewParserPool(params.Workers, params.Cache, CollectObjects(nil, buffer, report, params), report.OnParseError)
+		callback := GatherObjects(pool, report, interrupter, params)
+		for _, path := range ready {
+			if err = walkFile(path, follower.processed[path], callback); err != nil {
+				if err = report.OnWalkError(path, err); err != nil {
+					break
+				}
+			}
+		}
+		pool.Close()
+		if err == nil {
+			err = report.Err()
+		}
+		if err != nil && err != errInterrupted {
+			return err
+		}
+
+		// New files arrive infrequently, so there's no point in holding
+		// them back for reordering.
+		buffer.Flush()
+		for _, channel := range channels {
+			channel <- EndOfBatch
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
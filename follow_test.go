@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollowerPoll(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sybilhunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "2015-08-01-00-00-00-consensus")
+	ioutil.WriteFile(old, []byte("old"), 0600)
+
+	// Record the files in the state in which we walk them.
+	walked := make(map[string]os.FileInfo)
+	err = walkArchiveData([]string{dir}, func(string, os.FileInfo, io.Reader) error {
+		return nil
+	}, func(path string, err error) error {
+		return err
+	}, func(path string, info os.FileInfo) {
+		walked[path] = info
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := walked[old]; !exists {
+		t.Fatalf("Expected %s to be visited but got %v.", old, walked)
+	}
+
+	// A new file, and the old one modified after we read it.
+	created := filepath.Join(dir, "2015-08-01-01-00-00-consensus")
+	ioutil.WriteFile(created, []byte("new"), 0600)
+	ioutil.WriteFile(old, []byte("modified"), 0600)
+	os.Chtimes(old, testEpoch, testEpoch)
+
+	follower := NewFollower(walked)
+	paths := []string{dir}
+
+	// Files are only ready once they didn't change between two polls.
+	if ready := follower.Poll(paths); len(ready) != 0 {
+		t.Errorf("Expected no ready files after the first poll but got %s.", ready)
+	}
+	ready := follower.Poll(paths)
+	if len(ready) != 2 || ready[0] != old || ready[1] != created {
+		t.Errorf("Expected %s and %s to be ready but got %s.", old, created, ready)
+	}
+
+	if ready := follower.Poll(paths); len(ready) != 0 {
+		t.Errorf("Expected processed files not to be ready again but got %s.", ready)
+	}
+
+	// A file that's still being written is held back.
+	growing := filepath.Join(dir, "2015-08-01-02-00-00-consensus")
+	ioutil.WriteFile(growing, []byte("part"), 0600)
+	follower.Poll(paths)
+	ioutil.WriteFile(growing, []byte("partial"), 0600)
+	os.Chtimes(growing, testEpoch.Add(time.Hour), testEpoch.Add(time.Hour))
+	if ready := follower.Poll(paths); len(ready) != 0 {
+		t.Errorf("Expected growing file to be held back but got %s.", ready)
+	}
+	if ready := follower.Poll(paths); len(ready) != 1 || ready[0] != growing {
+		t.Errorf("Expected %s to be ready but got %s.", growing, ready)
+	}
+}
+
+func TestEndOfBatch(t *testing.T) {
+
+	if EndOfBatch.Length() != 0 {
+		t.Errorf("Expected EndOfBatch to be empty but it has length %d.", EndOfBatch.Length())
+	}
+	for object := range EndOfBatch.Iterate(nil) {
+		t.Errorf("Expected EndOfBatch to be empty but got %v.", object)
+	}
+	if !ObjectSetTime(EndOfBatch).IsZero() {
+		t.Error("Expected EndOfBatch to carry no time.")
+	}
+}
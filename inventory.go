@@ -0,0 +1,421 @@
+// Takes inventory of archived data: which documents we have, which time span
+// they cover, and what's missing.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const (
+	inventoryJSONFile   = "inventory.json"
+	inventoryCSVFile    = "inventory.csv"
+	missingHoursCSVFile = "inventory-missing-hours.csv"
+	unknownDocumentType = "unknown"
+)
+
+// collectorFileNames holds the file name patterns that CollecTor uses for the
+// documents it archives.
+var collectorFileNames = []*regexp.Regexp{
+	// Consensuses, microdescriptor consensuses, and votes.
+	regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-consensus(-microdesc)?$`),
+	regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-vote-[0-9A-F]{40}-[0-9A-F]{40}$`),
+	// Server descriptors, extra-info descriptors, and microdescriptors are
+	// named after their digest.
+	regexp.MustCompile(`^[0-9a-f]{40}$`),
+	regexp.MustCompile(`^[0-9a-f]{64}$`),
+	// Exit lists.
+	regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}$`),
+	// Bridge network statuses.
+	regexp.MustCompile(`^\d{8}-\d{6}-[0-9A-F]{40}$`),
+	// Bandwidth files.
+	regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-bandwidth-[0-9A-F]{40}$`),
+	// Concatenated documents in CollecTor's "recent" directory.
+	regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-[a-z-]+$`),
+}
+
+// DocTypeInventory summarises all documents of a given type.  For network
+// status documents, which are published every hour, it also lists the hours
+// between the earliest and the latest document for which we have none.
+type DocTypeInventory struct {
+	Type         string      `json:"type"`
+	Files        int         `json:"files"`
+	Parsed       int         `json:"parsed"`
+	Duplicates   int         `json:"duplicates"`
+	Earliest     time.Time   `json:"earliest"`
+	Latest       time.Time   `json:"latest"`
+	MissingHours []time.Time `json:"missing_hours,omitempty"`
+
+	// statusTimes maps the times of network status documents to the path
+	// of the first document with that time.
+	statusTimes map[time.Time]string
+}
+
+// DuplicateDocument represents a document that we came across more than once.
+type DuplicateDocument struct {
+	Path        string `json:"path"`
+	DuplicateOf string `json:"duplicate_of"`
+	Reason      string `json:"reason"`
+}
+
+// Inventory holds what we found in the archived data.
+type Inventory struct {
+	Types               map[string]*DocTypeInventory `json:"types"`
+	Duplicates          []DuplicateDocument          `json:"duplicates"`
+	UnconventionalNames []string                     `json:"unconventional_names"`
+	Report              *IngestionReport             `json:"ingestion"`
+
+	contentHashes map[[sha256.Size]byte]string
+}
+
+// NewInventory allocates and returns a new inventory.
+func NewInventory(report *IngestionReport) *Inventory {
+
+	return &Inventory{
+		Types:               make(map[string]*DocTypeInventory),
+		Duplicates:          make([]DuplicateDocument, 0),
+		UnconventionalNames: make([]string, 0),
+		Report:              report,
+		contentHashes:       make(map[[sha256.Size]byte]string),
+	}
+}
+
+// IsHourlyStatus returns true if the given object set is a network status
+// document that is published every hour, i.e., a consensus, a microdescriptor
+// consensus, or a bridge network status.  Votes are published every hour as
+// well, but by every authority, so their times don't tell us what's missing.
+func IsHourlyStatus(objects tor.ObjectSet) bool {
+
+	switch objects.(type) {
+	case *Consensus, *MicrodescConsensus, *BridgeNetworkStatus:
+		return true
+	}
+
+	return false
+}
+
+// HasCollecTorName returns true if the given file name follows one of
+// CollecTor's naming conventions.
+func HasCollecTorName(fileName string) bool {
+
+	base := path.Base(fileName)
+	for _, pattern := range collectorFileNames {
+		if pattern.MatchString(base) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// annotatedType extracts the document type from the "@type" annotation in the
+// first line of the given blurb, e.g., "server-descriptor" from
+// "@type server-descriptor 1.0".  If there's no annotation, it returns an
+// empty string.
+func annotatedType(blurb []byte) string {
+
+	line, _ := bufio.NewReader(bytes.NewReader(blurb)).ReadString('\n')
+	words := strings.Fields(line)
+	if len(words) < 2 || words[0] != "@type" {
+		return ""
+	}
+
+	return words[1]
+}
+
+// getType returns the inventory for the given document type, which is created
+// if it doesn't exist yet.
+func (inv *Inventory) getType(docType string) *DocTypeInventory {
+
+	typeInv, exists := inv.Types[docType]
+	if !exists {
+		typeInv = &DocTypeInventory{Type: docType, statusTimes: make(map[time.Time]string)}
+		inv.Types[docType] = typeInv
+	}
+
+	return typeInv
+}
+
+// addDuplicate records the given duplicate document.
+func (inv *Inventory) addDuplicate(typeInv *DocTypeInventory, path, original, reason string) {
+
+	typeInv.Duplicates++
+	inv.Duplicates = append(inv.Duplicates, DuplicateDocument{path, original, reason})
+}
+
+// AddFile takes inventory of the file with the given path and content.
+// Objects outside of the desired date range are not considered.
+func (inv *Inventory) AddFile(path string, info os.FileInfo, blurb []byte, params *CmdLineParams) {
+
+	if !HasCollecTorName(path) {
+		inv.UnconventionalNames = append(inv.UnconventionalNames, path)
+	}
+
+	objects, err := ParseDocument(blurb)
+
+	docType := annotatedType(blurb)
+	if docType == "" {
+		if err == nil && objects != nil {
+			docType = DocumentType(objects)
+		} else {
+			docType = unknownDocumentType
+		}
+	}
+
+	typeInv := inv.getType(docType)
+	typeInv.Files++
+
+	hash := sha256.Sum256(blurb)
+	if original, exists := inv.contentHashes[hash]; exists {
+		inv.addDuplicate(typeInv, path, original, "identical content")
+		return
+	}
+	inv.contentHashes[hash] = path
+
+	if err != nil {
+		inv.Report.AddSkipped(path, FileOffset(info), err)
+		return
+	}
+	if objects == nil {
+		return
+	}
+	inv.Report.AddParsed(objects)
+	typeInv.Parsed++
+
+	objects = FilterObjectSet(objects, params.StartDate, params.EndDate)
+	if objects == nil {
+		inv.Report.AddOutOfRange()
+		return
+	}
+
+	t := ObjectSetTime(objects)
+	if t.IsZero() {
+		return
+	}
+	if typeInv.Earliest.IsZero() || t.Before(typeInv.Earliest) {
+		typeInv.Earliest = t
+	}
+	if t.After(typeInv.Latest) {
+		typeInv.Latest = t
+	}
+
+	if IsHourlyStatus(objects) {
+		if original, exists := typeInv.statusTimes[t]; exists {
+			inv.addDuplicate(typeInv, path, original, "same valid-after time")
+			return
+		}
+		typeInv.statusTimes[t] = path
+	}
+}
+
+// determineMissingHours determines, for every type of network status
+// document, all hours between the earliest and the latest document, for which
+// we don't have a document.
+func (inv *Inventory) determineMissingHours() {
+
+	for _, typeInv := range inv.Types {
+		if len(typeInv.statusTimes) == 0 {
+			continue
+		}
+
+		hours := make(map[time.Time]bool)
+		for t := range typeInv.statusTimes {
+			hours[t.Truncate(time.Hour)] = true
+		}
+
+		for hour := typeInv.Earliest.Truncate(time.Hour); !hour.After(typeInv.Latest); hour = hour.Add(time.Hour) {
+			if !hours[hour] {
+				typeInv.MissingHours = append(typeInv.MissingHours, hour)
+			}
+		}
+	}
+}
+
+// sortedTypes returns the inventory's document types in alphabetical order.
+func (inv *Inventory) sortedTypes() []string {
+
+	types := make([]string, 0, len(inv.Types))
+	for docType := range inv.Types {
+		types = append(types, docType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// formatTime formats the given time for our output, or returns "NA" if it's
+// the zero time.
+func formatTime(t time.Time) string {
+
+	if t.IsZero() {
+		return "NA"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// Print writes a human-readable summary of the inventory to the given writer.
+func (inv *Inventory) Print(w io.Writer) {
+
+	fmt.Fprintln(w, "Document types:")
+	for _, docType := range inv.sortedTypes() {
+		typeInv := inv.Types[docType]
+		fmt.Fprintf(w, "\t%s: %d files (%d parsed, %d duplicates), from %s to %s\n",
+			docType, typeInv.Files, typeInv.Parsed, typeInv.Duplicates,
+			formatTime(typeInv.Earliest), formatTime(typeInv.Latest))
+	}
+
+	for _, docType := range inv.sortedTypes() {
+		typeInv := inv.Types[docType]
+		if len(typeInv.statusTimes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "Missing hours of %s: %d\n", docType, len(typeInv.MissingHours))
+		for _, hour := range typeInv.MissingHours {
+			fmt.Fprintf(w, "\t%s\n", formatTime(hour))
+		}
+	}
+
+	fmt.Fprintf(w, "Duplicate documents: %d\n", len(inv.Duplicates))
+	for _, dup := range inv.Duplicates {
+		fmt.Fprintf(w, "\t%s (%s as %s)\n", dup.Path, dup.Reason, dup.DuplicateOf)
+	}
+
+	fmt.Fprintf(w, "Files with unconventional names: %d\n", len(inv.UnconventionalNames))
+	for _, name := range inv.UnconventionalNames {
+		fmt.Fprintf(w, "\t%s\n", name)
+	}
+
+	fmt.Fprintf(w, "Skipped files: %d\n", len(inv.Report.Skipped))
+	for _, skipped := range inv.Report.Skipped {
+		fmt.Fprintf(w, "\t%s (at byte offset %d): %s\n", skipped.Path, skipped.Offset, skipped.Reason)
+	}
+}
+
+// WriteFiles writes the inventory as JSON and CSV to the output directory.
+func (inv *Inventory) WriteFiles() error {
+
+	fd, err := createOutputFile(inventoryJSONFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	encoder := json.NewEncoder(fd)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(inv); err != nil {
+		return err
+	}
+	log.Printf("Wrote inventory to \"%s\".\n", fd.Name())
+
+	fd, err = createOutputFile(inventoryCSVFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	writer := csv.NewWriter(fd)
+	writer.Write([]string{"type", "files", "parsed", "duplicates", "earliest", "latest"})
+	for _, docType := range inv.sortedTypes() {
+		typeInv := inv.Types[docType]
+		writer.Write([]string{
+			docType,
+			strconv.Itoa(typeInv.Files),
+			strconv.Itoa(typeInv.Parsed),
+			strconv.Itoa(typeInv.Duplicates),
+			formatTime(typeInv.Earliest),
+			formatTime(typeInv.Latest)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	log.Printf("Wrote inventory to \"%s\".\n", fd.Name())
+
+	fd, err = createOutputFile(missingHoursCSVFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	writer = csv.NewWriter(fd)
+	writer.Write([]string{"type", "missing_hour"})
+	for _, docType := range inv.sortedTypes() {
+		for _, hour := range inv.Types[docType].MissingHours {
+			writer.Write([]string{docType, formatTime(hour)})
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	log.Printf("Wrote missing hours to \"%s\".\n", fd.Name())
+
+	return nil
+}
+
+// TakeInventory walks over the given archive data and reports which document
+// types it contains, the time span they cover, missing hours of network
+// status documents, duplicate documents, and files whose names don't follow
+// CollecTor's conventions.
+func TakeInventory(params *CmdLineParams) error {
+
+	report := NewIngestionReport(params.Strict)
+	inv := NewInventory(report)
+
+	log.Printf("Taking inventory of \"%s\".\n", params.ArchiveData)
+
+	walkFn := func(path string, info os.FileInfo, r io.Reader) error {
+
+		if err := report.Err(); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if !fileInRange(path, params.StartDate, params.EndDate) {
+			report.AddOutOfRange()
+			return nil
+		}
+
+		blurb, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		inv.AddFile(path, info, blurb, params)
+
+		return nil
+	}
+
+	err := walkArchiveData(params.ArchivePaths, walkFn, report.OnWalkError, nil)
+	if err == nil {
+		err = report.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	inv.determineMissingHours()
+	inv.Print(os.Stdout)
+
+	return inv.WriteFiles()
+}
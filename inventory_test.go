@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHasCollecTorName(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"consensuses-2015-08/01/2015-08-01-00-00-00-consensus", true},
+		{"2015-08-01-00-00-00-consensus-microdesc", true},
+		{"server-descriptors-2015-08/0/0/00a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3", true},
+		{"20150801-001234-4A0CCD2DDC7995083D73F5D667100C8A5831F16D", true},
+		{"my-consensus.txt", false},
+	}
+
+	for _, test := range tests {
+		if HasCollecTorName(test.name) != test.expected {
+			t.Errorf("Expected %t for %s.", test.expected, test.name)
+		}
+	}
+}
+
+func TestInventoryDuplicatesAndNames(t *testing.T) {
+
+	inv := NewInventory(NewIngestionReport(false))
+	info := &testFileInfo{"consensus", 3, testEpoch}
+	inv.AddFile("2015-08-01-00-00-00-consensus", info, []byte("foo"), new(CmdLineParams))
+	inv.AddFile("copy-of-consensus", info, []byte("foo"), new(CmdLineParams))
+
+	if len(inv.Duplicates) != 1 || inv.Duplicates[0].Path != "copy-of-consensus" {
+		t.Errorf("Expected copy-of-consensus to be a duplicate but got %+v.", inv.Duplicates)
+	}
+	if len(inv.UnconventionalNames) != 1 || inv.UnconventionalNames[0] != "copy-of-consensus" {
+		t.Errorf("Expected copy-of-consensus to have an unconventional name but got %s.", inv.UnconventionalNames)
+	}
+}
+
+func TestInventoryMissingHours(t *testing.T) {
+
+	inv := NewInventory(NewIngestionReport(false))
+	typeInv := inv.getType("network-status-consensus")
+	for _, hour := range []int{0, 1, 4} {
+		valid := testEpoch.Add(time.Duration(hour) * time.Hour)
+		typeInv.statusTimes[valid] = valid.String()
+	}
+	typeInv.Earliest = testEpoch
+	typeInv.Latest = testEpoch.Add(4 * time.Hour)
+
+	// Types without network statuses have no missing hours.
+	other := inv.getType("server-descriptor")
+	other.Earliest = testEpoch
+	other.Latest = testEpoch.Add(4 * time.Hour)
+
+	inv.determineMissingHours()
+
+	expected := []time.Time{testEpoch.Add(2 * time.Hour), testEpoch.Add(3 * time.Hour)}
+	if len(typeInv.MissingHours) != len(expected) {
+		t.Fatalf("Expected missing hours %s but got %s.", expected, typeInv.MissingHours)
+	}
+	for i := range expected {
+		if !typeInv.MissingHours[i].Equal(expected[i]) {
+			t.Errorf("Expected missing hours %s but got %s.", expected, typeInv.MissingHours)
+		}
+	}
+	if len(other.MissingHours) != 0 {
+		t.Errorf("Expected no missing hours for descriptors but got %s.", other.MissingHours)
+	}
+}
@@ -0,0 +1,657 @@
+// Parses microdescriptor consensuses and microdescriptors, which clients use
+// instead of the full consensus and server descriptors.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// Time format of timestamps in network statuses.
+const statusTimeLayout = "2006-01-02 15:04:05"
+
+// MicrodescConsensus is a microdescriptor consensus.  Its router statuses
+// carry the same information as the ones in a consensus, except that they
+// reference microdescriptors rather than server descriptors.  Analyses that
+// only need router statuses can use the embedded consensus.  Unlike the
+// consensus' router flags, the embedded status flags also hold flags that
+// zoossh doesn't know about.
+type MicrodescConsensus struct {
+	*tor.Consensus
+	StatusFlags
+
+	// Microdescs maps relay fingerprints to the digests of their
+	// microdescriptors, as given in the consensus' "m" lines.
+	Microdescs map[tor.Fingerprint]string
+}
+
+// NewMicrodescConsensus allocates and returns a new microdescriptor consensus.
+func NewMicrodescConsensus() *MicrodescConsensus {
+
+	return &MicrodescConsensus{
+		Consensus:   tor.NewConsensus(),
+		StatusFlags: newStatusFlags(),
+		Microdescs:  make(map[tor.Fingerprint]string),
+	}
+}
+
+// Merge merges the given object set into the microdescriptor consensus.
+func (consensus *MicrodescConsensus) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*MicrodescConsensus)
+	if !ok {
+		return
+	}
+
+	consensus.Consensus.Merge(other.Consensus)
+	consensus.StatusFlags.merge(&other.StatusFlags)
+	for fpr, digest := range other.Microdescs {
+		consensus.Microdescs[fpr] = digest
+	}
+}
+
+// AsConsensus returns the router statuses of the given object set as
+// consensus, for consensuses, microdescriptor consensuses, and bridge network
+// statuses.  It returns nil for other object sets.
+func AsConsensus(objects tor.ObjectSet) *tor.Consensus {
+
+	switch v := objects.(type) {
+	case *Consensus:
+		return v.Consensus
+	case *MicrodescConsensus:
+		return v.Consensus
+	case *BridgeNetworkStatus:
+		return v.Consensus
+	}
+
+	return nil
+}
+
+// Microdescriptor holds the content of a microdescriptor.  Microdescriptors
+// don't contain their relay's fingerprint, unless they carry an "id rsa1024"
+// line, so Fingerprint can be empty.
+type Microdescriptor struct {
+	Digest       string
+	Fingerprint  tor.Fingerprint
+	OnionKey     string
+	NTorOnionKey string
+	Family       map[tor.Fingerprint]bool
+	Accept       bool
+	PortList     string
+}
+
+// GetFingerprint implements the tor.Object interface.
+func (desc *Microdescriptor) GetFingerprint() tor.Fingerprint {
+
+	return desc.Fingerprint
+}
+
+// String implements the tor.Object interface.
+func (desc *Microdescriptor) String() string {
+
+	policy := "reject"
+	if desc.Accept {
+		policy = "accept"
+	}
+
+	return fmt.Sprintf("%s,%s,%s,%d,%s %s", desc.Digest, desc.Fingerprint, desc.NTorOnionKey, len(desc.Family), policy, desc.PortList)
+}
+
+// Microdescriptors is a set of microdescriptors, keyed by their digest.
+type Microdescriptors struct {
+	Microdescriptors map[string]*Microdescriptor
+}
+
+// NewMicrodescriptors allocates and returns a new set of microdescriptors.
+func NewMicrodescriptors() *Microdescriptors {
+
+	return &Microdescriptors{Microdescriptors: make(map[string]*Microdescriptor)}
+}
+
+// Iterate implements the tor.ObjectSet interface.  If the given filter is not
+// empty, only microdescriptors with a matching fingerprint are returned.
+func (descs *Microdescriptors) Iterate(filter *tor.ObjectFilter) <-chan tor.Object {
+
+	ch := make(chan tor.Object)
+
+	go func() {
+		for _, desc := range descs.Microdescriptors {
+			if filter == nil || filter.IsEmpty() || filter.HasFingerprint(desc.Fingerprint) {
+				ch <- desc
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// GetObject implements the tor.ObjectSet interface.
+func (descs *Microdescriptors) GetObject(fpr tor.Fingerprint) (tor.Object, bool) {
+
+	for _, desc := range descs.Microdescriptors {
+		if desc.Fingerprint == fpr {
+			return desc, true
+		}
+	}
+
+	return nil, false
+}
+
+// Length implements the tor.ObjectSet interface.
+func (descs *Microdescriptors) Length() int {
+
+	return len(descs.Microdescriptors)
+}
+
+// Merge implements the tor.ObjectSet interface.
+func (descs *Microdescriptors) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*Microdescriptors)
+	if !ok {
+		return
+	}
+
+	for digest, desc := range other.Microdescriptors {
+		descs.Microdescriptors[digest] = desc
+	}
+}
+
+// base64ToFingerprint turns the given base64-encoded identity digest, as used
+// in network statuses, into a hex-encoded fingerprint.
+func base64ToFingerprint(encoded string) (tor.Fingerprint, error) {
+
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return "", err
+	}
+
+	return tor.Fingerprint(strings.ToUpper(hex.EncodeToString(raw))), nil
+}
+
+// parseRouterFlags turns the given flags of an "s" line into router flags.
+func parseRouterFlags(flags []string) tor.RouterFlags {
+
+	var routerFlags tor.RouterFlags
+
+	for _, flag := range flags {
+		switch flag {
+		case "Authority":
+			routerFlags.Authority = true
+		case "BadExit":
+			routerFlags.BadExit = true
+		case "Exit":
+			routerFlags.Exit = true
+		case "Fast":
+			routerFlags.Fast = true
+		case "Guard":
+			routerFlags.Guard = true
+		case "HSDir":
+			routerFlags.HSDir = true
+		case "Named":
+			routerFlags.Named = true
+		case "Stable":
+			routerFlags.Stable = true
+		case "Running":
+			routerFlags.Running = true
+		case "Unnamed":
+			routerFlags.Unnamed = true
+		case "Valid":
+			routerFlags.Valid = true
+		case "V2Dir":
+			routerFlags.V2Dir = true
+		}
+	}
+
+	return routerFlags
+}
+
+// parseMicrodescRouterLine parses an "r" line of a microdescriptor consensus.
+// Unlike in a consensus, the line has no descriptor digest, so it consists of
+// nickname, identity, publication date and time, address, ORPort, and DirPort.
+func parseMicrodescRouterLine(words []string) (*tor.RouterStatus, error) {
+
+	if len(words) != 8 {
+		return nil, fmt.Errorf("Expected 8 words in \"r\" line but got %d.", len(words))
+	}
+
+	fpr, err := base64ToFingerprint(words[2])
+	if err != nil {
+		return nil, err
+	}
+
+	publication, err := time.Parse(statusTimeLayout, words[3]+" "+words[4])
+	if err != nil {
+		return nil, err
+	}
+
+	orPort, err := strconv.ParseUint(words[6], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	dirPort, err := strconv.ParseUint(words[7], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &tor.RouterStatus{
+		Nickname:    words[1],
+		Fingerprint: fpr,
+		Publication: publication,
+	}
+	status.Address.IPv4Address = net.ParseIP(words[5])
+	status.Address.IPv4ORPort = uint16(orPort)
+	status.Address.IPv4DirPort = uint16(dirPort)
+
+	return status, nil
+}
+
+// parseStatusItem parses the given "a", "s", "v", "w", or "p" line of a router
+// status, and adds its content to the given router status.  Malformed lines
+// are ignored.
+func parseStatusItem(status *tor.RouterStatus, words []string) {
+
+	switch words[0] {
+	case "a":
+		if len(words) == 2 {
+			host, port, err := net.SplitHostPort(words[1])
+			if err == nil {
+				orPort, _ := strconv.ParseUint(port, 10, 16)
+				status.Address.IPv6Address = net.ParseIP(host)
+				status.Address.IPv6ORPort = uint16(orPort)
+			}
+		}
+	case "s":
+		status.Flags = parseRouterFlags(words[1:])
+	case "v":
+		if len(words) >= 3 {
+			status.TorVersion = words[2]
+		}
+	case "w":
+		for _, word := range words[1:] {
+			keyValue := strings.SplitN(word, "=", 2)
+			if len(keyValue) != 2 {
+				continue
+			}
+			switch keyValue[0] {
+			case "Bandwidth":
+				status.Bandwidth, _ = strconv.ParseUint(keyValue[1], 10, 64)
+			case "Measured":
+				status.Measured, _ = strconv.ParseUint(keyValue[1], 10, 64)
+			case "Unmeasured":
+				status.Unmeasured = keyValue[1] == "1"
+			}
+		}
+	case "p":
+		if len(words) == 3 {
+			status.Accept = words[1] == "accept"
+			status.PortList = words[2]
+		}
+	}
+}
+
+// parseStatusDocument parses the lines that all network status documents have
+// in common, and adds the router statuses to the given consensus.  The flags of
+// the document's known-flags line and of its relays' "s" lines go into the
+// given status flags.  Every document type has its own "r" line, which the
+// given parseRouter function parses.  All other lines, including the router
+// status lines that we parse here, are passed to the given parseItem function,
+// along with the router status they belong to, which is nil for header lines.
+// Document types use it to parse the lines that only they have.
+func parseStatusDocument(r io.Reader, consensus *tor.Consensus, flags *StatusFlags, parseRouter func([]string) (*tor.RouterStatus, error), parseItem func(*tor.RouterStatus, []string) error) error {
+
+	var status *tor.RouterStatus
+	var err error
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "valid-after", "fresh-until", "valid-until":
+			if len(words) != 3 {
+				return fmt.Errorf("Malformed \"%s\" line.", words[0])
+			}
+			t, err := time.Parse(statusTimeLayout, words[1]+" "+words[2])
+			if err != nil {
+				return err
+			}
+			switch words[0] {
+			case "valid-after":
+				consensus.ValidAfter = t
+			case "fresh-until":
+				consensus.FreshUntil = t
+			case "valid-until":
+				consensus.ValidUntil = t
+			}
+		case "r":
+			if status, err = parseRouter(words); err != nil {
+				return err
+			}
+			consensus.Set(status.Fingerprint, status)
+		case "known-flags":
+			flags.KnownFlags = words[1:]
+		case "directory-footer":
+			return nil
+		case "s":
+			if status != nil {
+				flags.Flags[status.Fingerprint] = words[1:]
+			}
+			fallthrough
+		case "a", "v", "w", "p":
+			if status != nil {
+				parseStatusItem(status, words)
+			}
+			fallthrough
+		default:
+			if err = parseItem(status, words); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ParseMicrodescConsensus parses a microdescriptor consensus.
+func ParseMicrodescConsensus(r io.Reader) (tor.ObjectSet, error) {
+
+	consensus := NewMicrodescConsensus()
+
+	err := parseStatusDocument(r, consensus.Consensus, &consensus.StatusFlags, parseMicrodescRouterLine, func(status *tor.RouterStatus, words []string) error {
+		if words[0] == "m" && status != nil && len(words) == 2 {
+			consensus.Microdescs[status.Fingerprint] = words[1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if consensus.ValidAfter.IsZero() {
+		return nil, fmt.Errorf("Microdescriptor consensus has no valid-after time.")
+	}
+
+	return consensus, nil
+}
+
+// MicrodescDigest returns the digest of the given raw microdescriptor, as it's
+// used in the "m" lines of microdescriptor consensuses.
+func MicrodescDigest(raw []byte) string {
+
+	digest := sha256.Sum256(raw)
+
+	return base64.RawStdEncoding.EncodeToString(digest[:])
+}
+
+// parseFamily turns the given "family" line entries into fingerprints.
+// Entries that are nicknames rather than fingerprints are ignored.
+func parseFamily(entries []string) map[tor.Fingerprint]bool {
+
+	family := make(map[tor.Fingerprint]bool)
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, "$") || len(entry) < 41 {
+			continue
+		}
+		family[tor.Fingerprint(strings.ToUpper(entry[1:41]))] = true
+	}
+
+	return family
+}
+
+// parseMicrodescriptor parses a single raw microdescriptor.
+func parseMicrodescriptor(raw []byte) (*Microdescriptor, error) {
+
+	desc := &Microdescriptor{
+		Digest: MicrodescDigest(raw),
+		Family: make(map[tor.Fingerprint]bool),
+	}
+
+	var key bytes.Buffer
+	inKey := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if inKey {
+			key.WriteString(line + "\n")
+			if strings.HasPrefix(line, "-----END") {
+				desc.OnionKey = key.String()
+				inKey = false
+			}
+			continue
+		}
+
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "onion-key":
+			inKey = true
+		case "ntor-onion-key":
+			if len(words) == 2 {
+				desc.NTorOnionKey = words[1]
+			}
+		case "family":
+			desc.Family = parseFamily(words[1:])
+		case "p":
+			if len(words) == 3 {
+				desc.Accept = words[1] == "accept"
+				desc.PortList = words[2]
+			}
+		case "id":
+			if len(words) == 3 && words[1] == "rsa1024" {
+				fpr, err := base64ToFingerprint(words[2])
+				if err != nil {
+					return nil, err
+				}
+				desc.Fingerprint = fpr
+			}
+		}
+	}
+
+	if desc.OnionKey == "" {
+		return nil, fmt.Errorf("Microdescriptor %s has no onion key.", desc.Digest)
+	}
+
+	return desc, scanner.Err()
+}
+
+// splitMicrodescs finds all microdescriptors in the given blurb, and calls the
+// given function with every raw microdescriptor.  Every microdescriptor starts
+// with an "onion-key" line.  Annotations are not part of a microdescriptor.
+func splitMicrodescs(blurb []byte, found func(raw []byte) error) error {
+
+	start := -1
+	offset := 0
+	for offset < len(blurb) {
+		end := bytes.IndexByte(blurb[offset:], '\n')
+		if end == -1 {
+			end = len(blurb)
+		} else {
+			end += offset + 1
+		}
+		line := blurb[offset:end]
+
+		if bytes.HasPrefix(line, []byte("onion-key")) || bytes.HasPrefix(line, []byte("@")) {
+			if start != -1 {
+				if err := found(blurb[start:offset]); err != nil {
+					return err
+				}
+				start = -1
+			}
+			if bytes.HasPrefix(line, []byte("onion-key")) {
+				start = offset
+			}
+		}
+		offset = end
+	}
+
+	if start != -1 {
+		return found(blurb[start:])
+	}
+
+	return nil
+}
+
+// ParseMicrodescriptors parses one or more microdescriptors.
+func ParseMicrodescriptors(r io.Reader) (tor.ObjectSet, error) {
+
+	blurb, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	descs := NewMicrodescriptors()
+	err = splitMicrodescs(blurb, func(raw []byte) error {
+		desc, err := parseMicrodescriptor(raw)
+		if err != nil {
+			return err
+		}
+		descs.Microdescriptors[desc.Digest] = desc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if descs.Length() == 0 {
+		return nil, fmt.Errorf("Found no microdescriptors.")
+	}
+
+	return descs, nil
+}
+
+// MicrodescStore maps microdescriptor digests to microdescriptors, so that we
+// can look up the microdescriptors that a microdescriptor consensus
+// references.  The store is loaded once, the first time a microdescriptor is
+// requested.  A MicrodescStore is safe for concurrent use.
+type MicrodescStore struct {
+	Dir string
+
+	buildOnce sync.Once
+	index     map[string]*Microdescriptor
+}
+
+// NewMicrodescStore returns a new microdescriptor store for the given
+// directory, which can contain files, directories, and tarballs of
+// microdescriptors.
+func NewMicrodescStore(dir string) *MicrodescStore {
+
+	return &MicrodescStore{
+		Dir:   dir,
+		index: make(map[string]*Microdescriptor),
+	}
+}
+
+// build walks the microdescriptor directory and loads all microdescriptors.
+func (store *MicrodescStore) build() {
+
+	log.Printf("Loading microdescriptors in \"%s\".\n", store.Dir)
+	start := time.Now()
+
+	callback := func(path string, info os.FileInfo, r io.Reader) error {
+		if info.IsDir() {
+			return nil
+		}
+		objects, err := ParseMicrodescriptors(r)
+		if err != nil {
+			log.Printf("Skipping %s: %s\n", path, err)
+			return nil
+		}
+		for digest, desc := range objects.(*Microdescriptors).Microdescriptors {
+			store.index[digest] = desc
+		}
+		return nil
+	}
+
+	err := walkPath(store.Dir, callback, func(path string, err error) error {
+		log.Printf("Could not load all microdescriptors: %s\n", err)
+		return nil
+	}, nil)
+	if err != nil {
+		log.Printf("Could not load all microdescriptors: %s\n", err)
+	}
+
+	log.Printf("Loaded %d microdescriptors after %s.\n", len(store.index), time.Since(start))
+}
+
+// Get returns the microdescriptor with the given digest.
+func (store *MicrodescStore) Get(digest string) (*Microdescriptor, error) {
+
+	if store == nil {
+		return nil, fmt.Errorf("No microdescriptor directory given.")
+	}
+
+	store.buildOnce.Do(store.build)
+
+	desc, exists := store.index[digest]
+	if !exists {
+		return nil, fmt.Errorf("Could not find microdescriptor with digest %s.", digest)
+	}
+
+	return desc, nil
+}
+
+// RouterDescriptors combines the router statuses of the given microdescriptor
+// consensus with the microdescriptors they reference, and returns them as
+// router descriptors.  These only contain what the two documents have in
+// common with server descriptors, i.e., no uptime, bandwidth, platform, or
+// contact information.  Relays whose microdescriptor we don't have are left
+// out.
+func (store *MicrodescStore) RouterDescriptors(consensus *MicrodescConsensus) *tor.RouterDescriptors {
+
+	descs := tor.NewRouterDescriptors()
+	missing := 0
+
+	for fpr, getStatus := range consensus.RouterStatuses {
+		microdesc, err := store.Get(consensus.Microdescs[fpr])
+		if err != nil {
+			missing++
+			continue
+		}
+
+		status := getStatus()
+		descs.Set(fpr, &tor.RouterDescriptor{
+			Nickname:     status.Nickname,
+			Fingerprint:  status.Fingerprint,
+			Address:      status.Address.IPv4Address,
+			ORPort:       status.Address.IPv4ORPort,
+			DirPort:      status.Address.IPv4DirPort,
+			Published:    status.Publication,
+			TorVersion:   status.TorVersion,
+			OnionKey:     microdesc.OnionKey,
+			NTorOnionKey: microdesc.NTorOnionKey,
+			Family:       microdesc.Family,
+		})
+	}
+
+	if missing > 0 {
+		log.Printf("Could not find microdescriptors of %d out of %d relays.\n", missing, consensus.Length())
+	}
+
+	return descs
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// Two microdescriptors in the format that CollecTor publishes.  The first one
+// carries its relay's fingerprint in an "id rsa1024" line.
+const microdescExcerpt = `@type microdescriptor 1.0
+onion-key
+-----BEGIN RSA PUBLIC KEY-----
+MIGJAoGBAMhPQtZPaxP3ukybV5LfofKQr20/ljpRk0e9IlGWWMSTkfVvBcHsa6IM
+H2KE6s4uuPHp7FqhakXAzJbODobnPHY8l1E4efyrqMQZXEQ6sM6C94SC1cdoDlTi
+GMsX8B+XMp8Cy1lc8+TDC8ng2tcY8xK/RL1fX6Q3cSMgw3sGzW3HAgMBAAE=
+-----END RSA PUBLIC KEY-----
+ntor-onion-key NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=
+family $0011BD2485AD45D984EC4159C88FC066E5E3300E $000A10D43011EA4928A35F610405F92B4433B4DC seele
+p accept 20-23,43,53,79-81
+id rsa1024 ABG9JIWtRdmE7EFZyI/AZuXjMA4
+id ed25519 Hm+HUYRMxJ1wbDvVm3ow1ee/NhqxCFYr7Du7u8pbcAo
+onion-key
+-----BEGIN RSA PUBLIC KEY-----
+MIGJAoGBAL1H2cuhn6ayYlqgF8NNaX94OCsLkCkIwbfh3Ky8rBk0VeFVCT1ig8uF
+Wnv9u0HIxa3xYuVC9l1T2LVQfXrzYAsRMv6ZdSu6TpD0aGrkM2XuRX5dXXnSn0vH
+2uWMnQN3XW3EJ+Jzc+QT8b8iuvwYpZl+YdLDYYoQ4jx2UF4UhLDxAgMBAAE=
+-----END RSA PUBLIC KEY-----
+ntor-onion-key 4Fq1D5qz/h/d5zEu+gtU7R1Wa7QWwI79j+TflBEV1Co=
+p reject 1-65535
+`
+
+// An excerpt of a microdescriptor consensus.  Unlike in a consensus, "r" lines
+// lack the descriptor digest, and "m" lines reference microdescriptors.
+const microdescConsensusExcerpt = `@type network-status-microdesc-consensus-3 1.0
+network-status-version 3 microdesc
+vote-status consensus
+consensus-method 28
+valid-after 2019-05-01 00:00:00
+fresh-until 2019-05-01 01:00:00
+valid-until 2019-05-01 03:00:00
+known-flags Authority BadExit Exit Fast Guard HSDir NoEdConsensus Running Stable StaleDesc V2Dir Valid
+r CalyxInstitute14 ABG9JIWtRdmE7EFZyI/AZuXjMA4 2019-04-30 18:01:16 162.247.74.201 443 80
+a [2620:18c:0:192::201]:443
+m JlzcRIy//VsPH1cOLKqk2KZNvaqdekF+tzKKvuoL1ng
+s Exit Fast Guard HSDir Running StaleDesc Stable V2Dir Valid
+v Tor 0.3.5.8
+w Bandwidth=9730
+directory-footer
+`
+
+func TestParseMicrodescriptors(t *testing.T) {
+
+	objects, err := ParseMicrodescriptors(strings.NewReader(microdescExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse microdescriptors: %s", err)
+	}
+	descs := objects.(*Microdescriptors)
+	if descs.Length() != 2 {
+		t.Fatalf("Expected 2 microdescriptors but got %d.", descs.Length())
+	}
+
+	// The digests are the base64-encoded SHA-256 digests over everything
+	// from "onion-key" up to the next microdescriptor, without the
+	// annotation.
+	desc, exists := descs.Microdescriptors["JlzcRIy//VsPH1cOLKqk2KZNvaqdekF+tzKKvuoL1ng"]
+	if !exists {
+		t.Fatalf("Could not find first microdescriptor among %v.", descs.Microdescriptors)
+	}
+	if desc.Fingerprint != "0011BD2485AD45D984EC4159C88FC066E5E3300E" {
+		t.Errorf("Got fingerprint %s.", desc.Fingerprint)
+	}
+	if desc.NTorOnionKey != "NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=" {
+		t.Errorf("Got ntor onion key %s.", desc.NTorOnionKey)
+	}
+	if !strings.HasPrefix(desc.OnionKey, "-----BEGIN RSA PUBLIC KEY-----\n") ||
+		!strings.HasSuffix(desc.OnionKey, "-----END RSA PUBLIC KEY-----\n") {
+		t.Errorf("Got onion key %q.", desc.OnionKey)
+	}
+	// Family members given by nickname are ignored.
+	if len(desc.Family) != 2 || !desc.Family["000A10D43011EA4928A35F610405F92B4433B4DC"] {
+		t.Errorf("Got family %v.", desc.Family)
+	}
+	if !desc.Accept || desc.PortList != "20-23,43,53,79-81" {
+		t.Errorf("Got policy %t %s.", desc.Accept, desc.PortList)
+	}
+
+	desc, exists = descs.Microdescriptors["uvA3t47DMMA+6ZSfvXG9e1RuCHE8E+gGgHqc58KevHk"]
+	if !exists {
+		t.Fatalf("Could not find second microdescriptor among %v.", descs.Microdescriptors)
+	}
+	if desc.Fingerprint != "" || desc.Accept {
+		t.Errorf("Got fingerprint %s and accept %t.", desc.Fingerprint, desc.Accept)
+	}
+}
+
+func TestMicrodescDigest(t *testing.T) {
+
+	tests := []struct {
+		raw    string
+		digest string
+	}{
+		{"", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"},
+		{"onion-key\n", "mwY2Pu47Jw7bFBGM8Ya20J/F+wtTgefqVn8JuhfzLxs"},
+	}
+
+	for _, test := range tests {
+		if digest := MicrodescDigest([]byte(test.raw)); digest != test.digest {
+			t.Errorf("Expected digest %s of %q but got %s.", test.digest, test.raw, digest)
+		}
+	}
+}
+
+func TestParseMicrodescriptorsErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"no microdescriptors", "@type microdescriptor 1.0\nntor-onion-key NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=\n"},
+		{"truncated onion key", "onion-key\n-----BEGIN RSA PUBLIC KEY-----\nMIGJAoGBAMhPQtZPaxP3\n"},
+		{"bad identity", "onion-key\n-----BEGIN RSA PUBLIC KEY-----\n-----END RSA PUBLIC KEY-----\nid rsa1024 !!!!\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseMicrodescriptors(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
+
+func TestParseMicrodescConsensus(t *testing.T) {
+
+	objects, err := ParseMicrodescConsensus(strings.NewReader(microdescConsensusExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse microdescriptor consensus: %s", err)
+	}
+	consensus := objects.(*MicrodescConsensus)
+
+	if !consensus.ValidAfter.Equal(time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Got valid-after time %s.", consensus.ValidAfter)
+	}
+
+	fpr := tor.Fingerprint("0011BD2485AD45D984EC4159C88FC066E5E3300E")
+	status, exists := consensus.Get(fpr)
+	if !exists {
+		t.Fatal("Could not find CalyxInstitute14.")
+	}
+	if status.Nickname != "CalyxInstitute14" || status.Address.IPv4Address.String() != "162.247.74.201" ||
+		status.Address.IPv4ORPort != 443 || status.Address.IPv4DirPort != 80 {
+		t.Errorf("Got router status %s at %v.", status.Nickname, status.Address)
+	}
+	if !status.Publication.Equal(time.Date(2019, 4, 30, 18, 1, 16, 0, time.UTC)) {
+		t.Errorf("Got publication time %s.", status.Publication)
+	}
+	if consensus.Microdescs[fpr] != "JlzcRIy//VsPH1cOLKqk2KZNvaqdekF+tzKKvuoL1ng" {
+		t.Errorf("Got microdescriptor digest %s.", consensus.Microdescs[fpr])
+	}
+	if !status.Flags.Exit || !status.Flags.Guard || status.Flags.BadExit {
+		t.Errorf("Got flags %+v.", status.Flags)
+	}
+	if status.Address.IPv6Address.String() != "2620:18c:0:192::201" || status.Address.IPv6ORPort != 443 {
+		t.Errorf("Got IPv6 address %s and port %d.", status.Address.IPv6Address, status.Address.IPv6ORPort)
+	}
+	if status.TorVersion != "0.3.5.8" || status.Bandwidth != 9730 {
+		t.Errorf("Got version %s and bandwidth %d.", status.TorVersion, status.Bandwidth)
+	}
+}
+
+func TestParseMicrodescConsensusErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"r line with digest", "valid-after 2019-05-01 00:00:00\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 9001 0\n"},
+		{"bad publication time", "valid-after 2019-05-01 00:00:00\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 2019-04-30 25:37:24 67.161.31.147 9001 0\n"},
+		{"bad DirPort", "valid-after 2019-05-01 00:00:00\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 2019-04-30 20:37:24 67.161.31.147 9001 x\n"},
+		{"malformed fresh-until", "valid-after 2019-05-01 00:00:00\nfresh-until 2019-05-01\n"},
+		{"no valid-after", "network-status-version 3 microdesc\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseMicrodescConsensus(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
@@ -65,7 +65,7 @@ func LinearSearch(objects tor.ObjectSet, params *CmdLineParams) (FingerprintMap,
 		return nil, fmt.Errorf("Could not find relay with fingerprint %s.", rootrelay)
 	}
 	targetStatus := targetRelay.(*tor.RouterStatus)
-	targetDesc, err := tor.LoadDescriptorFromDigest(params.DescriptorDir, targetStatus.Digest, targetStatus.Publication)
+	targetDesc, err := params.Descriptors.GetForStatus(targetStatus)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +76,7 @@ func LinearSearch(objects tor.ObjectSet, params *CmdLineParams) (FingerprintMap,
 		if status.Fingerprint == targetStatus.Fingerprint {
 			continue
 		}
-		desc, err := tor.LoadDescriptorFromDigest(params.DescriptorDir, status.Digest, status.Publication)
+		desc, err := params.Descriptors.GetForStatus(status)
 		if err != nil {
 			return nil, err
 		}
@@ -130,8 +130,8 @@ func VantagePointTreeSearch(objects tor.ObjectSet, params *CmdLineParams) (Finge
 	lvnst := func(stat1, stat2 interface{}) float64 {
 		status1 := stat1.(*tor.RouterStatus)
 		status2 := stat2.(*tor.RouterStatus)
-		desc1, _ := tor.LoadDescriptorFromDigest(params.DescriptorDir, status1.Digest, status1.Publication)
-		desc2, _ := tor.LoadDescriptorFromDigest(params.DescriptorDir, status2.Digest, status2.Publication)
+		desc1, _ := params.Descriptors.GetForStatus(status1)
+		desc2, _ := params.Descriptors.GetForStatus(status2)
 		return float64(Levenshtein(status1, status2, desc1, desc2))
 	}
 
@@ -146,12 +146,12 @@ func VantagePointTreeSearch(objects tor.ObjectSet, params *CmdLineParams) (Finge
 	log.Printf("Found relays after looking for %s.", time.Since(now))
 
 	targetStatus := targetRelay.(*tor.RouterStatus)
-	targetDesc, _ := tor.LoadDescriptorFromDigest(params.DescriptorDir, targetStatus.Digest, targetStatus.Publication)
+	targetDesc, _ := params.Descriptors.GetForStatus(targetStatus)
 	// We skip the most similar relay because it's targetRelay.
 	for i := 1; i < len(similarRelays); i++ {
 
 		similarRelay := similarRelays[i].(*tor.RouterStatus)
-		similarDesc, _ := tor.LoadDescriptorFromDigest(params.DescriptorDir, similarRelay.Digest, similarRelay.Publication)
+		similarDesc, _ := params.Descriptors.GetForStatus(similarRelay)
 
 		foundNeighbours[similarRelay.Fingerprint] = true
 
@@ -175,6 +175,10 @@ func FindNearestNeighbours(channel chan tor.ObjectSet, params *CmdLineParams, gr
 	defer group.Done()
 
 	for objects := range channel {
+		// We process every object set as it arrives.
+		if objects == EndOfBatch {
+			continue
+		}
 		if params.SearchAlg == "linear" {
 			if _, err := LinearSearch(objects, params); err != nil {
 				log.Fatal(err)
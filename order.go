@@ -0,0 +1,197 @@
+// Delivers parsed object sets to analysis functions in chronological order.
+
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// ObjectSetTime returns the time that determines the given object set's
+// position in a time series.  For consensuses, that's their valid-after time
+// and for router descriptors, it's the earliest publication time.  The zero
+// time is returned for object sets that carry no time.
+func ObjectSetTime(objects tor.ObjectSet) time.Time {
+
+	var earliest time.Time
+
+	switch v := objects.(type) {
+	case *Consensus:
+		return v.ValidAfter
+	case *MicrodescConsensus:
+		return v.ValidAfter
+	case *Vote:
+		return v.ValidAfter
+	case *ExitList:
+		return v.Downloaded
+	case *BandwidthFile:
+		return v.Timestamp
+	case *BridgeNetworkStatus:
+		return v.ValidAfter
+	case *BridgeDescriptors:
+		return ObjectSetTime(v.RouterDescriptors)
+	case *tor.RouterDescriptors:
+		for _, getDesc := range v.RouterDescriptors {
+			published := getDesc().Published
+			if earliest.IsZero() || published.Before(earliest) {
+				earliest = published
+			}
+		}
+	case *ExtraInfos:
+		for _, info := range v.ExtraInfos {
+			if earliest.IsZero() || info.Published.Before(earliest) {
+				earliest = info.Published
+			}
+		}
+	}
+
+	return earliest
+}
+
+// queuedObjectSet is an object set waiting in the reorder buffer.
+type queuedObjectSet struct {
+	objects tor.ObjectSet
+	time    time.Time
+	// Arrival sequence number.  It breaks ties between object sets with
+	// identical time, so that they keep their original order.
+	seq uint64
+}
+
+// before returns true if the queued object set goes before the given one.
+func (q *queuedObjectSet) before(other *queuedObjectSet) bool {
+	if q.time.Equal(other.time) {
+		return q.seq < other.seq
+	}
+	return q.time.Before(other.time)
+}
+
+// objectSetHeap implements heap.Interface as a min-heap over object set time.
+type objectSetHeap []*queuedObjectSet
+
+func (h objectSetHeap) Len() int {
+	return len(h)
+}
+
+func (h objectSetHeap) Less(i, j int) bool {
+	return h[i].before(h[j])
+}
+
+func (h objectSetHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *objectSetHeap) Push(x interface{}) {
+	*h = append(*h, x.(*queuedObjectSet))
+}
+
+func (h *objectSetHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// reorderWindow holds the buffered object sets of one document type.
+type reorderWindow struct {
+	queue       objectSetHeap
+	lastEmitted time.Time
+}
+
+// ReorderBuffer is a bounded buffer that sorts object sets by their time
+// before passing them on.  Walkers visit files in whatever order an archive
+// stores them, but time series analyses like churn and uptime need
+// chronological input.  Every document type is sorted in its own window of up
+// to Size object sets, because data paths are sorted by document type first,
+// e.g., a month of consensuses is followed by a month of server descriptors.
+// As long as no object set arrives more than Size positions too late within
+// its document type, the output of every document type is sorted.
+type ReorderBuffer struct {
+	Size int
+
+	windows map[string]*reorderWindow
+	seq     uint64
+	emit    func(tor.ObjectSet)
+}
+
+// NewReorderBuffer allocates and returns a new reorder buffer that holds up to
+// the given number of object sets per document type and passes them to the
+// given emit function.  A size of 0 disables reordering.
+func NewReorderBuffer(size int, emit func(tor.ObjectSet)) *ReorderBuffer {
+
+	if size < 0 {
+		size = 0
+	}
+
+	return &ReorderBuffer{
+		Size:    size,
+		windows: make(map[string]*reorderWindow),
+		emit:    emit,
+	}
+}
+
+// send passes the given object set on, unless it arrived too late to be put
+// into order with the other object sets of its document type.
+func (rb *ReorderBuffer) send(window *reorderWindow, queued *queuedObjectSet) {
+
+	if queued.time.Before(window.lastEmitted) {
+		log.Printf("Discarding %T from %s because it arrived after %s was processed.  "+
+			"Use -reorder to increase the reorder buffer size (currently %d).\n",
+			queued.objects, queued.time.Format(time.RFC3339), window.lastEmitted.Format(time.RFC3339), rb.Size)
+		return
+	}
+
+	window.lastEmitted = queued.time
+	rb.emit(queued.objects)
+}
+
+// Push adds the given object set to the window of its document type.  If the
+// window is full, its earliest object set is passed on.  Object sets without
+// time bypass the buffer.
+func (rb *ReorderBuffer) Push(objects tor.ObjectSet) {
+
+	t := ObjectSetTime(objects)
+	if t.IsZero() {
+		rb.emit(objects)
+		return
+	}
+
+	docType := fmt.Sprintf("%T", objects)
+	window, exists := rb.windows[docType]
+	if !exists {
+		window = new(reorderWindow)
+		rb.windows[docType] = window
+	}
+
+	rb.seq++
+	heap.Push(&window.queue, &queuedObjectSet{objects: objects, time: t, seq: rb.seq})
+
+	for window.queue.Len() > rb.Size {
+		rb.send(window, heap.Pop(&window.queue).(*queuedObjectSet))
+	}
+}
+
+// Flush passes on all buffered object sets.  Object sets of different
+// document types are interleaved in chronological order.
+func (rb *ReorderBuffer) Flush() {
+
+	for {
+		var earliest *reorderWindow
+		for _, window := range rb.windows {
+			if window.queue.Len() == 0 {
+				continue
+			}
+			if earliest == nil || window.queue[0].before(earliest.queue[0]) {
+				earliest = window
+			}
+		}
+		if earliest == nil {
+			return
+		}
+		rb.send(earliest, heap.Pop(&earliest.queue).(*queuedObjectSet))
+	}
+}
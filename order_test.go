@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// Base time for object sets in tests.
+var testEpoch = time.Date(2015, 8, 1, 0, 0, 0, 0, time.UTC)
+
+// consensusAt returns an empty consensus that became valid the given number of
+// hours after testEpoch.
+func consensusAt(hour int) *Consensus {
+
+	consensus := NewConsensus()
+	consensus.ValidAfter = testEpoch.Add(time.Duration(hour) * time.Hour)
+
+	return consensus
+}
+
+// descriptorsAt returns a set containing one router descriptor that was
+// published the given number of hours after testEpoch.
+func descriptorsAt(hour int) *tor.RouterDescriptors {
+
+	descs := tor.NewRouterDescriptors()
+	descs.Set("0000000000000000000000000000000000000000", &tor.RouterDescriptor{
+		Published: testEpoch.Add(time.Duration(hour) * time.Hour),
+	})
+
+	return descs
+}
+
+// collectHours returns a reorder buffer of the given size and a pointer to the
+// hours (relative to testEpoch) of the object sets that it emits.
+func collectHours(size int) (*ReorderBuffer, *[]int) {
+
+	hours := []int{}
+	buffer := NewReorderBuffer(size, func(objects tor.ObjectSet) {
+		hours = append(hours, int(ObjectSetTime(objects).Sub(testEpoch)/time.Hour))
+	})
+
+	return buffer, &hours
+}
+
+func TestReorderBufferSorts(t *testing.T) {
+
+	buffer, hours := collectHours(2)
+	for _, hour := range []int{1, 0, 3, 2, 4} {
+		buffer.Push(consensusAt(hour))
+	}
+	buffer.Flush()
+
+	expected := []int{0, 1, 2, 3, 4}
+	if len(*hours) != len(expected) {
+		t.Fatalf("Expected %d object sets but got %v.", len(expected), *hours)
+	}
+	for i := range expected {
+		if (*hours)[i] != expected[i] {
+			t.Fatalf("Expected %v but got %v.", expected, *hours)
+		}
+	}
+}
+
+func TestReorderBufferDiscardsLateSets(t *testing.T) {
+
+	buffer, hours := collectHours(1)
+	for _, hour := range []int{2, 3, 4, 0} {
+		buffer.Push(consensusAt(hour))
+	}
+	buffer.Flush()
+
+	for _, hour := range *hours {
+		if hour == 0 {
+			t.Errorf("Expected late consensus to be discarded, but got %v.", *hours)
+		}
+	}
+}
+
+// Data paths are sorted by document type, so a month of consensuses is
+// followed by a month of descriptors.  The descriptors must not be discarded
+// for being older than the last consensus.
+func TestReorderBufferMixedTypes(t *testing.T) {
+
+	var consensuses, descriptors int
+	buffer := NewReorderBuffer(2, func(objects tor.ObjectSet) {
+		switch objects.(type) {
+		case *Consensus:
+			consensuses++
+		case *tor.RouterDescriptors:
+			descriptors++
+		}
+	})
+
+	for hour := 0; hour < 24; hour++ {
+		buffer.Push(consensusAt(hour))
+	}
+	for hour := 0; hour < 24; hour++ {
+		buffer.Push(descriptorsAt(hour))
+	}
+	buffer.Flush()
+
+	if consensuses != 24 || descriptors != 24 {
+		t.Errorf("Expected 24 consensuses and 24 descriptor sets but got %d and %d.", consensuses, descriptors)
+	}
+}
+
+func TestReorderBufferFlushInterleaves(t *testing.T) {
+
+	var times []time.Time
+	buffer := NewReorderBuffer(10, func(objects tor.ObjectSet) {
+		times = append(times, ObjectSetTime(objects))
+	})
+
+	buffer.Push(consensusAt(0))
+	buffer.Push(consensusAt(2))
+	buffer.Push(descriptorsAt(1))
+	buffer.Push(descriptorsAt(3))
+	buffer.Flush()
+
+	if len(times) != 4 {
+		t.Fatalf("Expected 4 object sets but got %d.", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if times[i].Before(times[i-1]) {
+			t.Errorf("Flushed object sets are out of order: %s", times)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Parses documents, including the types that zoossh doesn't support.
+
+package main
+
+import (
+	"bytes"
+	"io"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// documentParsers maps the document types in @type annotations to the parsers
+// that we implement ourselves.  Everything else is parsed by zoossh.
+var documentParsers = map[string]func(io.Reader) (tor.ObjectSet, error){
+	"network-status-consensus-3":           ParseConsensus,
+	"network-status-microdesc-consensus-3": ParseMicrodescConsensus,
+	"microdescriptor":                      ParseMicrodescriptors,
+	"extra-info":                           ParseExtraInfos,
+	"network-status-vote-3":                ParseVote,
+	"tordnsel":                             ParseExitList,
+	"bridge-network-status":                ParseBridgeNetworkStatus,
+	"bridge-server-descriptor":             ParseBridgeDescriptors,
+	"bandwidth-file":                       ParseBandwidthFile,
+}
+
+// ParseDocument parses the given document, whose type is determined by its
+// @type annotation, and returns the resulting object set.
+func ParseDocument(blurb []byte) (tor.ObjectSet, error) {
+
+	if parse, exists := documentParsers[annotatedType(blurb)]; exists {
+		return parse(bytes.NewReader(blurb))
+	}
+
+	objects, err := tor.ParseUnknown(bytes.NewReader(blurb))
+	if err != nil {
+		return nil, err
+	}
+
+	// zoossh also recognises consensuses without @type annotation, e.g., the
+	// ones that directory authorities serve.  Its router flags lack the
+	// flags that it doesn't know, so we parse all consensuses ourselves.
+	if _, ok := objects.(*tor.Consensus); ok {
+		return ParseConsensus(bytes.NewReader(blurb))
+	}
+
+	return objects, nil
+}
@@ -14,9 +14,9 @@ var printedBanner bool = false
 
 // PrintInfo prints a router status.  If we also have access to router
 // descriptors, we print those too.
-func PrintInfo(descriptorDir string, status *tor.RouterStatus) {
+func PrintInfo(descs *DescriptorStore, status *tor.RouterStatus) {
 
-	desc, err := tor.LoadDescriptorFromDigest(descriptorDir, status.Digest, status.Publication)
+	desc, err := descs.GetForStatus(status)
 	if err == nil {
 		if !printedBanner {
 			fmt.Println("fingerprint,nickname,ip_addr,or_port,dir_port,flags,published,version,platform,bandwidthavg,bandwidthburst,uptime,familysize")
@@ -41,14 +41,20 @@ func PrettyPrint(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.
 
 	counter := 0
 	for objects := range channel {
+		// We process every object set as it arrives.
+		if objects == EndOfBatch {
+			continue
+		}
 		for object := range objects.Iterate(params.Filter) {
 			counter += 1
 
 			switch obj := object.(type) {
 			case *tor.RouterStatus:
-				PrintInfo(params.DescriptorDir, obj)
+				PrintInfo(params.Descriptors, obj)
 			case *tor.RouterDescriptor:
 				fmt.Println(obj)
+			case *Microdescriptor:
+				fmt.Println(obj)
 			}
 		}
 	}
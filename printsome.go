@@ -75,12 +75,16 @@ func PrintSome(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.Wa
 	counter := 0
 
 	for objects := range channel {
+		// We process every object set as it arrives.
+		if objects == EndOfBatch {
+			continue
+		}
 		for object := range objects.Iterate(params.Filter) {
 			switch obj := object.(type) {
 			case *tor.RouterStatus:
 				if _, exists := fprset[object.GetFingerprint()]; exists == true {
 					counter += 1
-					PrintInfo(params.DescriptorDir, obj)
+					PrintInfo(params.Descriptors, obj)
 				}
 			case *tor.RouterDescriptor:
 				if _, exists := fprset[object.GetFingerprint()]; exists == true {
@@ -0,0 +1,269 @@
+// Determines which flags the relays in network status documents have, and
+// evaluates named expressions over these flags.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// Names of flag expressions must be usable as CSV columns.
+var flagExprName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// StatusFlags holds the flags of a network status document's relays as
+// strings, so that it also covers flags that zoossh doesn't know about.
+type StatusFlags struct {
+	// KnownFlags holds the flags that the document's authorities vote on,
+	// as given in its "known-flags" line.  An authority that doesn't know
+	// a flag can't assign it.
+	KnownFlags []string
+
+	// Flags maps relay fingerprints to their flags, as given in the
+	// document's "s" lines.
+	Flags map[tor.Fingerprint][]string
+}
+
+// newStatusFlags returns empty status flags.
+func newStatusFlags() StatusFlags {
+
+	return StatusFlags{Flags: make(map[tor.Fingerprint][]string)}
+}
+
+// AsStatusFlags returns the status flags of the given object set, for
+// consensuses, microdescriptor consensuses, and bridge network statuses.  It
+// returns nil for other object sets.
+func AsStatusFlags(objects tor.ObjectSet) *StatusFlags {
+
+	switch v := objects.(type) {
+	case *Consensus:
+		return &v.StatusFlags
+	case *MicrodescConsensus:
+		return &v.StatusFlags
+	case *BridgeNetworkStatus:
+		return &v.StatusFlags
+	}
+
+	return nil
+}
+
+// merge merges the given status flags into ours.
+func (flags *StatusFlags) merge(other *StatusFlags) {
+
+	for _, flag := range other.KnownFlags {
+		if !flags.KnowsFlag(flag) {
+			flags.KnownFlags = append(flags.KnownFlags, flag)
+		}
+	}
+	for fpr, relayFlags := range other.Flags {
+		flags.Flags[fpr] = relayFlags
+	}
+}
+
+// Known returns the flags that the document knows.  Documents without a
+// known-flags line, e.g., bridge network statuses, know the flags that their
+// relays have.
+func (flags *StatusFlags) Known() []string {
+
+	if len(flags.KnownFlags) > 0 {
+		return flags.KnownFlags
+	}
+
+	seen := make(map[string]bool)
+	var known []string
+	for _, relayFlags := range flags.Flags {
+		for _, flag := range relayFlags {
+			if !seen[flag] {
+				seen[flag] = true
+				known = append(known, flag)
+			}
+		}
+	}
+	sort.Strings(known)
+
+	return known
+}
+
+// KnowsFlag returns true if the document knows the given flag.
+func (flags *StatusFlags) KnowsFlag(flag string) bool {
+
+	for _, known := range flags.Known() {
+		if known == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FlaggedConsensus is a consensus together with the flags of its relays.
+type FlaggedConsensus struct {
+	*tor.Consensus
+	Flags *StatusFlags
+}
+
+// AsFlaggedConsensus returns the router statuses and flags of the given object
+// set, for consensuses, microdescriptor consensuses, and bridge network
+// statuses.  It returns nil for other object sets.
+func AsFlaggedConsensus(objects tor.ObjectSet) *FlaggedConsensus {
+
+	consensus := AsConsensus(objects)
+	if consensus == nil {
+		return nil
+	}
+
+	return &FlaggedConsensus{consensus, AsStatusFlags(objects)}
+}
+
+// flagLiteral is a flag in a flag expression, which may be negated.
+type flagLiteral struct {
+	Flag    string
+	Negated bool
+}
+
+// FlagExpr is a named expression over relay flags.  Flags joined by "+" must
+// all be set, flags prefixed with "!" must not be set, and alternatives are
+// separated by "|".  For example, "Guard+Exit" matches relays with both the
+// Guard and the Exit flag, "HSDir+!Guard" matches relays with the HSDir but
+// without the Guard flag, and "BadExit|Exit+!Guard" matches relays that are
+// either bad exits, or exits without the Guard flag.
+type FlagExpr struct {
+	Name         string
+	Alternatives [][]flagLiteral
+}
+
+// flagExprFor returns an expression that matches relays with the given flag.
+func flagExprFor(flag string) *FlagExpr {
+
+	return &FlagExpr{Name: flag, Alternatives: [][]flagLiteral{{{Flag: flag}}}}
+}
+
+// ParseFlagExpr parses the given flag expression and gives it the given name.
+func ParseFlagExpr(name, expr string) (*FlagExpr, error) {
+
+	if !flagExprName.MatchString(name) {
+		return nil, fmt.Errorf("Flag expression name \"%s\" must start with a letter, followed by letters, digits, \"_\", or \"-\".", name)
+	}
+
+	flagExpr := &FlagExpr{Name: name}
+	for _, alternative := range strings.Split(expr, "|") {
+		var literals []flagLiteral
+		for _, flag := range strings.Split(alternative, "+") {
+			literal := flagLiteral{Flag: strings.TrimSpace(flag)}
+			if strings.HasPrefix(literal.Flag, "!") {
+				literal.Flag = strings.TrimSpace(literal.Flag[1:])
+				literal.Negated = true
+			}
+			if literal.Flag == "" {
+				return nil, fmt.Errorf("Flag expression \"%s\" contains an empty flag.", expr)
+			}
+			literals = append(literals, literal)
+		}
+		flagExpr.Alternatives = append(flagExpr.Alternatives, literals)
+	}
+
+	return flagExpr, nil
+}
+
+// ParseFlagExprs parses the given comma-separated list of named flag
+// expressions, e.g., "GuardExit=Guard+Exit,HSDirOnly=HSDir+!Guard".
+func ParseFlagExprs(exprs string) ([]*FlagExpr, error) {
+
+	var flagExprs []*FlagExpr
+	names := make(map[string]bool)
+
+	for _, namedExpr := range strings.Split(exprs, ",") {
+		nameExpr := strings.SplitN(namedExpr, "=", 2)
+		if len(nameExpr) != 2 {
+			return nil, fmt.Errorf("Flag expression \"%s\" must have the form name=expression.", namedExpr)
+		}
+		name := strings.TrimSpace(nameExpr[0])
+		if names[name] {
+			return nil, fmt.Errorf("Flag expression name \"%s\" is used more than once.", name)
+		}
+		names[name] = true
+
+		flagExpr, err := ParseFlagExpr(name, nameExpr[1])
+		if err != nil {
+			return nil, err
+		}
+		flagExprs = append(flagExprs, flagExpr)
+	}
+
+	return flagExprs, nil
+}
+
+// Flags returns the flags that the expression references.
+func (expr *FlagExpr) Flags() []string {
+
+	seen := make(map[string]bool)
+	var flags []string
+	for _, alternative := range expr.Alternatives {
+		for _, literal := range alternative {
+			if !seen[literal.Flag] {
+				seen[literal.Flag] = true
+				flags = append(flags, literal.Flag)
+			}
+		}
+	}
+
+	return flags
+}
+
+// Computable returns true if the given status flags know all flags that the
+// expression references.  Otherwise, we can't tell if a relay lacks a flag
+// or if its authorities didn't vote on the flag.
+func (expr *FlagExpr) Computable(flags *StatusFlags) bool {
+
+	for _, flag := range expr.Flags() {
+		if !flags.KnowsFlag(flag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Matches returns true if the given flags of a relay satisfy the expression.
+func (expr *FlagExpr) Matches(relayFlags []string) bool {
+
+	has := make(map[string]bool)
+	for _, flag := range relayFlags {
+		has[flag] = true
+	}
+
+	for _, alternative := range expr.Alternatives {
+		matches := true
+		for _, literal := range alternative {
+			if has[literal.Flag] == literal.Negated {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterConsensusByExpr filters the given consensus so that only relays whose
+// flags satisfy the given expression remain.  The resulting consensus is
+// returned.
+func FilterConsensusByExpr(consensus *FlaggedConsensus, expr *FlagExpr) *tor.Consensus {
+
+	filteredConsensus := tor.NewConsensus()
+
+	for fingerprint, getStatus := range consensus.RouterStatuses {
+		if expr.Matches(consensus.Flags.Flags[fingerprint]) {
+			filteredConsensus.Set(fingerprint, getStatus())
+		}
+	}
+
+	return filteredConsensus
+}
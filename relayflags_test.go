@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+func TestParseFlagExprs(t *testing.T) {
+
+	exprs, err := ParseFlagExprs("GuardExit=Guard+Exit,HSDirOnly=HSDir+!Guard,Odd=BadExit|Exit+!Guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exprs) != 3 || exprs[0].Name != "GuardExit" || exprs[2].Name != "Odd" {
+		t.Fatalf("Got expressions %+v.", exprs)
+	}
+	if flags := exprs[2].Flags(); !reflect.DeepEqual(flags, []string{"BadExit", "Exit", "Guard"}) {
+		t.Errorf("Expected flags BadExit, Exit, and Guard but got %v.", flags)
+	}
+
+	for _, invalid := range []string{"Guard+Exit", "1st=Guard", "A=Guard+", "A=!", "A=Guard,A=Exit"} {
+		if _, err := ParseFlagExprs(invalid); err == nil {
+			t.Errorf("Expected error for \"%s\".", invalid)
+		}
+	}
+}
+
+func TestFlagExprMatches(t *testing.T) {
+
+	expr, err := ParseFlagExpr("Odd", "BadExit|Exit+!Guard")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flags    []string
+		expected bool
+	}{
+		{[]string{"BadExit", "Guard"}, true},
+		{[]string{"Exit", "Running"}, true},
+		{[]string{"Exit", "Guard"}, false},
+		{[]string{"Running"}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if got := expr.Matches(test.flags); got != test.expected {
+			t.Errorf("Expected %t for flags %v but got %t.", test.expected, test.flags, got)
+		}
+	}
+}
+
+func TestStatusFlagsKnown(t *testing.T) {
+
+	flags := newStatusFlags()
+	flags.Flags["AAAA"] = []string{"Running", "Guard"}
+	flags.Flags["BBBB"] = []string{"Running", "Fast"}
+
+	// Without a known-flags line, the relays' flags are known.
+	if known := flags.Known(); !reflect.DeepEqual(known, []string{"Fast", "Guard", "Running"}) {
+		t.Errorf("Expected known flags Fast, Guard, and Running but got %v.", known)
+	}
+
+	flags.KnownFlags = []string{"Fast", "Guard", "Running", "StaleDesc"}
+	if !flags.KnowsFlag("StaleDesc") || flags.KnowsFlag("MiddleOnly") {
+		t.Errorf("Expected StaleDesc but not MiddleOnly to be known: %v", flags.KnownFlags)
+	}
+
+	expr, _ := ParseFlagExpr("Stale", "StaleDesc+!Guard")
+	if !expr.Computable(&flags) {
+		t.Error("Expected expression over known flags to be computable.")
+	}
+	if expr, _ = ParseFlagExpr("Middle", "MiddleOnly"); expr.Computable(&flags) {
+		t.Error("Expected expression over unknown flag not to be computable.")
+	}
+}
+
+func TestFilterConsensusByExpr(t *testing.T) {
+
+	consensus := runningConsensusAt(0, "AAAA", "BBBB")
+	consensus.Flags["AAAA"] = []string{"Guard", "Running"}
+
+	expr, _ := ParseFlagExpr("NoGuard", "Running+!Guard")
+	filtered := FilterConsensusByExpr(AsFlaggedConsensus(consensus), expr)
+	if _, exists := filtered.Get(tor.Fingerprint("BBBB")); filtered.Length() != 1 || !exists {
+		t.Errorf("Expected only BBBB to remain but got %v.", filtered.ToSlice())
+	}
+}
@@ -0,0 +1,214 @@
+// Keeps track of what we ingested and what we had to skip.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+const ingestionReportFile = "ingestion-report.json"
+
+// SkippedFile represents a file that we could not ingest.
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Reason string `json:"reason"`
+}
+
+// IngestionReport summarises a run's input data: how many documents of each
+// type were parsed, and which files were skipped and why.  An IngestionReport
+// is safe for concurrent use.
+type IngestionReport struct {
+	Parsed     map[string]int `json:"parsed"`
+	OutOfRange int            `json:"out_of_range"`
+	Skipped    []SkippedFile  `json:"skipped"`
+
+	// Strict makes the report remember the first error, which then aborts
+	// the run.
+	Strict bool `json:"-"`
+
+	mutex    sync.Mutex
+	firstErr error
+}
+
+// NewIngestionReport allocates and returns a new ingestion report.
+func NewIngestionReport(strict bool) *IngestionReport {
+
+	return &IngestionReport{
+		Parsed:  make(map[string]int),
+		Skipped: make([]SkippedFile, 0),
+		Strict:  strict,
+	}
+}
+
+// DocumentType returns a human-readable name of the document type of the
+// given object set.
+func DocumentType(objects tor.ObjectSet) string {
+
+	switch objects.(type) {
+	case *Consensus:
+		return "network-status-consensus"
+	case *tor.RouterDescriptors:
+		return "server-descriptor"
+	case *MicrodescConsensus:
+		return "network-status-microdesc-consensus-3"
+	case *Microdescriptors:
+		return "microdescriptor"
+	case *ExtraInfos:
+		return "extra-info"
+	case *Vote:
+		return "network-status-vote-3"
+	case *ExitList:
+		return "tordnsel"
+	case *BandwidthFile:
+		return "bandwidth-file"
+	case *BridgeNetworkStatus:
+		return "bridge-network-status"
+	case *BridgeDescriptors:
+		return "bridge-server-descriptor"
+	default:
+		return fmt.Sprintf("%T", objects)
+	}
+}
+
+// AddParsed records the given successfully parsed object set.
+func (report *IngestionReport) AddParsed(objects tor.ObjectSet) {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	report.Parsed[DocumentType(objects)]++
+}
+
+// AddOutOfRange records a file that was skipped because it's not in the
+// desired date range.
+func (report *IngestionReport) AddOutOfRange() {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	report.OutOfRange++
+}
+
+// AddSkipped records a file that we could not ingest because of the given
+// error.  In strict mode, the first error is remembered.
+func (report *IngestionReport) AddSkipped(path string, offset int64, err error) {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	if walkErr, ok := err.(*WalkError); ok {
+		path, offset, err = walkErr.Path, walkErr.Offset, walkErr.Err
+	}
+
+	report.Skipped = append(report.Skipped, SkippedFile{path, offset, err.Error()})
+	log.Printf("Skipping %s (at byte offset %d): %s\n", path, offset, err)
+
+	report.abort(path, offset, err)
+}
+
+// abort remembers the given error in strict mode, unless we already have one.
+// The caller must hold the report's mutex.
+func (report *IngestionReport) abort(path string, offset int64, err error) {
+
+	if report.Strict && report.firstErr == nil {
+		report.firstErr = fmt.Errorf("Aborting because of -strict: %s (at byte offset %d): %s", path, offset, err)
+	}
+}
+
+// Err returns the error that aborts the run in strict mode, or nil.
+func (report *IngestionReport) Err() error {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	return report.firstErr
+}
+
+// OnParseError is passed to parser pools.  In strict mode, it aborts the run as
+// soon as a file fails to parse, so that we stop walking files right away.
+// The file is recorded as skipped once its turn comes in the pool's sink.
+func (report *IngestionReport) OnParseError(path string, info os.FileInfo, err error) {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	report.abort(path, FileOffset(info), err)
+}
+
+// OnWalkError is passed to walkArchiveData.  It records the given error of the
+// file at the given path and decides whether the walk should continue.
+func (report *IngestionReport) OnWalkError(path string, err error) error {
+
+	// The walk is already being aborted, so there's nothing new to record.
+	if abortErr := report.Err(); abortErr != nil {
+		return abortErr
+	}
+
+	// Being interrupted isn't the file's fault.
+	if walkErr, ok := err.(*WalkError); ok && walkErr.Err == errInterrupted {
+		return errInterrupted
+	}
+
+	report.AddSkipped(path, 0, err)
+
+	return report.Err()
+}
+
+// Log writes a summary of the report to the log.
+func (report *IngestionReport) Log() {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	for docType, count := range report.Parsed {
+		log.Printf("Parsed %d file(s) of type %s.\n", count, docType)
+	}
+	if report.OutOfRange > 0 {
+		log.Printf("Skipped %d file(s) outside of the desired date range.\n", report.OutOfRange)
+	}
+	if len(report.Skipped) > 0 {
+		log.Printf("Skipped %d file(s) because of errors.  Use -report to get a list.\n", len(report.Skipped))
+	}
+}
+
+// WriteJSON writes the report as JSON to the output directory.
+func (report *IngestionReport) WriteJSON() error {
+
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	fd, err := createOutputFile(ingestionReportFile)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	encoder := json.NewEncoder(fd)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	log.Printf("Wrote ingestion report to \"%s\".\n", fd.Name())
+
+	return nil
+}
+
+// writeReport logs the given report and, if requested, writes it to the
+// output directory.
+func writeReport(report *IngestionReport, params *CmdLineParams) {
+
+	report.Log()
+
+	if params.WriteReport {
+		if err := report.WriteJSON(); err != nil {
+			log.Printf("Could not write ingestion report: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// walkTestFiles creates a truncated gzip file and a plain file, walks over
+// them using the given report, and returns the names of the files that were
+// passed to the callback, and the walk's error.
+func walkTestFiles(t *testing.T, report *IngestionReport) ([]string, error) {
+
+	dir, err := ioutil.TempDir("", "sybilhunter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "a-broken.gz"), []byte{0x1f, 0x8b, 0x08}, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b-plain"), []byte("foo\n"), 0600)
+
+	var names []string
+	err = walkArchiveData([]string{dir}, func(path string, info os.FileInfo, r io.Reader) error {
+		if !info.IsDir() {
+			names = append(names, filepath.Base(path))
+		}
+		return nil
+	}, report.OnWalkError, nil)
+
+	return names, err
+}
+
+func TestIngestionReportSkipsBrokenFiles(t *testing.T) {
+
+	report := NewIngestionReport(false)
+	names, err := walkTestFiles(t, report)
+	if err != nil {
+		t.Fatalf("Expected walk to continue but got: %s", err)
+	}
+
+	if len(names) != 1 || names[0] != "b-plain" {
+		t.Errorf("Expected to walk b-plain but walked %s.", names)
+	}
+	if len(report.Skipped) != 1 || filepath.Base(report.Skipped[0].Path) != "a-broken.gz" {
+		t.Errorf("Expected a-broken.gz to be skipped but got %+v.", report.Skipped)
+	}
+}
+
+func TestIngestionReportStrict(t *testing.T) {
+
+	report := NewIngestionReport(true)
+	names, err := walkTestFiles(t, report)
+	if err == nil {
+		t.Fatal("Expected strict walk to abort.")
+	}
+	if len(names) != 0 {
+		t.Errorf("Expected strict walk to stop at the broken file but walked %s.", names)
+	}
+
+	// Parse errors abort strict runs as soon as they happen.
+	report = NewIngestionReport(true)
+	report.OnParseError("consensus", &testFileInfo{"consensus", 3, testEpoch}, io.ErrUnexpectedEOF)
+	if report.Err() == nil {
+		t.Error("Expected parse error to abort strict run.")
+	}
+}
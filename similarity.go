@@ -50,6 +50,11 @@ type DescriptorSimilarity struct {
 	HaveDirPort  bool
 	SamePolicy   bool
 	SamePlatform bool
+	SameNTorKey  bool
+
+	// FromMicrodescs is set if both descriptors were derived from
+	// microdescriptors, which lack uptime, bandwidth, and platform.
+	FromMicrodescs bool
 
 	StringSummary string
 }
@@ -58,7 +63,7 @@ type DescriptorSimilarity struct {
 // representation of the similarity between two relay descriptors.
 func (s *DescriptorSimilarity) genStringSimilarity() {
 
-	var contact, version, bandwidth, sharedFpr, family, policy, uptime, orport, platform string
+	var contact, version, bandwidth, sharedFpr, family, policy, uptime, orport, platform, ntorKey string
 	var similarities int
 
 	if s.SameFamily {
@@ -80,7 +85,17 @@ func (s *DescriptorSimilarity) genStringSimilarity() {
 		version = fmt.Sprintf("Same version: %s\n", s.desc1.TorVersion)
 	}
 
-	if s.BandwidthDiff == 0 {
+	if s.SameNTorKey {
+		similarities++
+		ntorKey = fmt.Sprintf("Same ntor onion key: %s\n", s.desc1.NTorOnionKey)
+	}
+
+	// Descriptors that we derived from microdescriptors lack bandwidth and
+	// uptime, so we only compare them if they are known.
+	knownBandwidth := !s.FromMicrodescs || s.desc1.BandwidthAvg != 0
+	knownUptime := !s.FromMicrodescs || s.desc1.Uptime != 0 || s.desc2.Uptime != 0
+
+	if s.BandwidthDiff == 0 && knownBandwidth {
 		similarities++
 		// The default bandwidth rate is 1 GiB/s, i.e., 1024^3 Bps.
 		if s.desc1.BandwidthAvg == 1073741824 {
@@ -101,7 +116,7 @@ func (s *DescriptorSimilarity) genStringSimilarity() {
 		policy = fmt.Sprintf("Same exit policy: %s\n", s.desc1.RawReject)
 	}
 
-	if s.UptimeDiff < (60 * 60 * 3) {
+	if s.UptimeDiff < (60*60*3) && knownUptime {
 		similarities++
 		uptime = fmt.Sprintf("Uptime diff: %d sec\n", s.UptimeDiff)
 	}
@@ -114,8 +129,9 @@ func (s *DescriptorSimilarity) genStringSimilarity() {
 
 	s.SimilarityScore = float64(similarities)
 	s.StringSummary = fmt.Sprintf("%d similarities%s:\n"+
-		"%s%s%s%s%s%s%s%s",
+		"%s%s%s%s%s%s%s%s%s",
 		similarities, family,
+		ntorKey,
 		sharedFpr,
 		contact,
 		version,
@@ -137,10 +153,28 @@ func (s *DescriptorSimilarity) String() string {
 // descriptors.  The similarity is a vector of numbers, which is returned.
 func CalcDescSimilarity(desc1, desc2 *tor.RouterDescriptor) *DescriptorSimilarity {
 
+	return calcSimilarity(desc1, desc2, false)
+}
+
+// CalcMicrodescSimilarity determines the similarity between the two given
+// relay descriptors, which were derived from microdescriptors.  Unlike
+// CalcDescSimilarity, it compares ntor onion keys, and ignores the uptime,
+// bandwidth, and platform that such descriptors lack.
+func CalcMicrodescSimilarity(desc1, desc2 *tor.RouterDescriptor) *DescriptorSimilarity {
+
+	return calcSimilarity(desc1, desc2, true)
+}
+
+// calcSimilarity determines the similarity between the two given relay
+// descriptors, which were derived from microdescriptors if fromMicrodescs is
+// set.
+func calcSimilarity(desc1, desc2 *tor.RouterDescriptor, fromMicrodescs bool) *DescriptorSimilarity {
+
 	similarity := new(DescriptorSimilarity)
 
 	similarity.desc1 = desc1
 	similarity.desc2 = desc2
+	similarity.FromMicrodescs = fromMicrodescs
 
 	similarity.UptimeDiff = MaxUInt64(desc1.Uptime, desc2.Uptime) -
 		MinUInt64(desc1.Uptime, desc2.Uptime)
@@ -174,6 +208,11 @@ func CalcDescSimilarity(desc1, desc2 *tor.RouterDescriptor) *DescriptorSimilarit
 	similarity.HaveDirPort = (desc1.DirPort != 0) && (desc2.DirPort != 0)
 	similarity.SamePlatform = desc1.OperatingSystem == desc2.OperatingSystem
 
+	if fromMicrodescs {
+		similarity.SamePlatform = similarity.SamePlatform && desc1.OperatingSystem != ""
+		similarity.SameNTorKey = (desc1.NTorOnionKey == desc2.NTorOnionKey) && desc1.NTorOnionKey != ""
+	}
+
 	// We don't care about the default or the universal reject policy.
 	if !hasDefaultExitPolicy(desc1) && strings.TrimSpace(desc1.RawReject) != "*:*" {
 		similarity.SamePolicy = desc1.RawReject == desc2.RawReject
@@ -185,10 +224,11 @@ func CalcDescSimilarity(desc1, desc2 *tor.RouterDescriptor) *DescriptorSimilarit
 }
 
 // genSimilarityMatrix computes pairwise similarities for all given relay
-// descriptors.  If "visualise" is set to false, all (n^2)/2 similarities are
+// descriptors, which were derived from microdescriptors if "microdescs" is
+// set.  If "visualise" is set to false, all (n^2)/2 similarities are
 // written to stdout in human-readable output.  If "visualise" is true, the
 // output is Dot code, that can be turned into a diagram for visual inspection.
-func genSimilarityMatrix(descs *tor.RouterDescriptors, params *CmdLineParams) {
+func genSimilarityMatrix(descs *tor.RouterDescriptors, microdescs bool, params *CmdLineParams) {
 
 	// Turn the map keys (i.e., the relays' fingerprints) into a list.
 	size := len(descs.RouterDescriptors)
@@ -216,7 +256,12 @@ func genSimilarityMatrix(descs *tor.RouterDescriptors, params *CmdLineParams) {
 			desc1, _ := descs.Get(fpr1)
 			desc2, _ := descs.Get(fpr2)
 
-			similarity := CalcDescSimilarity(desc1, desc2)
+			var similarity *DescriptorSimilarity
+			if microdescs {
+				similarity = CalcMicrodescSimilarity(desc1, desc2)
+			} else {
+				similarity = CalcDescSimilarity(desc1, desc2)
+			}
 			if similarity.SimilarityScore < params.Threshold {
 				continue
 			}
@@ -248,17 +293,29 @@ func genSimilarityMatrix(descs *tor.RouterDescriptors, params *CmdLineParams) {
 }
 
 // SimilarityMatrix walks the given file or directory and computes pairwise
-// relay similarities.  If the cumulative argument is set to true, the content
-// of all files is accumulated rather than analysed independently.
+// relay similarities.  For microdescriptor consensuses, the relays'
+// microdescriptors are looked up in the -microdescdir directory.  If the
+// cumulative argument is set to true, the content of all files is accumulated
+// rather than analysed independently.
 func SimilarityMatrix(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
 
 	defer group.Done()
 
 	for objects := range channel {
+		// We process every object set as it arrives.
+		if objects == EndOfBatch {
+			continue
+		}
+
 		switch v := objects.(type) {
 		case *tor.RouterDescriptors:
-			genSimilarityMatrix(v, params)
-		case *tor.Consensus:
+			genSimilarityMatrix(v, false, params)
+		case *MicrodescConsensus:
+			if params.Microdescs == nil {
+				log.Fatalln("Analysing microdescriptor consensuses requires -microdescdir.")
+			}
+			genSimilarityMatrix(params.Microdescs.RouterDescriptors(v), true, params)
+		case *Consensus:
 			log.Fatalf("Couldn't analyse \"%s\" because consensus file format not yet supported.\n", params.InputData)
 		}
 	}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// microdescDescriptor returns a router descriptor like the ones that we derive
+// from microdescriptors, i.e., without uptime, bandwidth, and platform.
+func microdescDescriptor(fpr tor.Fingerprint, ntorKey string) *tor.RouterDescriptor {
+
+	return &tor.RouterDescriptor{
+		Nickname:     "seele",
+		Fingerprint:  fpr,
+		Address:      net.ParseIP("67.161.31.147"),
+		ORPort:       9001,
+		TorVersion:   "0.3.5.8",
+		NTorOnionKey: ntorKey,
+	}
+}
+
+func TestCalcMicrodescSimilarity(t *testing.T) {
+
+	desc1 := microdescDescriptor("000A10D43011EA4928A35F610405F92B4433B4DC", "NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=")
+	desc2 := microdescDescriptor("0011BD2485AD45D984EC4159C88FC066E5E3300E", "NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=")
+
+	similarity := CalcMicrodescSimilarity(desc1, desc2)
+	if !similarity.SameNTorKey {
+		t.Error("Expected shared ntor onion key to count as similarity.")
+	}
+	if similarity.SamePlatform {
+		t.Error("Expected missing platforms not to count as similarity.")
+	}
+	// Same ntor onion key, fingerprint prefix, version, and exit policy.
+	if similarity.SimilarityScore != 4 {
+		t.Errorf("Expected score 4 but got %.0f:\n%s", similarity.SimilarityScore, similarity)
+	}
+}
+
+func TestCalcDescSimilarity(t *testing.T) {
+
+	desc1 := microdescDescriptor("000A10D43011EA4928A35F610405F92B4433B4DC", "NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=")
+	desc2 := microdescDescriptor("0011BD2485AD45D984EC4159C88FC066E5E3300E", "NZsdvm/FHTqaDr6nGlRMxo/pf4l84sB9aWWZ3PN2QBI=")
+
+	// Server descriptors are scored just like before microdescriptor
+	// support, i.e., ntor onion keys are ignored, and zero bandwidth and
+	// uptime count as similar.
+	similarity := CalcDescSimilarity(desc1, desc2)
+	if similarity.SameNTorKey || similarity.FromMicrodescs {
+		t.Error("Expected server descriptors to ignore ntor onion keys.")
+	}
+	if !similarity.SamePlatform {
+		t.Error("Expected identical platforms to count as similarity.")
+	}
+	// Same fingerprint prefix, version, exit policy, uptime, bandwidth, and
+	// platform.
+	if similarity.SimilarityScore != 6 {
+		t.Errorf("Expected score 6 but got %.0f:\n%s", similarity.SimilarityScore, similarity)
+	}
+}
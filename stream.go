@@ -0,0 +1,159 @@
+// Reads concatenated documents from stdin and from named pipes.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// stdinPath is the -data argument that makes us read from stdin.
+const stdinPath = "-"
+
+// Every document in CollecTor's format starts with a line like this.
+var typeAnnotation = []byte("@type ")
+
+// streamDocumentInfo implements os.FileInfo for a document that we split off
+// a stream.  Such documents have no modification time, and they are never
+// cached because their name doesn't identify their content.
+type streamDocumentInfo struct {
+	name   string
+	size   int64
+	offset int64
+}
+
+// Name implements the os.FileInfo interface.
+func (info *streamDocumentInfo) Name() string {
+
+	return info.name
+}
+
+// Size implements the os.FileInfo interface.
+func (info *streamDocumentInfo) Size() int64 {
+
+	return info.size
+}
+
+// Mode implements the os.FileInfo interface.
+func (info *streamDocumentInfo) Mode() os.FileMode {
+
+	return 0444
+}
+
+// ModTime implements the os.FileInfo interface.
+func (info *streamDocumentInfo) ModTime() time.Time {
+
+	return time.Time{}
+}
+
+// IsDir implements the os.FileInfo interface.
+func (info *streamDocumentInfo) IsDir() bool {
+
+	return false
+}
+
+// Sys implements the os.FileInfo interface.
+func (info *streamDocumentInfo) Sys() interface{} {
+
+	return nil
+}
+
+// IsStreamDocument returns true if the given FileInfo belongs to a document
+// that we split off a stream.
+func IsStreamDocument(info os.FileInfo) bool {
+
+	_, ok := info.(*streamDocumentInfo)
+	return ok
+}
+
+// splitDocuments splits the given stream of concatenated, @type-annotated
+// documents and passes every document to callback.  Documents are named after
+// the stream and their position in it, e.g., "<stdin>#3".
+func splitDocuments(name string, r io.Reader, callback func(string, os.FileInfo, io.Reader) error) error {
+
+	br := bufio.NewReader(r)
+	var doc bytes.Buffer
+	var offset, docOffset int64
+	count := 0
+
+	emit := func() error {
+		if doc.Len() == 0 {
+			return nil
+		}
+		count++
+		info := &streamDocumentInfo{fmt.Sprintf("%s#%d", name, count), int64(doc.Len()), docOffset}
+		// The callback keeps a reference to the content, so we hand out a
+		// copy rather than our buffer.
+		blurb := make([]byte, doc.Len())
+		copy(blurb, doc.Bytes())
+		doc.Reset()
+		return callback(info.name, info, bytes.NewReader(blurb))
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if bytes.HasPrefix(line, typeAnnotation) {
+			if err := emit(); err != nil {
+				return err
+			}
+			docOffset = offset
+		}
+		doc.Write(line)
+		offset += int64(len(line))
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return emit()
+}
+
+// walkStream walks over the content of the given stream, which we can only read
+// once and sequentially.  Archives and compressed streams are handled like
+// files, and plain content is split into its documents.
+func walkStream(name string, r io.Reader, callback func(string, os.FileInfo, io.Reader) error) error {
+
+	br := bufio.NewReader(r)
+
+	switch format := DetectFormat(br); format {
+	case FormatTar:
+		return walkTar(br, callback)
+	case FormatZip:
+		return walkZipStream(br, callback)
+	case FormatXZ, FormatGzip, FormatBzip2:
+		dr, err := decompress(br, format)
+		if err != nil {
+			return err
+		}
+		if err = walkStream(name, dr, callback); err != nil {
+			return err
+		}
+		_, err = io.Copy(ioutil.Discard, dr)
+		return err
+	default:
+		return splitDocuments(name, br, callback)
+	}
+}
+
+// walkStdin walks over the documents that we read from stdin.  Errors are
+// returned as *WalkError.
+func walkStdin(callback func(string, os.FileInfo, io.Reader) error) error {
+
+	cr := &countingReader{r: os.Stdin}
+	if err := walkStream("<stdin>", cr, callback); err != nil {
+		if _, ok := err.(*WalkError); ok {
+			return err
+		}
+		return &WalkError{"<stdin>", cr.n, err}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+
+	first := "@type network-status-consensus-3 1.0\nvalid-after 2015-08-01 00:00:00\n"
+	second := "@type network-status-consensus-3 1.0\nvalid-after 2015-08-01 01:00:00\n"
+
+	var names, docs []string
+	var offsets []int64
+	callback := func(name string, info os.FileInfo, r io.Reader) error {
+		blurb, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !IsStreamDocument(info) {
+			t.Errorf("Expected stream document info for %s.", name)
+		}
+		names = append(names, name)
+		docs = append(docs, string(blurb))
+		offsets = append(offsets, FileOffset(info))
+		return nil
+	}
+
+	// Gzip-compressed streams are decompressed first.
+	stream := gzipBytes(t, []byte(first+second))
+	if err := walkStream("<stdin>", bytes.NewReader(stream), callback); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(docs) != 2 {
+		t.Fatalf("Expected 2 documents but got %d.", len(docs))
+	}
+	if names[0] != "<stdin>#1" || names[1] != "<stdin>#2" {
+		t.Errorf("Got document names %s.", names)
+	}
+	if docs[0] != first || docs[1] != second {
+		t.Errorf("Split documents wrongly: %q", docs)
+	}
+	if offsets[0] != 0 || offsets[1] != int64(len(first)) {
+		t.Errorf("Got document offsets %v.", offsets)
+	}
+}
+
+func TestSplitDocumentsWithoutAnnotation(t *testing.T) {
+
+	var docs []string
+	err := splitDocuments("pipe", strings.NewReader("foo\nbar"), func(name string, info os.FileInfo, r io.Reader) error {
+		blurb, _ := ioutil.ReadAll(r)
+		docs = append(docs, string(blurb))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(docs) != 1 || docs[0] != "foo\nbar" {
+		t.Errorf("Expected the whole stream as one document but got %q.", docs)
+	}
+}
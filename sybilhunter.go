@@ -12,7 +12,7 @@ import (
 	"os"
 	"os/user"
 	"path"
-	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +27,20 @@ const (
 	configFile    = ".sybilhunterrc"
 	longCSVFormat = "long"
 	wideCSVFormat = "wide"
+
+	// Policies for gaps in the consensuses of the churn analysis.
+	skipGaps        = "skip"
+	normaliseGaps   = "normalise"
+	interpolateGaps = "interpolate"
+
+	// Churn metrics that -threshold applies to in the churn analysis.
+	countMetric     = "count"
+	bandwidthMetric = "bandwidth"
+	eitherMetric    = "either"
+
+	// Ways of detecting churn spikes.
+	thresholdDetector = "threshold"
+	adaptiveDetector  = "adaptive"
 )
 
 // Files for manual analysis are written to this directory.
@@ -38,19 +52,33 @@ type CmdLineParams struct {
 	BwFraction     float64
 	Neighbours     int
 	WindowSize     int
+	DescCacheSize  int
+	ReorderSize    int
+	Workers        int
 	Uptime         bool
 	Contrib        bool
 	Churn          bool
 	PrintFiles     bool
 	PrintSome      bool
 	Fingerprints   bool
+	Inventory      bool
+	BwHistory      bool
+	Votes          bool
+	ExitAddrs      bool
+	BwRatio        bool
 	Matrix         bool
 	ShowVersion    bool
 	Visualise      bool
 	Cumulative     bool
+	Follow         bool
+	Resume         bool
 	NoFamily       bool
+	Strict         bool
+	WriteReport    bool
 	DescriptorDir  string
+	MicrodescDir   string
 	ArchiveData    string
+	ArchivePaths   []string
 	InputData      string
 	OutputDir      string
 	StartDate      time.Time
@@ -58,14 +86,32 @@ type CmdLineParams struct {
 	StartDateStr   string
 	EndDateStr     string
 	ReferenceRelay string
+	CacheDir       string
+	CachePrune     time.Duration
+	FollowInterval time.Duration
+	CheckpointInt  time.Duration
+	CacheInfo      bool
+	CacheClear     bool
 	LogFile        string
 	SearchAlg      string
 	CSVFormat      string
-
+	ChurnGaps      string
+	ChurnMetric    string
+	ChurnDetector  string
+	BaselineDays   int
+	ChurnRecords   string
+	Investigate    bool
+	ChurnFlags     string
+
+	Cache          *ObjectCache
+	Checkpoint     *Checkpoint
+	Descriptors    *DescriptorStore
+	Microdescs     *MicrodescStore
 	Filter         *tor.ObjectFilter
 	FilterFpr      string
 	FilterAddr     string
 	FilterNickname string
+	FlagExprs      []*FlagExpr
 
 	// Callbacks holds a slice of analysis functions that are called for parsed
 	// data objects.
@@ -85,8 +131,17 @@ func ParseFlagSet(arguments []string, params *CmdLineParams) *CmdLineParams {
 		params.BwFraction = -1
 		params.Neighbours = -1
 		params.WindowSize = 1
+		params.ReorderSize = 48
+		params.DescCacheSize = 10000
+		params.Workers = runtime.NumCPU()
+		params.FollowInterval = time.Minute
 		params.SearchAlg = "linear"
 		params.CSVFormat = longCSVFormat
+		params.ChurnGaps = skipGaps
+		params.ChurnMetric = countMetric
+		params.ChurnDetector = thresholdDetector
+		params.BaselineDays = 7
+		params.ChurnRecords = csvRecords
 		params.Filter = tor.NewObjectFilter()
 	}
 
@@ -95,30 +150,56 @@ func ParseFlagSet(arguments []string, params *CmdLineParams) *CmdLineParams {
 	flags.Float64Var(&params.BwFraction, "bwfraction", params.BwFraction, "Print which relays amount to the given total bandwidth fraction.")
 	flags.IntVar(&params.Neighbours, "neighbours", params.Neighbours, "Find n nearest neighbours.")
 	flags.IntVar(&params.WindowSize, "windowsize", params.WindowSize, "Window size for moving average (default is 1).")
+	flags.IntVar(&params.ReorderSize, "reorder", params.ReorderSize, "Number of parsed files per document type to buffer, so they can be delivered in chronological order (default is 48, 0 disables reordering).")
+	flags.IntVar(&params.Workers, "workers", params.Workers, "Number of files to parse in parallel (default is the number of CPUs).")
 	flags.BoolVar(&params.Uptime, "uptime", params.Uptime, "Create relay uptime visualisation.  Use -input for output file name.")
 	flags.BoolVar(&params.Contrib, "contrib", params.Contrib, "Determine the bandwidth contribution of relays in the given IP address blocks.")
 	flags.BoolVar(&params.Churn, "churn", params.Churn, "Determine churn rate of given set of consensuses.  Requires -threshold parameter.")
 	flags.BoolVar(&params.PrintFiles, "print", params.PrintFiles, "Print the content of all files in the given file or directory.")
 	flags.BoolVar(&params.PrintSome, "printsome", params.PrintSome, "Print the content of all files in the given file or directory that contain the given fingerprints.  Requires -input parameter.")
 	flags.BoolVar(&params.Fingerprints, "fingerprints", params.Fingerprints, "Analyse relay fingerprints in the given file or directory.")
+	flags.BoolVar(&params.Inventory, "inventory", params.Inventory, "Report document types, covered time span, missing consensus hours, duplicates, and unconventional file names in the given data.")
+	flags.BoolVar(&params.BwHistory, "bwhistory", params.BwHistory, "Find relays with near-identical bandwidth histories in extra-info descriptors, and relays whose history contradicts their consensus bandwidth.  Use -threshold to set the minimum correlation (default is 0.95).")
+	flags.BoolVar(&params.Votes, "votes", params.Votes, "Report which authorities listed each relay in their votes, and which flags and measured bandwidth they assigned.  Highlights relays that authorities disagree on.")
+	flags.BoolVar(&params.ExitAddrs, "exitaddrs", params.ExitAddrs, "Find relays whose exit traffic leaves from an address other than their OR address, and relays that share an exit address.  Requires exit lists and consensuses.")
+	flags.BoolVar(&params.BwRatio, "bwratio", params.BwRatio, "Track the ratio between measured and advertised bandwidth in bandwidth files, and report outliers and coordinated jumps.  Use -threshold to set the minimum outlier score (default is 3.5).")
 	flags.BoolVar(&params.Matrix, "matrix", params.Matrix, "Calculate O(n^2) similarity matrix for all objects in the given file or directory.")
 	flags.BoolVar(&params.ShowVersion, "version", params.ShowVersion, "Show version and exit.")
 	flags.BoolVar(&params.Visualise, "visualise", params.Visualise, "Write DOT code to stdout, that can then be turned into a diagram using Graphviz.")
 	flags.BoolVar(&params.Cumulative, "cumulative", params.Cumulative, "Accumulate all files in a directory rather than process them independently.")
+	flags.BoolVar(&params.Follow, "follow", params.Follow, "Keep watching -data for new files after processing the existing ones, and pass them on to the running analyses.")
+	flags.DurationVar(&params.FollowInterval, "follow-interval", params.FollowInterval, "How often -follow looks for new files (default is 1m).")
+	flags.DurationVar(&params.CheckpointInt, "checkpoint", params.CheckpointInt, "Save the state of the churn, uptime, and fingerprint analyses to the output directory at the given interval, e.g., 10m.  Requires -output parameter.")
+	flags.BoolVar(&params.Resume, "resume", params.Resume, "Resume analyses from the checkpoint in the output directory, and skip data that was already processed.  Requires -output parameter.")
 	flags.BoolVar(&params.NoFamily, "nofamily", params.NoFamily, "Don't interpret MyFamily relationships as Sybils.")
-	flags.StringVar(&params.DescriptorDir, "descdir", params.DescriptorDir, "Path to directory containing router descriptors.")
-	flags.StringVar(&params.ArchiveData, "data", params.ArchiveData, "File or directory to analyse.  It must contain network statuses or relay descriptors.")
+	flags.BoolVar(&params.Strict, "strict", params.Strict, "Abort on the first file that cannot be read or parsed, instead of skipping it.")
+	flags.BoolVar(&params.WriteReport, "report", params.WriteReport, "Write a JSON report of parsed and skipped files to the output directory.")
+	flags.StringVar(&params.DescriptorDir, "descdir", params.DescriptorDir, "Path to directory containing router descriptors.  It can contain files, directories, and tarballs.")
+	flags.StringVar(&params.MicrodescDir, "microdescdir", params.MicrodescDir, "Path to directory containing microdescriptors.  It can contain files, directories, and tarballs.")
+	flags.IntVar(&params.DescCacheSize, "desccache", params.DescCacheSize, "Number of parsed router descriptors to keep in memory (default is 10000).")
+	flags.StringVar(&params.ArchiveData, "data", params.ArchiveData, "Files, directories, tarballs, or glob patterns to analyse.  Use ',' as delimiter when multiple are given.  Use '-' to read a stream of @type-annotated documents from stdin.  They must contain network statuses or relay descriptors.")
 	flags.StringVar(&params.InputData, "input", params.InputData, "File or directory to analyse.  It must contain network statuses or relay descriptors.")
 	flags.StringVar(&params.OutputDir, "output", params.OutputDir, "Directory where analysis results are written to.")
 	flags.StringVar(&params.ReferenceRelay, "referencerelay", params.ReferenceRelay, "Relay that's used as reference for nearest neighbour search.")
-	flags.StringVar(&params.StartDateStr, "startdate", params.StartDateStr, "Start date for analyzed data in format YYYY-MM-DD.")
-	flags.StringVar(&params.EndDateStr, "enddate", params.EndDateStr, "End date for analyzed data in format YYYY-MM-DD.")
+	flags.StringVar(&params.StartDateStr, "startdate", params.StartDateStr, "Start date for analyzed data in format YYYY-MM-DD, or an RFC 3339 timestamp such as 2015-08-01T06:00:00Z.  The start date is inclusive.")
+	flags.StringVar(&params.EndDateStr, "enddate", params.EndDateStr, "End date for analyzed data in format YYYY-MM-DD, or an RFC 3339 timestamp such as 2015-08-01T12:00:00Z.  The end date is inclusive.")
 	flags.StringVar(&params.FilterFpr, "filter-fpr", params.FilterFpr, "Filter router statuses and descriptors by fingerprint.  Use ',' as delimiter when multiple fingerprints are given.")
 	flags.StringVar(&params.FilterAddr, "filter-addr", params.FilterAddr, "Filter router statuses and descriptors by IP address.  Use ',' as delimiter when multiple addresses are given.")
 	flags.StringVar(&params.FilterNickname, "filter-nickname", params.FilterNickname, "Filter router statuses and descriptors by nickname.  Use ',' as delimiter when multiple nicknames are given.")
+	flags.StringVar(&params.CacheDir, "cache", params.CacheDir, "Directory in which parsed files are cached, so subsequent runs don't have to parse them again.")
+	flags.BoolVar(&params.CacheInfo, "cache-info", params.CacheInfo, "Print a summary of the cache's content.  Requires -cache parameter.")
+	flags.BoolVar(&params.CacheClear, "cache-clear", params.CacheClear, "Invalidate the cache by removing all entries.  Requires -cache parameter.")
+	flags.DurationVar(&params.CachePrune, "cache-prune", params.CachePrune, "Remove cache entries that weren't used for the given duration, e.g., 720h.  Requires -cache parameter.")
 	flags.StringVar(&params.LogFile, "logfile", params.LogFile, "Log file to write log messages to.")
 	flags.StringVar(&params.SearchAlg, "search", params.SearchAlg, "Search algorithm to use.  Must be 'vptree' or 'linear'.  Default is 'linear'.")
 	flags.StringVar(&params.CSVFormat, "csvformat", params.CSVFormat, "Must be either 'long' or 'wide'.  Default is 'long'.")
+	flags.StringVar(&params.ChurnGaps, "churn-gaps", params.ChurnGaps, "How -churn handles missing consensuses.  'skip' starts over after the gap, 'normalise' compares across the gap and normalises churn per hour, and 'interpolate' also fills the gap with the normalised churn.  Default is 'skip'.")
+	flags.StringVar(&params.ChurnMetric, "churn-metric", params.ChurnMetric, "Churn metric that -threshold applies to in -churn.  'count' uses the fraction of relays, 'bandwidth' the fraction of consensus weight, and 'either' dumps relays if either of them exceeds the threshold.  Default is 'count'.")
+	flags.StringVar(&params.ChurnDetector, "churn-detector", params.ChurnDetector, "How -churn detects spikes.  'threshold' compares churn to -threshold, and 'adaptive' compares it to per-flag baselines and uses -threshold as minimum score.  Default is 'threshold'.")
+	flags.IntVar(&params.BaselineDays, "baseline-days", params.BaselineDays, "Number of days that the baselines of '-churn-detector adaptive' cover (default is 7).")
+	flags.StringVar(&params.ChurnRecords, "churn-records", params.ChurnRecords, "Format of the relays that -churn writes to the output directory for every spike.  Must be either 'csv' or 'json'.  Default is 'csv'.")
+	flags.BoolVar(&params.Investigate, "investigate", params.Investigate, "Check if the relays that joined in a churn spike look alike, and write the result to the output directory.  Compares descriptors if -descdir is given.")
+	flags.StringVar(&params.ChurnFlags, "churn-flags", params.ChurnFlags, "Comma-separated list of named flag expressions that -churn determines churn for, e.g., 'GuardExit=Guard+Exit,HSDirOnly=HSDir+!Guard'.  '+' requires all flags, '!' negates a flag, and '|' separates alternatives.")
 
 	err := flags.Parse(arguments)
 	if err != nil {
@@ -129,11 +210,23 @@ func ParseFlagSet(arguments []string, params *CmdLineParams) *CmdLineParams {
 }
 
 // parseDate extracts and returns the date that is in the given date string.
-func parseDate(dateString string) time.Time {
+// We accept whole days in the format YYYY-MM-DD, and RFC 3339 timestamps.  If
+// endOfDay is true, whole days are turned into the last instant of the day, so
+// that the day is part of an inclusive date range.
+func parseDate(dateString string, endOfDay bool) time.Time {
 
-	date, err := time.Parse("2006-01-02", dateString)
+	date, err := time.Parse(time.RFC3339, dateString)
+	if err == nil {
+		return date
+	}
+
+	date, err = time.Parse("2006-01-02", dateString)
 	if err != nil {
-		log.Fatalf("Given date \"%s\" invalid.  We expect the format YYYY-MM-DD.\n", dateString)
+		log.Fatalf("Given date \"%s\" invalid.  We expect the format YYYY-MM-DD or an RFC 3339 timestamp such as 2006-01-02T15:04:05Z.\n", dateString)
+	}
+
+	if endOfDay {
+		date = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
 	}
 
 	return date
@@ -181,13 +274,54 @@ func ParseConfig() *CmdLineParams {
 func setNonPrimitiveParams(params *CmdLineParams) {
 
 	if params.StartDateStr != "" {
-		params.StartDate = parseDate(params.StartDateStr)
+		params.StartDate = parseDate(params.StartDateStr, false)
 	}
 
 	if params.EndDateStr != "" {
-		params.EndDate = parseDate(params.EndDateStr)
-	} else {
-		params.EndDate = time.Now()
+		params.EndDate = parseDate(params.EndDateStr, true)
+	}
+
+	if params.OutputDir != "" {
+		outputDir = params.OutputDir
+	}
+
+	if params.Follow && params.Cumulative {
+		log.Fatalln("Cannot use -follow together with -cumulative.")
+	}
+
+	if params.CheckpointInt > 0 || params.Resume {
+		if params.OutputDir == "" {
+			log.Fatalln("-checkpoint and -resume require -output, so we know where to find the checkpoint.")
+		}
+		params.Checkpoint = NewCheckpoint(path.Join(params.OutputDir, checkpointFile), params.CheckpointInt)
+	}
+
+	if params.Resume {
+		if err := params.Checkpoint.Load(); err != nil {
+			log.Fatalf("Could not load checkpoint: %s\n", err)
+		}
+		// Skip files and object sets that all analyses already processed.
+		if resumeTime := params.Checkpoint.ResumeTime(); !resumeTime.IsZero() && !resumeTime.Before(params.StartDate) {
+			params.StartDate = resumeTime.Add(time.Nanosecond)
+			log.Printf("Skipping data up to %s because it was already processed.\n", resumeTime.Format(time.RFC3339))
+		}
+	}
+
+	if params.ArchiveData != "" {
+		paths, err := ExpandArchivePaths(params.ArchiveData)
+		if err != nil {
+			log.Fatalf("Could not expand -data argument: %s\n", err)
+		}
+		params.ArchivePaths = paths
+		log.Printf("Processing %d data path(s): %s\n", len(paths), paths)
+	}
+
+	if params.DescriptorDir != "" {
+		params.Descriptors = NewDescriptorStore(params.DescriptorDir, params.DescCacheSize)
+	}
+
+	if params.MicrodescDir != "" {
+		params.Microdescs = NewMicrodescStore(params.MicrodescDir)
 	}
 
 	if params.FilterFpr != "" {
@@ -244,6 +378,21 @@ func main() {
 		log.Printf("Using log file %q.\n", params.LogFile)
 	}
 
+	if params.CacheDir != "" {
+		cache, err := NewObjectCache(params.CacheDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		params.Cache = cache
+		log.Printf("Using cache directory \"%s\".\n", params.CacheDir)
+	} else if params.CacheInfo || params.CacheClear || params.CachePrune != 0 {
+		log.Fatalln("No cache directory given.  Please use the -cache switch.")
+	}
+
+	if RunCacheCommands(params) && params.ArchiveData == "" {
+		os.Exit(0)
+	}
+
 	if params.ArchiveData == "" {
 		log.Fatalln("No file or directory given.  Please use the -data switch.")
 	}
@@ -307,147 +456,173 @@ func main() {
 		params.Callbacks = append(params.Callbacks, FindFastRelays)
 	}
 
+	if params.BwHistory {
+		if params.DescriptorDir == "" {
+			log.Println("You might want to use -descdir to compare the router descriptors of relays with similar bandwidth histories.")
+		}
+		params.Callbacks = append(params.Callbacks, AnalyseBandwidthHistories)
+	}
+
+	if params.Votes {
+		params.Callbacks = append(params.Callbacks, AnalyseVotes)
+	}
+
+	if params.ExitAddrs {
+		params.Callbacks = append(params.Callbacks, AnalyseExitAddresses)
+	}
+
+	if params.BwRatio {
+		params.Callbacks = append(params.Callbacks, AnalyseBandwidthRatios)
+	}
+
 	if params.CSVFormat != longCSVFormat && params.CSVFormat != wideCSVFormat {
 		log.Fatalf("Parameter 'csvformat' must be either '%s' or '%s', but is '%s'.", longCSVFormat, wideCSVFormat, params.CSVFormat)
 	}
 
-	if len(params.Callbacks) == 0 {
-		log.Fatalln("No command given.  Please use -print, -printsome, -fingerprint, -matrix, -neighbours, -bwfraction, or -churn.")
+	if params.ChurnGaps != skipGaps && params.ChurnGaps != normaliseGaps && params.ChurnGaps != interpolateGaps {
+		log.Fatalf("Parameter 'churn-gaps' must be '%s', '%s', or '%s', but is '%s'.", skipGaps, normaliseGaps, interpolateGaps, params.ChurnGaps)
 	}
 
-	if err := ParseFiles(params); err != nil {
-		log.Fatal(err)
+	if params.ChurnMetric != countMetric && params.ChurnMetric != bandwidthMetric && params.ChurnMetric != eitherMetric {
+		log.Fatalf("Parameter 'churn-metric' must be '%s', '%s', or '%s', but is '%s'.", countMetric, bandwidthMetric, eitherMetric, params.ChurnMetric)
 	}
-}
 
-// fileInRange tries to extract the time that's part of consensus file names,
-// e.g., 2015-07-31-15-00-00-consensus.  If the time is in the given date
-// range, it returns true, otherwise false.  By trusting that the file name
-// contains a time stamp (and all consensus files from CollecTor do), we can
-// discard irrelevant files significantly faster than by parsing their content.
-func fileInRange(fileName string, startDate, endDate time.Time) bool {
+	if params.ChurnDetector != thresholdDetector && params.ChurnDetector != adaptiveDetector {
+		log.Fatalf("Parameter 'churn-detector' must be either '%s' or '%s', but is '%s'.", thresholdDetector, adaptiveDetector, params.ChurnDetector)
+	}
 
-	date, err := time.Parse("2006-01-02-15-04-05-consensus", path.Base(fileName))
-	if err != nil {
-		// Parse the file if we are unable to extract the timestamp.
-		return true
+	if params.ChurnRecords != csvRecords && params.ChurnRecords != jsonRecords {
+		log.Fatalf("Parameter 'churn-records' must be either '%s' or '%s', but is '%s'.", csvRecords, jsonRecords, params.ChurnRecords)
 	}
 
-	return date.After(startDate) && date.Before(endDate)
+	if params.ChurnFlags != "" {
+		exprs, err := ParseFlagExprs(params.ChurnFlags)
+		if err != nil {
+			log.Fatalf("Could not parse -churn-flags: %s\n", err)
+		}
+		params.FlagExprs = exprs
+	}
+
+	if params.BaselineDays < 1 {
+		log.Fatalf("Parameter 'baseline-days' must be at least 1, but is %d.", params.BaselineDays)
+	}
+
+	if params.Inventory {
+		if err := TakeInventory(params); err != nil {
+			log.Fatal(err)
+		}
+		if len(params.Callbacks) == 0 {
+			return
+		}
+	}
+
+	if len(params.Callbacks) == 0 {
+		log.Fatalln("No command given.  Please use -print, -printsome, -fingerprint, -matrix, -neighbours, -bwfraction, -bwhistory, -votes, -exitaddrs, -bwratio, -churn, or -inventory.")
+	}
+
+	if err := ParseFiles(params); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // GatherObjects returns a callback function that gathers data objects from a
-// file, directory, or tarball.  If the given object set pointer is not nil, it
-// is used to accumulate objects.  If the given channels are not nil,
-// GatherObjects sends the gathered data objects over the channels instead of
-// accumulating them.
-func GatherObjects(objs *tor.ObjectSet, channels []chan tor.ObjectSet, params *CmdLineParams) func(string, os.FileInfo, io.Reader) error {
+// file, directory, or tarball.  Files in the desired date range are submitted
+// to the given parser pool.  In strict mode, the callback returns the first
+// error recorded in the given report, which aborts the walk.  The walk is also
+// aborted once the given interrupter caught SIGINT.
+func GatherObjects(pool *ParserPool, report *IngestionReport, interrupter *Interrupter, params *CmdLineParams) func(string, os.FileInfo, io.Reader) error {
 
 	return func(path string, info os.FileInfo, r io.Reader) error {
 
-		if info.IsDir() {
-			return nil
+		if err := report.Err(); err != nil {
+			return err
 		}
 
-		if !fileInRange(path, params.StartDate, params.EndDate) {
-			log.Printf("File %s not in desired date range.\n", path)
-			return nil
+		if interrupter.Interrupted() {
+			return errInterrupted
 		}
 
-		objects, err := tor.ParseUnknown(r)
-		if err != nil {
-			log.Println(err)
+		if info.IsDir() {
 			return nil
 		}
 
-		if channels != nil {
-			// Processing independently.
-			for _, channel := range channels {
-				if objects != nil {
-					channel <- objects
-				}
-			}
-		} else {
-			// Processing cumulatively.
-			if *objs == nil {
-				*objs = objects
-			} else {
-				(*objs).Merge(objects)
-			}
+		if !fileInRange(path, params.StartDate, params.EndDate) {
+			log.Printf("File %s not in desired date range.\n", path)
+			report.AddOutOfRange()
+			return nil
 		}
 
-		return nil
+		return pool.Submit(path, info, r)
 	}
 }
 
-// Walk over the entries of path, open each one, and pass it to callback.
-func walkPath(path string, callback func(string, os.FileInfo, io.Reader) error) error {
+// CollectObjects returns a sink function for a parser pool.  If the given
+// object set pointer is not nil, it is used to accumulate objects.  If the
+// given reorder buffer is not nil, CollectObjects pushes the parsed data
+// objects into the buffer instead of accumulating them.  Objects outside of
+// the desired date range are discarded.  Parsed and skipped files are recorded
+// in the given report.
+func CollectObjects(objs *tor.ObjectSet, buffer *ReorderBuffer, report *IngestionReport, params *CmdLineParams) func(string, os.FileInfo, tor.ObjectSet, error) {
+
+	return func(path string, info os.FileInfo, objects tor.ObjectSet, err error) {
 
-	// callback expects an already opened file.
-	walkFn := func(path string, info os.FileInfo, err error) error {
-		fd, err := os.Open(path)
 		if err != nil {
-			return err
+			report.AddSkipped(path, FileOffset(info), err)
+			return
 		}
-		defer fd.Close()
 
-		return callback(path, info, fd)
-	}
-
-	return filepath.Walk(path, walkFn)
-}
-
-// Walk over the entries of the named tar.xz file and pass them to callback.
-//
-// Unlike filepath.Walk, this function does not visit directory entries in
-// lexicographic order, rather the order they appear in the tar file.
-func walkTarXZFile(path string, callback func(string, os.FileInfo, io.Reader) error) error {
-
-	fd, t, err := openTarXZFile(path)
-	if err != nil {
-		return err
-	}
-	defer fd.Close()
-
-	for {
-		header, err := t.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
+		if objects == nil {
+			return
 		}
+		report.AddParsed(objects)
+		SetBandwidthSource(objects, path)
 
-		err = callback(header.Name, header.FileInfo(), t)
-		if err != nil {
-			return err
+		objects = FilterObjectSet(objects, params.StartDate, params.EndDate)
+		if objects == nil {
+			report.AddOutOfRange()
+			return
 		}
-	}
-
-	return nil
-}
 
-// Walk over the entries of the given path. The treatment depends on the given
-// path: if it ends in ".tar.xz" it is passed to walkTarXZFile; otherwise it is
-// passed to walkPath.
-func walkArchiveData(path string, callback func(string, os.FileInfo, io.Reader) error) error {
+		// Don't pass on any more data once we're about to abort.
+		if report.Err() != nil {
+			return
+		}
 
-	if strings.HasSuffix(path, ".tar.xz") {
-		return walkTarXZFile(path, callback)
-	} else {
-		return walkPath(path, callback)
+		if buffer != nil {
+			// Processing independently.
+			buffer.Push(objects)
+		} else {
+			// Processing cumulatively.
+			if *objs == nil {
+				*objs = objects
+			} else {
+				(*objs).Merge(objects)
+			}
+		}
 	}
 }
 
 // ParseFiles parses the given directory or files and passes the parsed data to
 // the given analysis functions.  ParseFiles then waits for all these functions
-// to finish processing.
+// to finish processing.  In follow mode, ParseFiles keeps passing on new files
+// and only returns if an error occurs.  EndOfBatch then tells the analyses
+// that a batch of files was passed on.  If the user interrupts us, we stop
+// reading files, but still pass on what we parsed so far, so analyses can
+// report partial results.
 func ParseFiles(params *CmdLineParams) error {
 
+	var err error
 	var objs tor.ObjectSet
 	var channels []chan tor.ObjectSet
 	var group sync.WaitGroup
 	group.Add(len(params.Callbacks))
 
+	report := NewIngestionReport(params.Strict)
+	defer writeReport(report, params)
+
+	interrupter := NewInterrupter()
+	defer interrupter.Stop()
+
 	// Create a channel for and invoke all callback functions.
 	for _, analysisFunc := range params.Callbacks {
 		channel := make(chan tor.ObjectSet)
@@ -458,7 +633,15 @@ func ParseFiles(params *CmdLineParams) error {
 
 	if params.Cumulative {
 		log.Printf("Processing \"%s\" cumulatively.\n", params.ArchiveData)
-		walkArchiveData(params.ArchiveData, GatherObjects(&objs, nil, params))
+		pool := NewParserPool(params.Workers, params.Cache, CollectObjects(&objs, nil, report, params), report.OnParseError)
+		err = walkArchiveData(params.ArchivePaths, GatherObjects(pool, report, interrupter, params), report.OnWalkError, nil)
+		pool.Close()
+		if err == nil {
+			err = report.Err()
+		}
+		if err != nil && err != errInterrupted {
+			return err
+		}
 
 		if objs == nil {
 			return errors.New("Gathered object set empty.  Are we parsing the right files?")
@@ -470,7 +653,40 @@ func ParseFiles(params *CmdLineParams) error {
 		}
 	} else {
 		log.Printf("Processing \"%s\" independently.\n", params.ArchiveData)
-		walkArchiveData(params.ArchiveData, GatherObjects(nil, channels, params))
+
+		// Send object sets to all callback functions in chronological order.
+		buffer := NewReorderBuffer(params.ReorderSize, func(objects tor.ObjectSet) {
+			for _, channel := range channels {
+				channel <- objects
+			}
+		})
+
+		// Remember the state in which we read every file, so that -follow
+		// picks up files that were created or modified after we read them.
+		var visited func(string, os.FileInfo)
+		walked := make(map[string]os.FileInfo)
+		if params.Follow {
+			visited = func(path string, info os.FileInfo) {
+				walked[path] = info
+			}
+		}
+
+		pool := NewParserPool(params.Workers, params.Cache, CollectObjects(nil, buffer, report, params), report.OnParseError)
+		err = walkArchiveData(params.ArchivePaths, GatherObjects(pool, report, interrupter, params), report.OnWalkError, visited)
+		pool.Close()
+		if err == nil {
+			err = report.Err()
+		}
+		if err == nil || err == errInterrupted {
+			buffer.Flush()
+		}
+
+		if err == nil && params.Follow {
+			for _, channel := range channels {
+				channel <- EndOfBatch
+			}
+			err = followArchiveData(walked, buffer, report, interrupter, channels, params)
+		}
 	}
 
 	// Close processing channels and wait for goroutines to finish.
@@ -479,5 +695,5 @@ func ParseFiles(params *CmdLineParams) error {
 	}
 	group.Wait()
 
-	return nil
+	return err
 }
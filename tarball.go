@@ -2,49 +2,102 @@ package main
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
+
+	xz "github.com/ulikunitz/xz"
 )
 
-// Decompress the given io.Reader and return a new io.ReadCloser containing the
+// Decompress the given io.Reader and return a new io.Reader containing the
 // decompressed output.
 //
-// This function requires the xz command to be installed.
-func xzReader(r io.Reader) (io.ReadCloser, error) {
+// Decoding happens in-process.  Corrupt blocks, checksum mismatches, and
+// truncated input are reported as errors by the returned reader's Read
+// method.
+func xzReader(r io.Reader) (io.Reader, error) {
 
-	cmd := exec.Command("xz", "--decompress", "--stdout")
-	cmd.Stdin = r
+	return xz.NewReader(r)
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
+// Walk over the entries of the given tar stream and pass them to callback.
+// Entries that are archives or compressed files themselves are walked
+// recursively.
+//
+// Unlike filepath.Walk, this function does not visit directory entries in
+// lexicographic order, rather the order they appear in the tar file.
+func walkTar(r io.Reader, callback func(string, os.FileInfo, io.Reader) error) error {
 
-	err = cmd.Start()
-	if err != nil {
-		return nil, err
+	// The tar reader reads whole blocks without buffering, so the number of
+	// bytes read so far tells us where an entry's content starts.
+	cr := &countingReader{r: r}
+	t := tar.NewReader(cr)
+
+	for {
+		header, err := t.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		info := &archiveEntryInfo{header.FileInfo(), cr.n}
+		if info.IsDir() {
+			err = callback(header.Name, info, t)
+		} else {
+			err = walkReader(header.Name, info, t, callback)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	return stdout, nil
+	return nil
 }
 
-// Open an xz-compressed tar file.
-//
-// Returns the underlying tar.xz file descriptor (so you can close it), as well
-// as a *tar.Reader over the decompressed file contents.
-func openTarXZFile(fileName string) (*os.File, *tar.Reader, error) {
+// Walk over the entries of the given zip archive and pass them to callback.
+// Entries that are archives or compressed files themselves are walked
+// recursively.
+func walkZip(r io.ReaderAt, size int64, callback func(string, os.FileInfo, io.Reader) error) error {
 
-	fd, err := os.Open(fileName)
+	z, err := zip.NewReader(r, size)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+
+	for _, file := range z.File {
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+
+		offset, _ := file.DataOffset()
+		info := &archiveEntryInfo{file.FileInfo(), offset}
+		if info.IsDir() {
+			err = callback(file.Name, info, rc)
+		} else {
+			err = walkReader(file.Name, info, rc, callback)
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
 	}
 
-	xzr, err := xzReader(fd)
+	return nil
+}
+
+// Walk over the entries of a zip archive that we can only read sequentially,
+// e.g., because it's nested in a tarball.  The archive is buffered in memory
+// because the zip format keeps its directory at the end of the file.
+func walkZipStream(r io.Reader, callback func(string, os.FileInfo, io.Reader) error) error {
+
+	blurb, err := ioutil.ReadAll(r)
 	if err != nil {
-		fd.Close()
-		return nil, nil, err
+		return err
 	}
 
-	return fd, tar.NewReader(xzr), nil
+	return walkZip(bytes.NewReader(blurb), int64(len(blurb)), callback)
 }
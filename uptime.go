@@ -267,6 +267,14 @@ func PruneUptimes(uptimes *Uptimes, totalConsensuses int) {
 		alwaysOnline, oldAmount, oldAmount-alwaysOnline)
 }
 
+// uptimeState is the state of the uptime analysis that we save in checkpoints.
+type uptimeState struct {
+	ForFingerprint   map[tor.Fingerprint]OnlineSequence
+	Hour             int
+	DaysPassed       int
+	TotalConsensuses int
+}
+
 // AnalyseUptimes analyses the uptime pattern of Tor relays and generates an
 // image, that should help with finding Sybils.
 func AnalyseUptimes(channel chan tor.ObjectSet, params *CmdLineParams, group *sync.WaitGroup) {
@@ -279,10 +287,46 @@ func AnalyseUptimes(channel chan tor.ObjectSet, params *CmdLineParams, group *sy
 	hour := -1
 	daysPassed := -1
 	totalConsensuses := 0
+	var lastTime time.Time
+
+	var state uptimeState
+	resumeTime := params.Checkpoint.Restore("uptime", &state)
+	if !resumeTime.IsZero() {
+		// Gob leaves out empty maps.
+		if state.ForFingerprint != nil {
+			uptimes.ForFingerprint = state.ForFingerprint
+		}
+		hour, daysPassed, totalConsensuses, lastTime = state.Hour, state.DaysPassed, state.TotalConsensuses, resumeTime
+	}
+
+	saveState := func() {
+		if params.Checkpoint != nil && totalConsensuses > 0 {
+			params.Checkpoint.Save("uptime", lastTime, &uptimeState{uptimes.ForFingerprint, hour, daysPassed, totalConsensuses})
+		}
+	}
 
 	// One loop iteration corresponds to one consensus.
 	for objects := range channel {
 
+		if alreadyProcessed(objects, resumeTime) {
+			continue
+		}
+
+		// Follow mode finished a batch, so write the image we have so far.
+		if objects == EndOfBatch {
+			if totalConsensuses > 0 {
+				writeUptimes(uptimes, totalConsensuses, params)
+			}
+			continue
+		}
+
+		// The bridge authority publishes several network statuses per hour,
+		// but every row in the image is an hour, so we only use the first.
+		if _, ok := objects.(*BridgeNetworkStatus); ok && !lastTime.IsZero() &&
+			ObjectSetTime(objects).Truncate(time.Hour).Equal(lastTime.Truncate(time.Hour)) {
+			continue
+		}
+
 		totalConsensuses++
 
 		hour = (hour + 1) % 24
@@ -304,18 +348,39 @@ func AnalyseUptimes(channel chan tor.ObjectSet, params *CmdLineParams, group *sy
 			last := len(daySeq) - 1
 			daySeq[last].MarkOnline(uint(hour))
 		}
+
+		lastTime = ObjectSetTime(objects)
+		if params.Checkpoint.Due("uptime") {
+			saveState()
+		}
 	}
 
+	// Save our state before clustering, which can take a long time.
+	saveState()
+
 	if len(uptimes.ForFingerprint) == 0 {
 		log.Fatalln("No consensuses to process.  Exiting.")
 	}
 
+	writeUptimes(uptimes, totalConsensuses, params)
+}
+
+// writeUptimes prunes and clusters the given uptimes, and writes them to an
+// image.  The given uptimes are left intact, so we can keep adding to them.
+func writeUptimes(uptimes Uptimes, totalConsensuses int, params *CmdLineParams) {
+
 	log.Printf("Processed %d consensuses, %d unique fingerprints.",
 		totalConsensuses, len(uptimes.ForFingerprint))
 
-	PruneUptimes(&uptimes, totalConsensuses)
+	pruned := Uptimes{
+		ForFingerprint: make(map[tor.Fingerprint]OnlineSequence, len(uptimes.ForFingerprint)),
+	}
+	for fpr, seq := range uptimes.ForFingerprint {
+		pruned.ForFingerprint[fpr] = seq
+	}
+	PruneUptimes(&pruned, totalConsensuses)
 
-	sortedUptimes := Cluster(&uptimes)
+	sortedUptimes := Cluster(&pruned)
 	GenImage(sortedUptimes, GetHighlights(sortedUptimes), params.InputData, totalConsensuses)
 }
 
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
+	"os"
+	"path/filepath"
 	"sort"
 	"time"
 
@@ -63,11 +66,25 @@ func getOutputDir() (string, error) {
 		}
 
 		log.Printf("Created output directory \"%s\".\n", outputDir)
+	} else if err = os.MkdirAll(outputDir, 0700); err != nil {
+		return "", err
 	}
 
 	return outputDir, nil
 }
 
+// createOutputFile creates a file with the given name in the output directory
+// which can be set by the user.  An existing file is truncated.
+func createOutputFile(fileName string) (*os.File, error) {
+
+	directory, err := getOutputDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Create(filepath.Join(directory, fileName))
+}
+
 // writeStringToFile writes the given string blurb to a randomly generated file
 // with the given string prefix.  The file is placed in the output directory
 // which can bet set by the user.
@@ -82,6 +99,7 @@ func writeStringToFile(fileName string, content string) error {
 	if err != nil {
 		return err
 	}
+	defer fd.Close()
 
 	fmt.Fprint(fd, content)
 	log.Printf("Wrote %d-byte string to \"%s\".\n", len(content), fd.Name())
@@ -125,6 +143,49 @@ func MinUInt16(a, b uint16) uint16 {
 	}
 }
 
+// MinInt returns the smaller of the two given integers.
+func MinInt(a, b int) int {
+	if a < b {
+		return a
+	} else {
+		return b
+	}
+}
+
+// median returns the median of the given sorted values.
+func median(sorted []float64) float64 {
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
+
+// medianDeviation returns the median of the given values and their median
+// absolute deviation.  The deviation is scaled, so that it estimates the
+// standard deviation of normally distributed values.  Unlike the mean and the
+// standard deviation, both are robust to the outliers that we are looking for.
+func medianDeviation(values []float64) (float64, float64) {
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	med := median(sorted)
+
+	var deviations []float64
+	for _, value := range sorted {
+		deviations = append(deviations, math.Abs(value-med))
+	}
+	sort.Float64s(deviations)
+
+	return med, 1.4826 * median(deviations)
+}
+
 // RouterFlagsToString converts a RouterFlags struct to a constant-size string
 // containing a series of bits.
 func RouterFlagsToString(flags *tor.RouterFlags) string {
@@ -0,0 +1,106 @@
+// Parses the votes that directory authorities publish before they compute a
+// consensus.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// Vote is a directory authority's vote.  Its router statuses carry the flags
+// and measured bandwidth that the authority assigned, so analyses that need
+// router statuses can use the embedded consensus.  Votes are no consensuses,
+// though, so AsConsensus ignores them.  The embedded status flags hold the
+// flags that the authority votes on, and the flags it assigned.
+type Vote struct {
+	*tor.Consensus
+	StatusFlags
+
+	Authority            string
+	AuthorityFingerprint tor.Fingerprint
+}
+
+// NewVote allocates and returns a new vote.
+func NewVote() *Vote {
+
+	return &Vote{
+		Consensus:   tor.NewConsensus(),
+		StatusFlags: newStatusFlags(),
+	}
+}
+
+// Merge merges the given object set into the vote.
+func (vote *Vote) Merge(objects tor.ObjectSet) {
+
+	other, ok := objects.(*Vote)
+	if !ok {
+		return
+	}
+
+	vote.Consensus.Merge(other.Consensus)
+	vote.StatusFlags.merge(&other.StatusFlags)
+}
+
+// parseRouterLine parses an "r" line of a consensus, a vote, or a bridge
+// network status.  It looks just like the "r" line of a microdescriptor
+// consensus, except that it also contains the digest of the relay's server
+// descriptor.
+func parseRouterLine(words []string) (*tor.RouterStatus, error) {
+
+	if len(words) != 9 {
+		return nil, fmt.Errorf("Expected 9 words in \"r\" line but got %d.", len(words))
+	}
+
+	status, err := parseMicrodescRouterLine(append([]string{words[0], words[1], words[2]}, words[4:]...))
+	if err != nil {
+		return nil, err
+	}
+
+	// base64ToFingerprint does exactly what we need for descriptor digests,
+	// which are encoded just like fingerprints.
+	digest, err := base64ToFingerprint(words[3])
+	if err != nil {
+		return nil, err
+	}
+	status.Digest = string(digest)
+
+	return status, nil
+}
+
+// ParseVote parses a directory authority's vote.
+func ParseVote(r io.Reader) (tor.ObjectSet, error) {
+
+	vote := NewVote()
+
+	err := parseStatusDocument(r, vote.Consensus, &vote.StatusFlags, parseRouterLine, func(status *tor.RouterStatus, words []string) error {
+		if words[0] != "dir-source" {
+			return nil
+		}
+		if len(words) < 3 {
+			return fmt.Errorf("Malformed \"dir-source\" line.")
+		}
+		if _, err := hex.DecodeString(words[2]); err != nil {
+			return fmt.Errorf("Malformed authority fingerprint \"%s\".", words[2])
+		}
+		vote.Authority = words[1]
+		vote.AuthorityFingerprint = tor.Fingerprint(strings.ToUpper(words[2]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if vote.ValidAfter.IsZero() {
+		return nil, fmt.Errorf("Vote has no valid-after time.")
+	}
+	if vote.Authority == "" {
+		return nil, fmt.Errorf("Vote has no \"dir-source\" line.")
+	}
+
+	return vote, nil
+}
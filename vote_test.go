@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// An excerpt of a vote by moria1.  Votes carry the measured bandwidth that
+// the authority assigned, and the "r" lines reference server descriptors.
+const voteExcerpt = `@type network-status-vote-3 1.0
+network-status-version 3
+vote-status vote
+consensus-methods 25 26 27 28
+published 2019-04-30 23:50:00
+valid-after 2019-05-01 00:00:00
+fresh-until 2019-05-01 01:00:00
+valid-until 2019-05-01 03:00:00
+voting-delay 300 300
+known-flags Authority BadExit Exit Fast Guard HSDir NoEdConsensus Running Stable StaleDesc V2Dir Valid
+dir-source moria1 D586D18309DED4CD6D57C18FDB97EFA96D330566 128.31.0.34 128.31.0.34 9131 9101
+contact 1024D/EB5A896A28988BF5 arma mit edu
+r CalyxInstitute14 ABG9JIWtRdmE7EFZyI/AZuXjMA4 g4tM4vNh0ojHEpw3V9nLDVqUGNE 2019-04-30 18:01:16 162.247.74.201 443 80
+a [2620:18c:0:192::201]:443
+s Exit Fast Guard HSDir Running Stable V2Dir Valid
+v Tor 0.3.5.8
+w Bandwidth=10200 Measured=9730
+p accept 20-23,43,53,79-81
+id ed25519 Hm+HUYRMxJ1wbDvVm3ow1ee/NhqxCFYr7Du7u8pbcAo
+m 28 sha256=JlzcRIy//VsPH1cOLKqk2KZNvaqdekF+tzKKvuoL1ng
+directory-footer
+directory-signature D586D18309DED4CD6D57C18FDB97EFA96D330566 D6FA6E4D2B11C4AC5D8CE78F4ED4FE2CE5E2E8B7
+`
+
+func TestParseVote(t *testing.T) {
+
+	objects, err := ParseVote(strings.NewReader(voteExcerpt))
+	if err != nil {
+		t.Fatalf("Could not parse vote: %s", err)
+	}
+	vote := objects.(*Vote)
+
+	if vote.Authority != "moria1" || vote.AuthorityFingerprint != "D586D18309DED4CD6D57C18FDB97EFA96D330566" {
+		t.Errorf("Got authority %s with fingerprint %s.", vote.Authority, vote.AuthorityFingerprint)
+	}
+	if !vote.ValidAfter.Equal(time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Got valid-after time %s.", vote.ValidAfter)
+	}
+
+	fpr := tor.Fingerprint("0011BD2485AD45D984EC4159C88FC066E5E3300E")
+	status, exists := vote.Get(fpr)
+	if !exists {
+		t.Fatal("Could not find CalyxInstitute14.")
+	}
+	if status.Digest != "838B4CE2F361D288C7129C3757D9CB0D5A9418D1" {
+		t.Errorf("Got descriptor digest %s.", status.Digest)
+	}
+	if status.Bandwidth != 10200 || status.Measured != 9730 {
+		t.Errorf("Got bandwidth %d and measured bandwidth %d.", status.Bandwidth, status.Measured)
+	}
+	if status.Address.IPv6Address.String() != "2620:18c:0:192::201" || status.Address.IPv6ORPort != 443 {
+		t.Errorf("Got IPv6 address %s and port %d.", status.Address.IPv6Address, status.Address.IPv6ORPort)
+	}
+
+	expected := []string{"Exit", "Fast", "Guard", "HSDir", "Running", "Stable", "V2Dir", "Valid"}
+	if !reflect.DeepEqual(vote.Flags[fpr], expected) {
+		t.Errorf("Expected flags %v but got %v.", expected, vote.Flags[fpr])
+	}
+	if !vote.KnowsFlag("StaleDesc") || vote.KnowsFlag("MiddleOnly") {
+		t.Errorf("Got known flags %v.", vote.KnownFlags)
+	}
+}
+
+func TestParseRouterLine(t *testing.T) {
+
+	tests := []struct {
+		line  string
+		valid bool
+	}{
+		{"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 9001 0", true},
+		// Microdescriptor consensuses have no descriptor digest.
+		{"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 2019-04-30 20:37:24 67.161.31.147 9001 0", false},
+		{"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 9001 0 0", false},
+		{"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw !!!! 2019-04-30 20:37:24 67.161.31.147 9001 0", false},
+		{"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 3JdIVL4ZMRM1LdQdrm4ojUsfbuA 2019-04-30 20:37:24 67.161.31.147 -1 0", false},
+	}
+
+	for _, test := range tests {
+		status, err := parseRouterLine(strings.Fields(test.line))
+		if test.valid && err != nil {
+			t.Errorf("Could not parse %q: %s", test.line, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Expected an error for %q.", test.line)
+		}
+		if test.valid && (status.Nickname != "seele" || status.Fingerprint != "000A10D43011EA4928A35F610405F92B4433B4DC" ||
+			status.Digest != "DC974854BE193113352DD41DAE6E288D4B1F6EE0" || status.Address.IPv4ORPort != 9001) {
+			t.Errorf("Parsed %q into %v.", test.line, status)
+		}
+	}
+}
+
+func TestParseVoteErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"no valid-after", "dir-source moria1 D586D18309DED4CD6D57C18FDB97EFA96D330566 128.31.0.34 128.31.0.34 9131 9101\n"},
+		{"no dir-source", "valid-after 2019-05-01 00:00:00\n"},
+		{"short dir-source", "valid-after 2019-05-01 00:00:00\ndir-source moria1\n"},
+		{"bad authority fingerprint", "valid-after 2019-05-01 00:00:00\n" +
+			"dir-source moria1 D586D18309DED4CD6D57C18FDB97EFA96D33056X 128.31.0.34 128.31.0.34 9131 9101\n"},
+		{"short r line", "valid-after 2019-05-01 00:00:00\n" +
+			"dir-source moria1 D586D18309DED4CD6D57C18FDB97EFA96D330566 128.31.0.34 128.31.0.34 9131 9101\n" +
+			"r seele AAoQ1DAR6kkoo19hBAX5K0QztNw 2019-04-30 20:37:24 67.161.31.147 9001 0\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseVote(strings.NewReader(test.document)); err == nil {
+			t.Errorf("%s: expected an error.", test.name)
+		}
+	}
+}
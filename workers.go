@@ -0,0 +1,180 @@
+// Parses files concurrently using a pool of worker goroutines.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// parseJob is a file whose content waits to be parsed, or whose object set
+// waits to be loaded from the cache.
+type parseJob struct {
+	seq    uint64
+	path   string
+	info   os.FileInfo
+	key    string
+	cached bool
+	blurb  []byte
+}
+
+// parseResult holds the outcome of a parse job.
+type parseResult struct {
+	seq     uint64
+	path    string
+	info    os.FileInfo
+	objects tor.ObjectSet
+	err     error
+}
+
+// ParserPool parses files in parallel and passes the parsed object sets to a
+// sink function in the order in which the files were submitted.  The sink is
+// only ever called from a single goroutine, so it doesn't need locking.  If
+// the pool has an object cache, parsed object sets are loaded from and stored
+// in the cache.  Errors are also passed to a fail function as soon as they
+// happen, without waiting for the files that were submitted earlier, so that
+// callers can stop submitting files right away.
+type ParserPool struct {
+	cache   *ObjectCache
+	jobs    chan *parseJob
+	results chan *parseResult
+	// Limits the number of files that are in memory at the same time.
+	inFlight chan bool
+	done     chan bool
+	workers  sync.WaitGroup
+	seq      uint64
+	sink     func(string, os.FileInfo, tor.ObjectSet, error)
+	fail     func(string, os.FileInfo, error)
+}
+
+// NewParserPool allocates a new parser pool, starts the given number of
+// workers, and returns the pool.  The given cache and fail function can be
+// nil.  The fail function is called from the workers' goroutines.
+func NewParserPool(numWorkers int, cache *ObjectCache, sink func(string, os.FileInfo, tor.ObjectSet, error), fail func(string, os.FileInfo, error)) *ParserPool {
+
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	pool := &ParserPool{
+		jobs:     make(chan *parseJob, numWorkers),
+		results:  make(chan *parseResult, numWorkers),
+		inFlight: make(chan bool, numWorkers*2),
+		done:     make(chan bool),
+		cache:    cache,
+		sink:     sink,
+		fail:     fail,
+	}
+
+	pool.workers.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go pool.work()
+	}
+	go pool.collect()
+
+	return pool
+}
+
+// work parses files until the job queue is closed.
+func (pool *ParserPool) work() {
+
+	defer pool.workers.Done()
+
+	for job := range pool.jobs {
+		objects, err := pool.parse(job)
+		if err != nil && pool.fail != nil {
+			pool.fail(job.path, job.info, err)
+		}
+		pool.results <- &parseResult{job.seq, job.path, job.info, objects, err}
+	}
+}
+
+// parse turns the given job into an object set, either by parsing the file's
+// content or by loading it from the cache.
+func (pool *ParserPool) parse(job *parseJob) (tor.ObjectSet, error) {
+
+	if job.cached {
+		return pool.cache.Load(job.key)
+	}
+
+	objects, err := ParseDocument(job.blurb)
+	if err != nil || objects == nil || job.key == "" {
+		return objects, err
+	}
+
+	if err := pool.cache.Store(job.key, job.path, job.info, objects); err != nil {
+		log.Printf("Could not cache %s: %s\n", job.path, err)
+	}
+
+	return objects, nil
+}
+
+// collect restores the submission order of parse results and passes them to
+// the sink.
+func (pool *ParserPool) collect() {
+
+	pending := make(map[uint64]*parseResult)
+	next := uint64(0)
+
+	for result := range pool.results {
+		pending[result.seq] = result
+
+		for {
+			result, exists := pending[next]
+			if !exists {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			pool.sink(result.path, result.info, result.objects, result.err)
+			<-pool.inFlight
+		}
+	}
+
+	close(pool.done)
+}
+
+// Submit reads the given file content and queues it for parsing.  If the
+// file is already in the cache, its content is not read.  Documents from
+// streams bypass the cache.  Submit blocks if too
+// many files are waiting to be parsed or delivered.
+func (pool *ParserPool) Submit(path string, info os.FileInfo, r io.Reader) error {
+
+	job := &parseJob{path: path, info: info}
+
+	if pool.cache != nil && !IsStreamDocument(info) {
+		job.key = pool.cache.Key(path, info)
+		job.cached = pool.cache.Has(job.key)
+	}
+
+	if !job.cached {
+		blurb, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		job.blurb = blurb
+	}
+
+	pool.inFlight <- true
+	job.seq = pool.seq
+	pool.jobs <- job
+	pool.seq++
+
+	return nil
+}
+
+// Close waits until all submitted files are parsed and passed to the sink.
+// The pool must not be used afterwards.
+func (pool *ParserPool) Close() {
+
+	close(pool.jobs)
+	pool.workers.Wait()
+	close(pool.results)
+	<-pool.done
+}
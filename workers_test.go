@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tor "git.torproject.org/user/phw/zoossh.git"
+)
+
+// testFileInfo implements os.FileInfo for files that only exist in tests.
+type testFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (info *testFileInfo) Name() string       { return info.name }
+func (info *testFileInfo) Size() int64        { return info.size }
+func (info *testFileInfo) Mode() os.FileMode  { return 0644 }
+func (info *testFileInfo) ModTime() time.Time { return info.modTime }
+func (info *testFileInfo) IsDir() bool        { return false }
+func (info *testFileInfo) Sys() interface{}   { return nil }
+
+func TestParserPoolKeepsOrder(t *testing.T) {
+
+	var paths []string
+	pool := NewParserPool(4, nil, func(path string, info os.FileInfo, objects tor.ObjectSet, err error) {
+		paths = append(paths, path)
+	}, nil)
+
+	var expected []string
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("file-%03d", i)
+		expected = append(expected, path)
+		info := &testFileInfo{path, int64(len(path)), testEpoch}
+		if err := pool.Submit(path, info, strings.NewReader(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Close()
+
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %d results but got %d.", len(expected), len(paths))
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Fatalf("Expected result %d to be %s but got %s.", i, expected[i], paths[i])
+		}
+	}
+}